@@ -0,0 +1,216 @@
+package bson_ext
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//MarshalExtendedJSON converts a BSON value - typically a document, as a bson.D
+//to keep the order of its fields - to MongoDB Extended JSON v2, in either its
+//canonical mode, which keeps the type of every value, or its relaxed mode,
+//which writes numbers and recent dates the way plain JSON would.
+func MarshalExtendedJSON(value interface{}, canonical bool) ([]byte, error) {
+	encoder := extendedJSONEncoder{canonical: canonical}
+	if err := encoder.encode(value); err != nil {
+		return nil, err
+	}
+	return encoder.out.Bytes(), nil
+}
+
+//jsonNumber is a number written as a plain JSON number in either mode, as
+//in {"$minKey": 1}.
+type jsonNumber int64
+
+type extendedJSONEncoder struct {
+	out       bytes.Buffer
+	canonical bool
+}
+
+//encodeWrapper writes a value in the form {"<key>": <value>}, the way
+//Extended JSON writes the values of types JSON doesn't have.
+func (encoder *extendedJSONEncoder) encodeWrapper(key string, value interface{}) error {
+	return encoder.encode(bson.D{{Name: key, Value: value}})
+}
+
+func (encoder *extendedJSONEncoder) encodeString(value string) {
+	//strings can't fail to marshal
+	data, _ := json.Marshal(value)
+	encoder.out.Write(data)
+}
+
+func (encoder *extendedJSONEncoder) encode(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		encoder.out.WriteString("null")
+	case bool:
+		encoder.out.WriteString(strconv.FormatBool(v))
+	case string:
+		encoder.encodeString(v)
+	case bson.D:
+		encoder.out.WriteByte('{')
+		for index, field := range v {
+			if index > 0 {
+				encoder.out.WriteByte(',')
+			}
+			encoder.encodeString(field.Name)
+			encoder.out.WriteByte(':')
+			if err := encoder.encode(field.Value); err != nil {
+				return err
+			}
+		}
+		encoder.out.WriteByte('}')
+	case bson.M:
+		return encoder.encode(sortedDocument(v))
+	case map[string]interface{}:
+		return encoder.encode(sortedDocument(v))
+	case []interface{}:
+		encoder.out.WriteByte('[')
+		for index, element := range v {
+			if index > 0 {
+				encoder.out.WriteByte(',')
+			}
+			if err := encoder.encode(element); err != nil {
+				return err
+			}
+		}
+		encoder.out.WriteByte(']')
+	case int:
+		//mgo encodes ints as int32 whenever they fit
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			return encoder.encode(int32(v))
+		}
+		return encoder.encode(int64(v))
+	case int32:
+		if encoder.canonical {
+			return encoder.encodeWrapper("$numberInt", strconv.Itoa(int(v)))
+		}
+		encoder.out.WriteString(strconv.Itoa(int(v)))
+	case int64:
+		if encoder.canonical {
+			return encoder.encodeWrapper("$numberLong",
+				strconv.FormatInt(v, 10))
+		}
+		encoder.out.WriteString(strconv.FormatInt(v, 10))
+	case jsonNumber:
+		encoder.out.WriteString(strconv.FormatInt(int64(v), 10))
+	case NumberLongExt:
+		return encoder.encode(int64(v))
+	case float64:
+		formatted := formatExtendedJSONDouble(v)
+		if encoder.canonical || math.IsInf(v, 0) || math.IsNaN(v) {
+			return encoder.encodeWrapper("$numberDouble", formatted)
+		}
+		encoder.out.WriteString(formatted)
+	case bson.ObjectId:
+		return encoder.encodeWrapper("$oid", v.Hex())
+	case time.Time:
+		return encoder.encodeDate(v)
+	case []byte:
+		return encoder.encodeBinary(0x00, v)
+	case bson.Binary:
+		return encoder.encodeBinary(v.Kind, v.Data)
+	case bson.RegEx:
+		return encoder.encodeWrapper("$regularExpression", bson.D{
+			{Name: "pattern", Value: v.Pattern},
+			{Name: "options", Value: sortRegexOptions(v.Options)},
+		})
+	case bson.MongoTimestamp:
+		return encoder.encodeWrapper("$timestamp", bson.D{
+			{Name: "t", Value: jsonNumber(uint64(v) >> 32)},
+			{Name: "i", Value: jsonNumber(uint32(v))},
+		})
+	case bson.JavaScript:
+		if v.Scope == nil {
+			return encoder.encodeWrapper("$code", v.Code)
+		}
+		return encoder.encode(bson.D{
+			{Name: "$code", Value: v.Code},
+			{Name: "$scope", Value: v.Scope},
+		})
+	case bson.Symbol:
+		return encoder.encodeWrapper("$symbol", string(v))
+	default:
+		switch value {
+		case bson.MinKey:
+			return encoder.encodeWrapper("$minKey", jsonNumber(1))
+		case bson.MaxKey:
+			return encoder.encodeWrapper("$maxKey", jsonNumber(1))
+		case bson.Undefined:
+			return encoder.encodeWrapper("$undefined", true)
+		}
+		return fmt.Errorf("can not convert value of type %T to extended JSON",
+			value)
+	}
+	return nil
+}
+
+//encodeDate writes a date: in relaxed mode, dates between the years 1970 and
+//9999 are written as ISO-8601 strings, and all others as milliseconds since
+//the Unix epoch.
+func (encoder *extendedJSONEncoder) encodeDate(date time.Time) error {
+	date = date.UTC()
+	if !encoder.canonical && date.Year() >= 1970 && date.Year() <= 9999 {
+		return encoder.encodeWrapper("$date",
+			date.Format("2006-01-02T15:04:05.000Z07:00"))
+	}
+	millis := date.Unix()*1000 + int64(date.Nanosecond()/1e6)
+	return encoder.encodeWrapper("$date", bson.D{
+		{Name: "$numberLong", Value: strconv.FormatInt(millis, 10)},
+	})
+}
+
+func (encoder *extendedJSONEncoder) encodeBinary(kind byte, data []byte) error {
+	return encoder.encodeWrapper("$binary", bson.D{
+		{Name: "base64", Value: base64.StdEncoding.EncodeToString(data)},
+		{Name: "subType", Value: fmt.Sprintf("%02x", kind)},
+	})
+}
+
+//formatExtendedJSONDouble formats a double so that it reads back as the same
+//double, and always as a double rather than an integer.
+func formatExtendedJSONDouble(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "Infinity"
+	case math.IsInf(value, -1):
+		return "-Infinity"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	formatted := strconv.FormatFloat(value, 'G', -1, 64)
+	if !strings.ContainsAny(formatted, ".E") {
+		formatted += ".0"
+	}
+	return formatted
+}
+
+//sortRegexOptions returns the options of a regular expression in alphabetical
+//order, as Extended JSON requires.
+func sortRegexOptions(options string) string {
+	letters := strings.Split(options, "")
+	sort.Strings(letters)
+	return strings.Join(letters, "")
+}
+
+//sortedDocument converts an unordered document to a bson.D with its fields in
+//alphabetical order, so that it is always written the same way.
+func sortedDocument(document map[string]interface{}) bson.D {
+	keys := make([]string, 0, len(document))
+	for key := range document {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	sorted := make(bson.D, 0, len(keys))
+	for _, key := range keys {
+		sorted = append(sorted, bson.DocElem{Name: key, Value: document[key]})
+	}
+	return sorted
+}
@@ -0,0 +1,101 @@
+package bson_ext
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"labix.org/v2/mgo/bson"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMarshalExtendedJSON(t *testing.T) {
+	// marshal returns the extended JSON of a value in the given mode
+	marshal := func(value interface{}, canonical bool) string {
+		out, err := MarshalExtendedJSON(value, canonical)
+		So(err, ShouldBeNil)
+		return string(out)
+	}
+
+	Convey("Numbers should keep their types in canonical mode only", t, func() {
+		document := bson.D{
+			{Name: "int32", Value: 1},
+			{Name: "int64", Value: int64(2)},
+			{Name: "double", Value: 3.0},
+			{Name: "fraction", Value: -0.5},
+			{Name: "big", Value: 1.2345678921232e18},
+		}
+		So(marshal(document, true), ShouldEqual, `{"int32":{"$numberInt":"1"},`+
+			`"int64":{"$numberLong":"2"},"double":{"$numberDouble":"3.0"},`+
+			`"fraction":{"$numberDouble":"-0.5"},`+
+			`"big":{"$numberDouble":"1.2345678921232E+18"}}`)
+		So(marshal(document, false), ShouldEqual, `{"int32":1,"int64":2,`+
+			`"double":3.0,"fraction":-0.5,"big":1.2345678921232E+18}`)
+	})
+
+	Convey("Doubles that JSON can't represent should always be wrapped", t,
+		func() {
+			for value, expected := range map[float64]string{
+				math.Inf(1):  `{"$numberDouble":"Infinity"}`,
+				math.Inf(-1): `{"$numberDouble":"-Infinity"}`,
+			} {
+				So(marshal(value, false), ShouldEqual, expected)
+			}
+			So(marshal(math.NaN(), false), ShouldEqual,
+				`{"$numberDouble":"NaN"}`)
+		})
+
+	Convey("Dates should be ISO-8601 strings in relaxed mode when they can "+
+		"be", t, func() {
+		date := time.Date(2014, 7, 22, 14, 30, 1, 5e6, time.UTC)
+		So(marshal(date, true), ShouldEqual,
+			`{"$date":{"$numberLong":"1406039401005"}}`)
+		So(marshal(date, false), ShouldEqual,
+			`{"$date":"2014-07-22T14:30:01.005Z"}`)
+		before := time.Date(1969, 12, 31, 23, 59, 59, 0, time.UTC)
+		So(marshal(before, false), ShouldEqual,
+			`{"$date":{"$numberLong":"-1000"}}`)
+	})
+
+	Convey("Other BSON types should have their v2 forms", t, func() {
+		id := bson.ObjectIdHex("53cfd31a8dc6d9a3d3ab5d6a")
+		document := bson.D{
+			{Name: "id", Value: id},
+			{Name: "bin", Value: []byte("hi")},
+			{Name: "uuid", Value: bson.Binary{Kind: 0x04, Data: []byte{1}}},
+			{Name: "re", Value: bson.RegEx{Pattern: "a.c", Options: "mi"}},
+			{Name: "ts", Value: bson.MongoTimestamp(5<<32 | 7)},
+			{Name: "code", Value: bson.JavaScript{Code: "f()"}},
+			{Name: "scoped", Value: bson.JavaScript{Code: "g()",
+				Scope: bson.M{"x": 1}}},
+			{Name: "sym", Value: bson.Symbol("s")},
+			{Name: "min", Value: bson.MinKey},
+			{Name: "max", Value: bson.MaxKey},
+			{Name: "undef", Value: bson.Undefined},
+			{Name: "null", Value: nil},
+			{Name: "array", Value: []interface{}{true, "x"}},
+		}
+		So(marshal(document, true), ShouldEqual, `{`+
+			`"id":{"$oid":"53cfd31a8dc6d9a3d3ab5d6a"},`+
+			`"bin":{"$binary":{"base64":"aGk=","subType":"00"}},`+
+			`"uuid":{"$binary":{"base64":"AQ==","subType":"04"}},`+
+			`"re":{"$regularExpression":{"pattern":"a.c","options":"im"}},`+
+			`"ts":{"$timestamp":{"t":5,"i":7}},`+
+			`"code":{"$code":"f()"},`+
+			`"scoped":{"$code":"g()","$scope":{"x":{"$numberInt":"1"}}},`+
+			`"sym":{"$symbol":"s"},`+
+			`"min":{"$minKey":1},"max":{"$maxKey":1},`+
+			`"undef":{"$undefined":true},"null":null,`+
+			`"array":[true,"x"]}`)
+	})
+
+	Convey("Unordered documents should be written with sorted fields", t,
+		func() {
+			So(marshal(bson.M{"b": 1, "a": bson.M{"d": 2, "c": 3}}, false),
+				ShouldEqual, `{"a":{"c":3,"d":2},"b":1}`)
+		})
+
+	Convey("Values of unknown types should cause an error", t, func() {
+		_, err := MarshalExtendedJSON(bson.M{"a": struct{}{}}, true)
+		So(err, ShouldNotBeNil)
+	})
+}
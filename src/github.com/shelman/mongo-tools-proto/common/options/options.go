@@ -7,6 +7,7 @@ import (
 	"github.com/jessevdk/go-flags"
 	"github.com/shelman/mongo-tools-proto/common/util"
 	"os"
+	"time"
 )
 
 // Struct encompassing all of the options that are reused across tools: "help",
@@ -97,6 +98,38 @@ type Kerberos struct {
 	// TODO: Add Hostname: https://github.com/mongodb/mongo/commit/f18e88ffafd615d515f3359ee73f719b1667e193
 }
 
+// Struct holding options for reporting the progress of long-running
+// operations, for the tools that add them with AddOptions. Progress is only
+// reported if asked for with --progressInterval.
+type Progress struct {
+	ProgressInterval int    `long:"progressInterval" description:"Specify the number of seconds between progress reports on stderr (0, the default, for none)"`
+	ProgressFormat   string `long:"progressFormat" default:"text" description:"Specify the format of progress reports: text, or json for one JSON document per line"`
+}
+
+func (self *Progress) Name() string {
+	return "progress"
+}
+
+// Returns a reporter writing the progress of the given operation to stderr as
+// specified by the options, or nil if progress reports are disabled - which
+// they always are in quiet mode.
+func (self *Progress) NewReporter(operation string,
+	quiet bool) (*util.ProgressReporter, error) {
+	if self.ProgressInterval < 0 {
+		return nil, fmt.Errorf("progress interval can not be negative")
+	}
+	if self.ProgressFormat != util.ProgressText &&
+		self.ProgressFormat != util.ProgressJSON {
+		return nil, fmt.Errorf("don't know what progress format [\"%v\"] is",
+			self.ProgressFormat)
+	}
+	if quiet || self.ProgressInterval == 0 {
+		return nil, nil
+	}
+	return util.NewProgressReporter(operation, os.Stderr,
+		time.Duration(self.ProgressInterval)*time.Second, self.ProgressFormat)
+}
+
 // Ask for a new instance of tool options
 func New(appName, versionStr, usageStr string) *ToolOptions {
 	return &ToolOptions{
@@ -0,0 +1,250 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// formats of progress reports
+const (
+	// ProgressText reports progress as lines of text
+	ProgressText = "text"
+	// ProgressJSON reports progress as one JSON document per line
+	ProgressJSON = "json"
+)
+
+// ProgressReporter periodically writes the progress of an operation over
+// documents - such as an import or an export - to an io.Writer: the number of
+// documents and bytes processed so far, the rate at which documents are
+// processed and, when the total number of bytes is known, the percentage done
+// and the estimated time left. Its counters are safe for concurrent use, and
+// all of its methods are no-ops on a nil ProgressReporter.
+type ProgressReporter struct {
+	// Operation names what is being reported on, e.g. "import"
+	Operation string
+	// Interval is the time between two reports
+	Interval time.Duration
+	// Format is either ProgressText or ProgressJSON
+	Format string
+
+	out        io.Writer
+	documents  int64
+	bytes      int64
+	totalBytes int64
+	started    time.Time
+	// now returns the current time; tests replace it
+	now func() time.Time
+
+	// mutex keeps reports from interleaving
+	mutex   sync.Mutex
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// ProgressReport is a single progress report, as written in JSON
+type ProgressReport struct {
+	Time       string `json:"time"`
+	Operation  string `json:"operation"`
+	Documents  int64  `json:"documents"`
+	Bytes      int64  `json:"bytes"`
+	TotalBytes int64  `json:"totalBytes,omitempty"`
+	// Percent and ETASeconds are only set when the total number of bytes
+	// is known
+	Percent       *float64 `json:"percent,omitempty"`
+	DocsPerSecond float64  `json:"docsPerSecond"`
+	ETASeconds    *float64 `json:"etaSeconds,omitempty"`
+	// Done is set in the final report
+	Done bool `json:"done"`
+}
+
+// NewProgressReporter returns a ProgressReporter for the given operation that
+// writes a report in the given format to out every interval once started
+func NewProgressReporter(operation string, out io.Writer,
+	interval time.Duration, format string) (*ProgressReporter, error) {
+	if format != ProgressText && format != ProgressJSON {
+		return nil, fmt.Errorf("don't know what progress format [\"%v\"] is",
+			format)
+	}
+	if interval <= 0 {
+		return nil, fmt.Errorf("progress interval must be positive")
+	}
+	return &ProgressReporter{
+		Operation: operation,
+		Interval:  interval,
+		Format:    format,
+		out:       out,
+		now:       time.Now,
+	}, nil
+}
+
+// SetTotalBytes sets the total number of bytes the operation will process, if
+// it is known in advance
+func (progress *ProgressReporter) SetTotalBytes(total int64) {
+	if progress == nil {
+		return
+	}
+	atomic.StoreInt64(&progress.totalBytes, total)
+}
+
+// AddDocuments adds to the number of documents processed
+func (progress *ProgressReporter) AddDocuments(count int64) {
+	if progress == nil {
+		return
+	}
+	atomic.AddInt64(&progress.documents, count)
+}
+
+// AddBytes adds to the number of bytes processed
+func (progress *ProgressReporter) AddBytes(count int64) {
+	if progress == nil {
+		return
+	}
+	atomic.AddInt64(&progress.bytes, count)
+}
+
+// Start starts reporting progress every interval until Stop is called
+func (progress *ProgressReporter) Start() {
+	if progress == nil {
+		return
+	}
+	progress.started = progress.now()
+	progress.stop = make(chan struct{})
+	progress.stopped = make(chan struct{})
+	go func() {
+		defer close(progress.stopped)
+		ticker := time.NewTicker(progress.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				progress.Report(false)
+			case <-progress.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops reporting progress and writes a final report
+func (progress *ProgressReporter) Stop() {
+	if progress == nil || progress.stop == nil {
+		return
+	}
+	close(progress.stop)
+	<-progress.stopped
+	progress.stop = nil
+	progress.Report(true)
+}
+
+// Report writes a report of the progress so far; done marks the final report
+func (progress *ProgressReporter) Report(done bool) {
+	if progress == nil {
+		return
+	}
+	report := progress.report(done)
+	progress.mutex.Lock()
+	defer progress.mutex.Unlock()
+	if progress.Format == ProgressJSON {
+		line, err := json.Marshal(report)
+		if err == nil {
+			fmt.Fprintf(progress.out, "%s\n", line)
+		}
+		return
+	}
+	fmt.Fprintln(progress.out, formatProgressReport(report))
+}
+
+// report gathers the current progress
+func (progress *ProgressReporter) report(done bool) ProgressReport {
+	now := progress.now()
+	report := ProgressReport{
+		Time:       now.Format(toolTimeFormat),
+		Operation:  progress.Operation,
+		Documents:  atomic.LoadInt64(&progress.documents),
+		Bytes:      atomic.LoadInt64(&progress.bytes),
+		TotalBytes: atomic.LoadInt64(&progress.totalBytes),
+		Done:       done,
+	}
+	elapsed := now.Sub(progress.started).Seconds()
+	if elapsed > 0 {
+		report.DocsPerSecond = float64(report.Documents) / elapsed
+	}
+	if report.TotalBytes > 0 {
+		// some inputs are read past what is counted as their size, e.g. the
+		// directory of a zip archive
+		percent := 100 * float64(report.Bytes) / float64(report.TotalBytes)
+		if percent > 100 {
+			percent = 100
+		}
+		report.Percent = &percent
+		if report.Bytes > 0 && elapsed > 0 && !done {
+			left := float64(report.TotalBytes-report.Bytes) /
+				(float64(report.Bytes) / elapsed)
+			if left < 0 {
+				left = 0
+			}
+			report.ETASeconds = &left
+		}
+	}
+	return report
+}
+
+// formatProgressReport formats a report as a line of text
+func formatProgressReport(report ProgressReport) string {
+	line := fmt.Sprintf("%v %v: %v documents, %v", report.Time,
+		report.Operation, report.Documents, FormatBytes(report.Bytes))
+	if report.Percent != nil {
+		line += fmt.Sprintf(" of %v (%.1f%%)", FormatBytes(report.TotalBytes),
+			*report.Percent)
+	}
+	line += fmt.Sprintf(", %.0f docs/sec", report.DocsPerSecond)
+	if report.ETASeconds != nil {
+		line += fmt.Sprintf(", ETA %v",
+			time.Duration(*report.ETASeconds)*time.Second)
+	}
+	if report.Done {
+		line += ", done"
+	}
+	return line
+}
+
+// FormatBytes formats a number of bytes in the largest binary unit in which
+// it is at least 1
+func FormatBytes(count int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	value := float64(count)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%v %v", count, units[0])
+	}
+	return fmt.Sprintf("%.1f %v", value, units[unit])
+}
+
+// progressWriter counts the bytes written through it
+type progressWriter struct {
+	out      io.Writer
+	progress *ProgressReporter
+}
+
+func (writer progressWriter) Write(p []byte) (int, error) {
+	n, err := writer.out.Write(p)
+	writer.progress.AddBytes(int64(n))
+	return n, err
+}
+
+// Writer returns an io.Writer writing to out that adds the bytes written to
+// the number of bytes processed. On a nil ProgressReporter it returns out.
+func (progress *ProgressReporter) Writer(out io.Writer) io.Writer {
+	if progress == nil {
+		return out
+	}
+	return progressWriter{out, progress}
+}
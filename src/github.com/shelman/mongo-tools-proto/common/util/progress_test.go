@@ -0,0 +1,132 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/shelman/mongo-tools-proto/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProgressReporter(t *testing.T) {
+
+	testutil.VerifyTestType(t, "unit")
+
+	Convey("With a progress reporter", t, func() {
+
+		out := &bytes.Buffer{}
+		start := time.Date(2014, 7, 1, 0, 0, 0, 0, time.UTC)
+		now := start.Add(10 * time.Second)
+
+		newReporter := func(format string) *ProgressReporter {
+			progress, err := NewProgressReporter("import", out, time.Hour,
+				format)
+			So(err, ShouldBeNil)
+			progress.now = func() time.Time { return now }
+			progress.started = start
+			return progress
+		}
+
+		Convey("text reports should show the documents, bytes and rate",
+			func() {
+
+				progress := newReporter(ProgressText)
+				progress.AddDocuments(500)
+				progress.AddBytes(2048)
+				progress.Report(false)
+				line := out.String()
+				So(line, ShouldEndWith, "\n")
+				So(line, ShouldContainSubstring,
+					"import: 500 documents, 2.0 KB, 50 docs/sec")
+				So(line, ShouldNotContainSubstring, "ETA")
+
+			})
+
+		Convey("with the total size known, the percentage and ETA should "+
+			"be shown", func() {
+
+			progress := newReporter(ProgressText)
+			progress.SetTotalBytes(4096)
+			progress.AddBytes(1024)
+			progress.Report(false)
+			So(out.String(), ShouldContainSubstring,
+				"1.0 KB of 4.0 KB (25.0%)")
+			So(out.String(), ShouldContainSubstring, "ETA 30s")
+
+		})
+
+		Convey("JSON reports should be one document per line", func() {
+
+			progress := newReporter(ProgressJSON)
+			progress.SetTotalBytes(100)
+			progress.AddDocuments(3)
+			progress.AddBytes(200)
+			progress.Report(true)
+			So(strings.Count(out.String(), "\n"), ShouldEqual, 1)
+			report := ProgressReport{}
+			So(json.Unmarshal(out.Bytes(), &report), ShouldBeNil)
+			So(report.Operation, ShouldEqual, "import")
+			So(report.Documents, ShouldEqual, 3)
+			So(report.Bytes, ShouldEqual, 200)
+			So(*report.Percent, ShouldEqual, 100)
+			So(report.ETASeconds, ShouldBeNil)
+			So(report.Done, ShouldBeTrue)
+
+		})
+
+		Convey("stopping should write a final report", func() {
+
+			progress := newReporter(ProgressText)
+			progress.Start()
+			progress.Stop()
+			So(out.String(), ShouldEndWith, ", done\n")
+
+		})
+
+		Convey("bytes written through its writer should be counted", func() {
+
+			progress := newReporter(ProgressText)
+			written := &bytes.Buffer{}
+			_, err := progress.Writer(written).Write([]byte("abc"))
+			So(err, ShouldBeNil)
+			So(written.String(), ShouldEqual, "abc")
+			So(progress.bytes, ShouldEqual, 3)
+
+		})
+
+		Convey("a nil reporter should do nothing", func() {
+
+			var progress *ProgressReporter
+			progress.AddDocuments(1)
+			progress.Start()
+			progress.Stop()
+			So(progress.Writer(out), ShouldEqual, out)
+
+		})
+
+		Convey("unknown formats should be rejected", func() {
+
+			_, err := NewProgressReporter("import", out, time.Second, "xml")
+			So(err, ShouldNotBeNil)
+
+		})
+
+	})
+
+}
+
+func TestFormatBytes(t *testing.T) {
+
+	testutil.VerifyTestType(t, "unit")
+
+	Convey("Byte counts should be formatted in the largest unit", t, func() {
+
+		So(FormatBytes(512), ShouldEqual, "512 B")
+		So(FormatBytes(1536), ShouldEqual, "1.5 KB")
+		So(FormatBytes(3*1024*1024*1024), ShouldEqual, "3.0 GB")
+
+	})
+
+}
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"strings"
+)
+
+var (
+	// tsvEscaper escapes the characters that can not appear as is in a TSV
+	// field
+	tsvEscaper = strings.NewReplacer(
+		`\`, `\\`,
+		"\t", `\t`,
+		"\n", `\n`,
+		"\r", `\r`,
+	)
+
+	// tsvUnescaper reverses tsvEscaper; any other backslash is left as is
+	tsvUnescaper = strings.NewReplacer(
+		`\\`, `\`,
+		`\t`, "\t",
+		`\n`, "\n",
+		`\r`, "\r",
+	)
+)
+
+// EscapeTSV escapes the backslashes, tabs and line breaks in the given TSV
+// field value as \\, \t, \n and \r
+func EscapeTSV(value string) string {
+	return tsvEscaper.Replace(value)
+}
+
+// UnescapeTSV returns the value of the given TSV field, undoing the escapes
+// of EscapeTSV. Backslashes that do not start one of those escapes are kept.
+func UnescapeTSV(field string) string {
+	return tsvUnescaper.Replace(field)
+}
@@ -0,0 +1,38 @@
+package util
+
+import (
+	"github.com/shelman/mongo-tools-proto/common/testutil"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestTSVEscapes(t *testing.T) {
+
+	testutil.VerifyTestType(t, "unit")
+
+	Convey("When escaping TSV field values", t, func() {
+
+		Convey("backslashes, tabs and line breaks should be escaped", func() {
+
+			So(EscapeTSV("a\tb\nc\r\nd\\e"), ShouldEqual, `a\tb\nc\r\nd\\e`)
+
+		})
+
+		Convey("unescaping should give back the original value", func() {
+
+			for _, value := range []string{"", "plain", "a\tb\n", `\`, `\\t`,
+				"\\\t", `C:\temp`} {
+				So(UnescapeTSV(EscapeTSV(value)), ShouldEqual, value)
+			}
+
+		})
+
+		Convey("unknown escapes should be left as is", func() {
+
+			So(UnescapeTSV(`C:\data\x`), ShouldEqual, `C:\data\x`)
+			So(UnescapeTSV(`trailing\`), ShouldEqual, `trailing\`)
+
+		})
+
+	})
+}
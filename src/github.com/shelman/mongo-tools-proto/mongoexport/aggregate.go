@@ -0,0 +1,108 @@
+package mongoexport
+
+import (
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+//documentCursor is a cursor over the documents to export: an *mgo.Iter over
+//the results of a query, or an aggregationCursor.
+type documentCursor interface {
+	Next(result interface{}) bool
+	Err() error
+	Close() error
+}
+
+//cursorReply is the reply to the aggregate and getMore commands.
+type cursorReply struct {
+	Cursor struct {
+		Id         int64      `bson:"id"`
+		FirstBatch []bson.Raw `bson:"firstBatch"`
+		NextBatch  []bson.Raw `bson:"nextBatch"`
+	} `bson:"cursor"`
+}
+
+//aggregationCursor is a cursor over the results of an aggregation pipeline.
+//The version of mgo in use can neither pass allowDiskUse to the aggregate
+//command nor ask it for a cursor - so its results would be limited to a single
+//16MB document - so the commands are run directly: aggregate for the first
+//batch of results, and getMore for each of the next.
+type aggregationCursor struct {
+	database   *mgo.Database
+	collection string
+	id         int64
+	batch      []bson.Raw
+	err        error
+}
+
+//runAggregation runs the given pipeline on the collection and returns a cursor
+//over its results.
+func runAggregation(collection *mgo.Collection, pipeline []bson.D,
+	allowDiskUse bool) (*aggregationCursor, error) {
+	command := bson.D{
+		{Name: "aggregate", Value: collection.Name},
+		{Name: "pipeline", Value: pipeline},
+		{Name: "cursor", Value: bson.M{}},
+	}
+	if allowDiskUse {
+		command = append(command, bson.DocElem{Name: "allowDiskUse", Value: true})
+	}
+	var reply cursorReply
+	if err := collection.Database.Run(command, &reply); err != nil {
+		return nil, err
+	}
+	return &aggregationCursor{
+		database:   collection.Database,
+		collection: collection.Name,
+		id:         reply.Cursor.Id,
+		batch:      reply.Cursor.FirstBatch,
+	}, nil
+}
+
+//Next unmarshals the next result into result, fetching the next batch of
+//results from the server if needed. It returns false once there are no
+//results left, or if an error occurred.
+func (cursor *aggregationCursor) Next(result interface{}) bool {
+	for len(cursor.batch) == 0 {
+		if cursor.id == 0 || cursor.err != nil {
+			return false
+		}
+		var reply cursorReply
+		cursor.err = cursor.database.Run(bson.D{
+			{Name: "getMore", Value: cursor.id},
+			{Name: "collection", Value: cursor.collection},
+		}, &reply)
+		if cursor.err != nil {
+			return false
+		}
+		cursor.id = reply.Cursor.Id
+		cursor.batch = reply.Cursor.NextBatch
+	}
+	document := cursor.batch[0]
+	cursor.batch = cursor.batch[1:]
+	if cursor.err = document.Unmarshal(result); cursor.err != nil {
+		return false
+	}
+	return true
+}
+
+//Err returns the error that stopped the cursor, if any.
+func (cursor *aggregationCursor) Err() error {
+	return cursor.err
+}
+
+//Close kills the cursor on the server, unless it is exhausted already, and
+//returns the error that stopped it, if any.
+func (cursor *aggregationCursor) Close() error {
+	if cursor.id != 0 {
+		err := cursor.database.Run(bson.D{
+			{Name: "killCursors", Value: cursor.collection},
+			{Name: "cursors", Value: []int64{cursor.id}},
+		}, nil)
+		cursor.id = 0
+		if err != nil && cursor.err == nil {
+			cursor.err = err
+		}
+	}
+	return cursor.err
+}
@@ -0,0 +1,58 @@
+package mongoexport
+
+import (
+	"io"
+	"labix.org/v2/mgo/bson"
+)
+
+//BSONExportOutput is an implementation of ExportOutput that writes documents
+//to the output as BSON, back to back with nothing in between - the same
+//framing mongodump uses - so that they can be imported without losing their
+//field order or types.
+type BSONExportOutput struct {
+	Out         io.Writer
+	NumExported int64
+}
+
+//NewBSONExportOutput creates a new BSONExportOutput configured to write data
+//to the given io.Writer
+func NewBSONExportOutput(out io.Writer) *BSONExportOutput {
+	return &BSONExportOutput{
+		Out: out,
+	}
+}
+
+//WriteHeader is a no-op, as BSON output has no header.
+func (bsonExporter *BSONExportOutput) WriteHeader() error {
+	return nil
+}
+
+//WriteFooter is a no-op, as BSON output has no footer.
+func (bsonExporter *BSONExportOutput) WriteFooter() error {
+	return nil
+}
+
+func (bsonExporter *BSONExportOutput) Flush() error {
+	return nil
+}
+
+//ExportDocument encodes the given document as BSON, and writes it to the
+//output.
+func (bsonExporter *BSONExportOutput) ExportDocument(document bson.M) error {
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return bsonExporter.ExportRawDocument(bson.Raw{Kind: 0x03, Data: data})
+}
+
+//ExportRawDocument writes the given document to the output exactly as it was
+//read from the server.
+func (bsonExporter *BSONExportOutput) ExportRawDocument(document bson.Raw) error {
+	_, err := bsonExporter.Out.Write(document.Data)
+	if err != nil {
+		return err
+	}
+	bsonExporter.NumExported++
+	return nil
+}
@@ -0,0 +1,113 @@
+package mongoexport
+
+import (
+	"bytes"
+	commonopts "github.com/shelman/mongo-tools-proto/common/options"
+	"github.com/shelman/mongo-tools-proto/mongoexport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestWriteBSON(t *testing.T) {
+	Convey("With a BSON export output", t, func() {
+		out := &bytes.Buffer{}
+		bsonExporter := NewBSONExportOutput(out)
+
+		Convey("raw documents should be written back to back as they are",
+			func() {
+				first, err := bson.Marshal(bson.D{{Name: "b", Value: 1},
+					{Name: "a", Value: int64(2)}})
+				So(err, ShouldBeNil)
+				second, err := bson.Marshal(bson.M{"c": "three"})
+				So(err, ShouldBeNil)
+
+				So(bsonExporter.WriteHeader(), ShouldBeNil)
+				for _, data := range [][]byte{first, second} {
+					err = exportRawDocument(bsonExporter,
+						bson.Raw{Kind: 0x03, Data: data})
+					So(err, ShouldBeNil)
+				}
+				So(bsonExporter.WriteFooter(), ShouldBeNil)
+				So(out.Bytes(), ShouldResemble, append(first, second...))
+				So(bsonExporter.NumExported, ShouldEqual, 2)
+			})
+
+		Convey("decoded documents should be encoded", func() {
+			So(bsonExporter.ExportDocument(bson.M{"a": 1}), ShouldBeNil)
+			var document bson.M
+			So(bson.Unmarshal(out.Bytes(), &document), ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"a": 1})
+		})
+	})
+
+	Convey("Raw documents should be decoded for other outputs", t, func() {
+		out := &bytes.Buffer{}
+		data, err := bson.Marshal(bson.M{"a": 1})
+		So(err, ShouldBeNil)
+		err = exportRawDocument(NewJSONExportOutput(false, out),
+			bson.Raw{Kind: 0x03, Data: data})
+		So(err, ShouldBeNil)
+		So(out.String(), ShouldEqual, `{"a":1}`+"\n")
+	})
+}
+
+func TestValidateBSONOptions(t *testing.T) {
+	Convey("With a MongoExport instance", t, func() {
+		exp := MongoExport{
+			ToolOptions: &commonopts.ToolOptions{
+				Namespace: &commonopts.Namespace{DB: "db", Collection: "c"},
+			},
+			OutputOpts: &options.OutputFormatOptions{BSON: true},
+			InputOpts:  &options.InputOptions{},
+		}
+
+		Convey("BSON output should be accepted on its own", func() {
+			So(exp.ValidateSettings(), ShouldBeNil)
+		})
+
+		Convey("BSON output should not be combined with another format",
+			func() {
+				for _, outputOpts := range []*options.OutputFormatOptions{
+					{BSON: true, CSV: true},
+					{BSON: true, TSV: true},
+					{BSON: true, JSONArray: true},
+					{BSON: true, JSONFormat: JSONFormatCanonical},
+				} {
+					exp.OutputOpts = outputOpts
+					So(exp.ValidateSettings(), ShouldNotBeNil)
+				}
+			})
+	})
+}
+
+func TestValidateJSONFormat(t *testing.T) {
+	Convey("With a MongoExport instance", t, func() {
+		exp := MongoExport{
+			ToolOptions: &commonopts.ToolOptions{
+				Namespace: &commonopts.Namespace{DB: "db", Collection: "c"},
+			},
+			OutputOpts: &options.OutputFormatOptions{},
+			InputOpts:  &options.InputOptions{},
+		}
+
+		Convey("known json formats should be accepted", func() {
+			for _, format := range []string{"", JSONFormatLegacy,
+				JSONFormatCanonical, JSONFormatRelaxed} {
+				exp.OutputOpts.JSONFormat = format
+				So(exp.ValidateSettings(), ShouldBeNil)
+			}
+		})
+
+		Convey("unknown json formats, or formats of non-json exports, "+
+			"should be rejected", func() {
+			for _, outputOpts := range []*options.OutputFormatOptions{
+				{JSONFormat: "strict"},
+				{JSONFormat: JSONFormatRelaxed, CSV: true},
+			} {
+				exp.OutputOpts = outputOpts
+				So(exp.ValidateSettings(), ShouldNotBeNil)
+			}
+		})
+	})
+}
@@ -7,6 +7,18 @@ import (
 	"labix.org/v2/mgo/bson"
 )
 
+//formats of JSON output
+const (
+	//JSONFormatLegacy is the extended JSON mongoexport has always written
+	JSONFormatLegacy = "legacy"
+	//JSONFormatCanonical is MongoDB Extended JSON v2 in canonical mode, which
+	//keeps the type of every value
+	JSONFormatCanonical = "canonical"
+	//JSONFormatRelaxed is MongoDB Extended JSON v2 in relaxed mode, which
+	//writes numbers and recent dates as plain JSON
+	JSONFormatRelaxed = "relaxed"
+)
+
 //JSONExportOutput is an implementation of ExportOutput that writes documents
 //to the output in JSON format.
 type JSONExportOutput struct {
@@ -16,6 +28,9 @@ type JSONExportOutput struct {
 	Encoder     *json.Encoder
 	Out         io.Writer
 	NumExported int64
+	//Format is one of JSONFormatLegacy (or "", the same), JSONFormatCanonical
+	//or JSONFormatRelaxed
+	Format string
 }
 
 //NewJSONExportOutput creates a new JSONExportOutput in array mode if specified,
@@ -26,9 +41,25 @@ func NewJSONExportOutput(arrayOutput bool, out io.Writer) *JSONExportOutput {
 		json.NewEncoder(out),
 		out,
 		0,
+		JSONFormatLegacy,
 	}
 }
 
+//NewJSONExportOutputWithFormat creates a new JSONExportOutput like
+//NewJSONExportOutput, writing the given format of extended JSON.
+func NewJSONExportOutputWithFormat(arrayOutput bool, format string,
+	out io.Writer) *JSONExportOutput {
+	jsonExporter := NewJSONExportOutput(arrayOutput, out)
+	jsonExporter.Format = format
+	return jsonExporter
+}
+
+//isV2 returns true if the output is in either mode of Extended JSON v2.
+func (jsonExporter *JSONExportOutput) isV2() bool {
+	return jsonExporter.Format == JSONFormatCanonical ||
+		jsonExporter.Format == JSONFormatRelaxed
+}
+
 //WriteHeader writes the opening square bracket if in array mode, otherwise it
 //behaves as a no-op.
 func (jsonExporter *JSONExportOutput) WriteHeader() error {
@@ -62,6 +93,9 @@ func (jsonExporter *JSONExportOutput) Flush() error {
 //ExportDocument converts the given document to extended json, and writes it
 //to the output.
 func (jsonExporter *JSONExportOutput) ExportDocument(document bson.M) error {
+	if jsonExporter.isV2() {
+		return jsonExporter.exportV2(document)
+	}
 	if jsonExporter.ArrayOutput {
 		if jsonExporter.NumExported >= 1 {
 			jsonExporter.Out.Write([]byte(","))
@@ -80,3 +114,43 @@ func (jsonExporter *JSONExportOutput) ExportDocument(document bson.M) error {
 	jsonExporter.NumExported++
 	return nil
 }
+
+//ExportRawDocument converts the given document as read from the server to
+//extended json, and writes it to the output. Extended JSON v2 keeps the order
+//of its fields.
+func (jsonExporter *JSONExportOutput) ExportRawDocument(document bson.Raw) error {
+	if !jsonExporter.isV2() {
+		var decoded bson.M
+		if err := document.Unmarshal(&decoded); err != nil {
+			return err
+		}
+		return jsonExporter.ExportDocument(decoded)
+	}
+	var decoded bson.D
+	if err := document.Unmarshal(&decoded); err != nil {
+		return err
+	}
+	return jsonExporter.exportV2(decoded)
+}
+
+//exportV2 writes the given document - a bson.M or bson.D - to the output as
+//Extended JSON v2.
+func (jsonExporter *JSONExportOutput) exportV2(document interface{}) error {
+	jsonOut, err := bson_ext.MarshalExtendedJSON(document,
+		jsonExporter.Format == JSONFormatCanonical)
+	if err != nil {
+		return err
+	}
+	if jsonExporter.ArrayOutput {
+		if jsonExporter.NumExported >= 1 {
+			jsonOut = append([]byte(","), jsonOut...)
+		}
+	} else {
+		jsonOut = append(jsonOut, '\n')
+	}
+	if _, err = jsonExporter.Out.Write(jsonOut); err != nil {
+		return err
+	}
+	jsonExporter.NumExported++
+	return nil
+}
@@ -68,3 +68,35 @@ func TestJSONArray(t *testing.T) {
 
 	})
 }
+
+func TestWriteExtendedJSONV2(t *testing.T) {
+	Convey("With a JSON export output in an Extended JSON v2 mode", t, func() {
+		out := &bytes.Buffer{}
+		data, err := bson.Marshal(bson.D{
+			{Name: "z", Value: int64(1)},
+			{Name: "a", Value: bson.D{{Name: "y", Value: 2}, {Name: "b", Value: 3.5}}},
+		})
+		So(err, ShouldBeNil)
+		document := bson.Raw{Kind: 0x03, Data: data}
+
+		Convey("canonical mode should keep field order and types", func() {
+			jsonExporter := NewJSONExportOutputWithFormat(false,
+				JSONFormatCanonical, out)
+			So(jsonExporter.ExportRawDocument(document), ShouldBeNil)
+			So(out.String(), ShouldEqual, `{"z":{"$numberLong":"1"},`+
+				`"a":{"y":{"$numberInt":"2"},"b":{"$numberDouble":"3.5"}}}`+"\n")
+		})
+
+		Convey("relaxed mode should write plain numbers, in an array if "+
+			"asked to", func() {
+			jsonExporter := NewJSONExportOutputWithFormat(true,
+				JSONFormatRelaxed, out)
+			So(jsonExporter.WriteHeader(), ShouldBeNil)
+			So(jsonExporter.ExportRawDocument(document), ShouldBeNil)
+			So(jsonExporter.ExportDocument(bson.M{"c": 1}), ShouldBeNil)
+			So(jsonExporter.WriteFooter(), ShouldBeNil)
+			So(out.String(), ShouldEqual,
+				`[{"z":1,"a":{"y":2,"b":3.5}},{"c":1}]`+"\n")
+		})
+	})
+}
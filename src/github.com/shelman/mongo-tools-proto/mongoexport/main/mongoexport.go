@@ -18,6 +18,8 @@ func main() {
 	opts.AddOptions(outputOpts)
 	inputOpts := &options.InputOptions{}
 	opts.AddOptions(inputOpts)
+	progressOpts := &commonopts.Progress{}
+	opts.AddOptions(progressOpts)
 
 	_, err := opts.Parse()
 	if err != nil {
@@ -44,6 +46,7 @@ func main() {
 		ToolOptions:     opts,
 		OutputOpts:      outputOpts,
 		InputOpts:       inputOpts,
+		ProgressOpts:    progressOpts,
 		SessionProvider: sessionProvider,
 	}
 
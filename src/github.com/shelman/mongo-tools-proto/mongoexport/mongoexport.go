@@ -9,8 +9,10 @@ import (
 	"github.com/shelman/mongo-tools-proto/common/util"
 	"github.com/shelman/mongo-tools-proto/mongoexport/options"
 	"io"
+	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -23,6 +25,11 @@ const (
 var (
 	_ ExportOutput = (*CSVExportOutput)(nil)
 	_ ExportOutput = (*JSONExportOutput)(nil)
+	_ ExportOutput = (*TSVExportOutput)(nil)
+	_ ExportOutput = (*BSONExportOutput)(nil)
+
+	_ RawExportOutput = (*BSONExportOutput)(nil)
+	_ RawExportOutput = (*JSONExportOutput)(nil)
 )
 
 // Wrapper for mongoexport functionality
@@ -35,6 +42,9 @@ type MongoExport struct {
 
 	InputOpts *options.InputOptions
 
+	//ProgressOpts controls how the progress of the export is reported, if at all
+	ProgressOpts *commonopts.Progress
+
 	// for connecting to the db
 	SessionProvider *db.SessionProvider
 	ExportOutput    ExportOutput
@@ -52,15 +62,109 @@ func (exp *MongoExport) ValidateSettings() error {
 		return fmt.Errorf("must specify a database and collection")
 	}
 
+	if exp.OutputOpts != nil {
+		formats := 0
+		for _, format := range []bool{exp.OutputOpts.CSV, exp.OutputOpts.TSV,
+			exp.OutputOpts.BSON} {
+			if format {
+				formats++
+			}
+		}
+		if formats > 1 {
+			return fmt.Errorf("can only export to one of csv, tsv and bson")
+		}
+		if exp.OutputOpts.BSON && exp.OutputOpts.JSONArray {
+			return fmt.Errorf("can not export a json array as bson")
+		}
+		switch exp.OutputOpts.JSONFormat {
+		case "", JSONFormatLegacy:
+		case JSONFormatCanonical, JSONFormatRelaxed:
+			if formats > 0 {
+				return fmt.Errorf("--jsonFormat can only be used with json " +
+					"exports")
+			}
+		default:
+			return fmt.Errorf("don't know what json format [\"%v\"] is",
+				exp.OutputOpts.JSONFormat)
+		}
+	}
+
 	if exp.InputOpts != nil && exp.InputOpts.Query != "" {
 		_, err := getQueryFromArg(exp.InputOpts.Query)
 		if err != nil {
 			return err
 		}
 	}
+
+	if exp.InputOpts != nil {
+		if exp.InputOpts.Sort != "" {
+			_, err := getSortFromArg(exp.InputOpts.Sort)
+			if err != nil {
+				return err
+			}
+		}
+		if exp.InputOpts.Skip < 0 {
+			return fmt.Errorf("number of documents to skip can not be negative")
+		}
+		if exp.InputOpts.Limit < 0 {
+			return fmt.Errorf("limit can not be negative")
+		}
+		if exp.InputOpts.Pipeline != "" {
+			if exp.InputOpts.Query != "" || exp.InputOpts.Sort != "" ||
+				exp.InputOpts.Skip != 0 || exp.InputOpts.Limit != 0 {
+				return fmt.Errorf("can not use --query, --sort, --skip or " +
+					"--limit with --pipeline; use $match, $sort, $skip and " +
+					"$limit stages instead")
+			}
+			_, err := getPipelineFromArg(exp.InputOpts.Pipeline)
+			if err != nil {
+				return err
+			}
+		} else if exp.InputOpts.AllowDiskUse {
+			return fmt.Errorf("--allowDiskUse can only be used with --pipeline")
+		}
+	}
+
+	if exp.InputOpts != nil && exp.InputOpts.Partitions != 0 {
+		if exp.InputOpts.Partitions < 0 {
+			return fmt.Errorf("number of partitions can not be negative")
+		}
+		if exp.InputOpts.Partitions > 1 && (exp.InputOpts.Sort != "" ||
+			exp.InputOpts.Skip != 0 || exp.InputOpts.Limit != 0 ||
+			exp.InputOpts.Pipeline != "") {
+			return fmt.Errorf("can not use --sort, --skip, --limit or " +
+				"--pipeline with --partitions")
+		}
+	}
+
+	if exp.OutputOpts != nil && exp.OutputOpts.SplitOutput {
+		if exp.partitions() < 2 {
+			return fmt.Errorf("--splitOutput requires at least 2 --partitions")
+		}
+		if exp.OutputOpts.OutputFile == "" {
+			return fmt.Errorf("--splitOutput requires an output file (--out) " +
+				"to name the files of the partitions after")
+		}
+	}
+
+	if exp.ProgressOpts != nil {
+		if _, err := exp.ProgressOpts.NewReporter("export", true); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+//getProgressReporter returns the reporter for the progress of the export, or
+//nil if progress reports are disabled.
+func (exp *MongoExport) getProgressReporter() (*util.ProgressReporter, error) {
+	if exp.ProgressOpts == nil {
+		return nil, nil
+	}
+	quiet := exp.ToolOptions.Verbosity != nil && exp.ToolOptions.Quiet
+	return exp.ProgressOpts.NewReporter("export", quiet)
+}
+
 //getOutputWriter returns an io.Writer corresponding to the output location
 //specified in the options.
 func (exp *MongoExport) getOutputWriter() (io.WriteCloser, error) {
@@ -80,49 +184,68 @@ func (exp *MongoExport) getOutputWriter() (io.WriteCloser, error) {
 //during the export operation.
 func (exp *MongoExport) Export() (int64, error) {
 	session := exp.SessionProvider.GetSession()
-	out, err := exp.getOutputWriter()
+	defer session.Close()
+
+	progress, err := exp.getProgressReporter()
 	if err != nil {
 		return 0, err
 	}
 
-	defer out.Close()
+	collection := session.DB(exp.ToolOptions.Namespace.DB).C(exp.ToolOptions.Namespace.Collection)
 
-	exportOutput, err := exp.getExportOutput(out)
+	if exp.partitions() > 1 {
+		return exp.exportPartitions(collection, progress)
+	}
+
+	out, err := exp.getOutputWriter()
 	if err != nil {
 		return 0, err
 	}
 
-	collection := session.DB(exp.ToolOptions.Namespace.DB).C(exp.ToolOptions.Namespace.Collection)
+	defer out.Close()
 
-	query := map[string]interface{}{}
-	if exp.InputOpts != nil && exp.InputOpts.Query != "" {
-		var err error
-		query, err = getQueryFromArg(exp.InputOpts.Query)
-		if err != nil {
-			return 0, err
-		}
+	exportOutput, err := exp.getExportOutput(progress.Writer(out))
+	if err != nil {
+		return 0, err
 	}
 
-	cursor := collection.Find(query).Iter()
+	cursor, err := exp.getCursor(collection)
+	if err != nil {
+		return 0, err
+	}
 	defer cursor.Close()
 
+	progress.Start()
+	defer progress.Stop()
+
+	return exp.exportCursor(cursor, exportOutput, progress)
+}
+
+//exportCursor writes all of the documents of the cursor, between the header
+//and the footer of the output. It returns the number of documents written.
+func (exp *MongoExport) exportCursor(cursor documentCursor, exportOutput ExportOutput,
+	progress *util.ProgressReporter) (int64, error) {
 	//Write headers
-	err = exportOutput.WriteHeader()
+	err := exportOutput.WriteHeader()
 	if err != nil {
 		return 0, err
 	}
 
-	var result bson.M
+	var result bson.Raw
 
 	docsCount := int64(0)
 	//Write document content
 	for cursor.Next(&result) {
-		err := exportOutput.ExportDocument(result)
+		err := exportRawDocument(exportOutput, result)
 		if err != nil {
 			fmt.Println(err)
 			return docsCount, err
 		}
 		docsCount++
+		progress.AddDocuments(1)
+	}
+	if err = cursor.Err(); err != nil {
+		return docsCount, err
 	}
 
 	//Write footers
@@ -136,25 +259,122 @@ func (exp *MongoExport) Export() (int64, error) {
 	return docsCount, nil
 }
 
+//getCursor returns a cursor over the documents to export from the given
+//collection: the results of the aggregation pipeline, if there is one, or else
+//the documents found by the query.
+func (exp *MongoExport) getCursor(collection *mgo.Collection) (documentCursor, error) {
+	if exp.InputOpts != nil && exp.InputOpts.Pipeline != "" {
+		pipeline, err := getPipelineFromArg(exp.InputOpts.Pipeline)
+		if err != nil {
+			return nil, err
+		}
+		return runAggregation(collection, pipeline, exp.InputOpts.AllowDiskUse)
+	}
+	find, err := exp.getFindQuery(collection)
+	if err != nil {
+		return nil, err
+	}
+	return find.Iter(), nil
+}
+
+//getFindQuery returns the query for the documents to export from the given
+//collection, with the filter, sort order, skip, limit and projection given by
+//the options applied.
+func (exp *MongoExport) getFindQuery(collection *mgo.Collection) (*mgo.Query, error) {
+	query, err := exp.getQuery()
+	if err != nil {
+		return nil, err
+	}
+	find := collection.Find(query)
+
+	if exp.InputOpts != nil {
+		if exp.InputOpts.Sort != "" {
+			sort, err := getSortFromArg(exp.InputOpts.Sort)
+			if err != nil {
+				return nil, err
+			}
+			find = find.Sort(sortKeys(sort)...)
+		}
+		if exp.InputOpts.Skip > 0 {
+			find = find.Skip(exp.InputOpts.Skip)
+		}
+		if exp.InputOpts.Limit > 0 {
+			find = find.Limit(exp.InputOpts.Limit)
+		}
+	}
+
+	return exp.selectFields(find)
+}
+
+//getQuery returns the filter given by --query, or an empty filter if there is
+//none.
+func (exp *MongoExport) getQuery() (map[string]interface{}, error) {
+	if exp.InputOpts != nil && exp.InputOpts.Query != "" {
+		return getQueryFromArg(exp.InputOpts.Query)
+	}
+	return map[string]interface{}{}, nil
+}
+
+//selectFields limits the given query to the fields that are exported, when
+//only some of them are.
+func (exp *MongoExport) selectFields(find *mgo.Query) (*mgo.Query, error) {
+	//CSV and TSV exports only need their fields sent over the wire
+	if exp.OutputOpts != nil && (exp.OutputOpts.CSV || exp.OutputOpts.TSV) {
+		fields, err := exp.getFields()
+		if err != nil {
+			return nil, err
+		}
+		if len(fields) > 0 {
+			find = find.Select(getProjection(fields))
+		}
+	}
+	return find, nil
+}
+
+//getFields returns the fields to export to CSV or TSV, from either --fields or
+//--fieldFile.
+func (exp *MongoExport) getFields() ([]string, error) {
+	//TODO what if user specifies *both* --fields and --fieldFile?
+	if len(exp.OutputOpts.Fields) > 0 {
+		return strings.Split(exp.OutputOpts.Fields, ","), nil
+	} else if exp.OutputOpts.FieldFile != "" {
+		return util.GetFieldsFromFile(exp.OutputOpts.FieldFile)
+	}
+	return nil, nil
+}
+
 //getExportOutput returns an implementation of ExportOutput which can handle
 //transforming BSON documents into the appropriate output format and writing
 //them to an output stream.
 func (exp *MongoExport) getExportOutput(out io.Writer) (ExportOutput, error) {
-	if exp.OutputOpts.CSV {
-		//TODO what if user specifies *both* --fields and --fieldFile?
-		var fields []string
-		var err error
-		if len(exp.OutputOpts.Fields) > 0 {
-			fields = strings.Split(exp.OutputOpts.Fields, ",")
-		} else if exp.OutputOpts.FieldFile != "" {
-			fields, err = util.GetFieldsFromFile(exp.OutputOpts.FieldFile)
-			if err != nil {
-				return nil, err
-			}
+	if exp.OutputOpts.CSV || exp.OutputOpts.TSV {
+		fields, err := exp.getFields()
+		if err != nil {
+			return nil, err
+		}
+		if exp.OutputOpts.TSV {
+			return NewTSVExportOutput(fields, out), nil
 		}
 		return NewCSVExportOutput(fields, out), nil
 	}
-	return NewJSONExportOutput(exp.OutputOpts.JSONArray, out), nil
+	if exp.OutputOpts.BSON {
+		return NewBSONExportOutput(out), nil
+	}
+	return NewJSONExportOutputWithFormat(exp.OutputOpts.JSONArray,
+		exp.OutputOpts.JSONFormat, out), nil
+}
+
+//exportRawDocument writes a document read from the server to the output: as
+//it is if the output writes raw documents, or else once decoded.
+func exportRawDocument(exportOutput ExportOutput, document bson.Raw) error {
+	if rawOutput, ok := exportOutput.(RawExportOutput); ok {
+		return rawOutput.ExportRawDocument(document)
+	}
+	var decoded bson.M
+	if err := document.Unmarshal(&decoded); err != nil {
+		return err
+	}
+	return exportOutput.ExportDocument(decoded)
 }
 
 //ExportOutput is an interface that specifies how a document should be formatted
@@ -176,6 +396,13 @@ type ExportOutput interface {
 	Flush() error
 }
 
+//RawExportOutput is implemented by the ExportOutputs that can write documents
+//exactly as they were read from the server, without decoding them.
+type RawExportOutput interface {
+	//ExportRawDocument writes the given BSON document to the output.
+	ExportRawDocument(bson.Raw) error
+}
+
 //getQueryFromArg takes a query in extended JSON, and convert is to an object that
 //can be passed straight to db.collection.find(...). Returns an error if the
 //string is not valid JSON, or extended JSON.
@@ -197,3 +424,125 @@ func getQueryFromArg(queryRaw string) (map[string]interface{}, error) {
 	}
 	return parsedJSON, nil
 }
+
+//getSortFromArg takes a sort order in JSON - a document of field names and
+//directions, 1 for ascending and -1 for descending - and converts it to an
+//ordered document, as the order of its fields matters. Like queries, it is
+//parsed as extended JSON, so directions may be given as e.g. {"$numberLong": "1"}.
+func getSortFromArg(sortRaw string) (bson.D, error) {
+	decoder := json.NewDecoder(strings.NewReader(sortRaw))
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('{') {
+		return nil, fmt.Errorf("Sort is not a JSON document")
+	}
+	var sort bson.D
+	for decoder.More() {
+		token, err = decoder.Token()
+		if err != nil {
+			return nil, fmt.Errorf("Sort is not valid JSON: %v", err)
+		}
+		field, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("Sort is not a JSON document")
+		}
+		if field == "" {
+			return nil, fmt.Errorf("Sort field names can not be empty")
+		}
+		var value interface{}
+		if err = decoder.Decode(&value); err != nil {
+			return nil, fmt.Errorf("Sort is not valid JSON: %v", err)
+		}
+		if valueSubDoc, ok := value.(map[string]interface{}); ok {
+			value, err = bson_ext.ParseExtendedJSON(valueSubDoc)
+			if err != nil {
+				return nil, fmt.Errorf("Error in sort: %v", err)
+			}
+		}
+		direction, ok := getSortDirection(value)
+		if !ok {
+			return nil, fmt.Errorf("Sort direction for '%v' must be 1 or -1", field)
+		}
+		sort = append(sort, bson.DocElem{Name: field, Value: direction})
+	}
+	if _, err = decoder.Token(); err != nil {
+		return nil, fmt.Errorf("Sort is not valid JSON: %v", err)
+	}
+	if len(sort) == 0 {
+		return nil, fmt.Errorf("Sort must name at least one field")
+	}
+	return sort, nil
+}
+
+//getSortDirection returns the sort direction given by a value of a sort order,
+//if it is 1 or -1.
+func getSortDirection(value interface{}) (int, bool) {
+	var direction int64
+	switch number := value.(type) {
+	case float64:
+		direction = int64(number)
+		if float64(direction) != number {
+			return 0, false
+		}
+	case bson_ext.NumberLongExt:
+		direction = int64(number)
+	default:
+		return 0, false
+	}
+	if direction != 1 && direction != -1 {
+		return 0, false
+	}
+	return int(direction), true
+}
+
+//sortKeys converts a sort order to the form mgo's Query.Sort takes: field
+//names, prefixed with '-' for descending order.
+func sortKeys(sort bson.D) []string {
+	keys := make([]string, 0, len(sort))
+	for _, field := range sort {
+		if field.Value == -1 {
+			keys = append(keys, "-"+field.Name)
+		} else {
+			keys = append(keys, field.Name)
+		}
+	}
+	return keys
+}
+
+//getProjection returns the projection that selects the given fields - and
+//only those, leaving out _id unless it is one of them. Fields within arrays
+//(e.g. "addresses.0.city") can not be projected, so their whole array is
+//selected instead. Fields within fields already selected are left out, as the
+//server refuses projections of both.
+func getProjection(fields []string) bson.M {
+	paths := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts := strings.Split(field, ".")
+		for index, part := range parts {
+			if _, err := strconv.Atoi(part); err == nil && index > 0 {
+				parts = parts[:index]
+				break
+			}
+		}
+		paths = append(paths, strings.Join(parts, "."))
+	}
+	projection := bson.M{}
+	for _, path := range paths {
+		covered := false
+		for _, other := range paths {
+			if strings.HasPrefix(path, other+".") {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			projection[path] = 1
+		}
+	}
+	for _, path := range paths {
+		if path == "_id" || strings.HasPrefix(path, "_id.") {
+			return projection
+		}
+	}
+	projection["_id"] = 0
+	return projection
+}
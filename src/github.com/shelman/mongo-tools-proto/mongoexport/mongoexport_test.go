@@ -3,6 +3,8 @@ package mongoexport
 import (
 	"encoding/json"
 	"github.com/shelman/mongo-tools-proto/common/bson_ext"
+	commonopts "github.com/shelman/mongo-tools-proto/common/options"
+	"github.com/shelman/mongo-tools-proto/mongoexport/options"
 	. "github.com/smartystreets/goconvey/convey"
 	"labix.org/v2/mgo/bson"
 	"os"
@@ -27,3 +29,71 @@ func TestExtendedJSON(t *testing.T) {
 		jsonEncoder.Encode(out)
 	})
 }
+
+func TestGetSortFromArg(t *testing.T) {
+	Convey("Parsing a sort order should keep its fields in order", t, func() {
+		sort, err := getSortFromArg(`{"b": 1, "a": -1, "c": {"$numberLong": "1"}}`)
+		So(err, ShouldBeNil)
+		So(sort, ShouldResemble, bson.D{
+			{Name: "b", Value: 1},
+			{Name: "a", Value: -1},
+			{Name: "c", Value: 1},
+		})
+		So(sortKeys(sort), ShouldResemble, []string{"b", "-a", "c"})
+	})
+
+	Convey("Bad sort orders should cause an error", t, func() {
+		for _, sortRaw := range []string{`[1]`, `{}`, `{"a": 2}`,
+			`{"a": "asc"}`, `{"a": 1`, `{"a": 0.5}`, `{"": 1}`,
+			`{"a": 1, "": -1}`} {
+			_, err := getSortFromArg(sortRaw)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestGetProjection(t *testing.T) {
+	Convey("Projecting fields should select only those fields", t, func() {
+		So(getProjection([]string{"a", "b.c"}), ShouldResemble,
+			bson.M{"a": 1, "b.c": 1, "_id": 0})
+		So(getProjection([]string{"_id", "a"}), ShouldResemble,
+			bson.M{"_id": 1, "a": 1})
+	})
+
+	Convey("Fields within arrays should select the whole array, and fields "+
+		"within selected fields should be left out", t, func() {
+		So(getProjection([]string{"addresses.0.city", "a", "a.b"}),
+			ShouldResemble, bson.M{"addresses": 1, "a": 1, "_id": 0})
+	})
+}
+
+func TestValidateQueryOptions(t *testing.T) {
+	Convey("With a MongoExport instance", t, func() {
+		exp := MongoExport{
+			ToolOptions: &commonopts.ToolOptions{
+				Namespace: &commonopts.Namespace{DB: "db", Collection: "c"},
+			},
+			OutputOpts: &options.OutputFormatOptions{},
+			InputOpts:  &options.InputOptions{},
+		}
+
+		Convey("a sort order, skip and limit should be accepted", func() {
+			exp.InputOpts.Sort = `{"a": -1}`
+			exp.InputOpts.Skip = 10
+			exp.InputOpts.Limit = 5
+			So(exp.ValidateSettings(), ShouldBeNil)
+		})
+
+		Convey("bad sort orders and negative skips or limits should be "+
+			"rejected", func() {
+			for _, inputOpts := range []*options.InputOptions{
+				{Sort: `{"a": "up"}`},
+				{Skip: -1},
+				{Limit: -1},
+			} {
+				exp.InputOpts = inputOpts
+				So(exp.ValidateSettings(), ShouldNotBeNil)
+			}
+		})
+	})
+}
@@ -10,11 +10,27 @@ type OutputFormatOptions struct {
 	//CSV switches the export mode from JSON (the default) to CSV
 	CSV bool `long:"csv" description:"export to csv instead of json"`
 
+	//TSV switches the export mode from JSON (the default) to TSV, escaping
+	//tabs, line breaks and backslashes within values
+	TSV bool `long:"tsv" description:"export to tsv instead of json"`
+
+	//BSON switches the export mode from JSON (the default) to BSON, written
+	//back to back as mongodump writes it
+	BSON bool `long:"bson" description:"export to bson instead of json"`
+
 	//OutputFile specifies an output file path.
 	OutputFile string `long:"out" description:"output file- if not specified, stdout is used"`
 
+	//JSONFormat selects the flavor of extended JSON written: the legacy one,
+	//or either mode of MongoDB Extended JSON v2
+	JSONFormat string `long:"jsonFormat" default:"legacy" description:"extended json format to export to: legacy, or canonical or relaxed for MongoDB Extended JSON v2"`
+
 	//JSONArray if set will export the documents an array of json docs
 	JSONArray bool `long:"jsonArray" description:"output to a json array rather than one object per line"`
+
+	//SplitOutput writes each partition of a partitioned export to its own
+	//file, numbered after the output file
+	SplitOutput bool `long:"splitOutput" description:"with --partitions, write each partition to its own file, e.g. out.0.json, out.1.json, ... for --out out.json"`
 }
 
 func (self *OutputFormatOptions) Name() string {
@@ -23,6 +39,27 @@ func (self *OutputFormatOptions) Name() string {
 
 type InputOptions struct {
 	Query string `long:"query" short:"q" description:"query filter, as a JSON string, e.g., '{x:{$gt:1}}'"`
+
+	//Sort orders the exported documents by the given fields, in order
+	Sort string `long:"sort" description:"sort order, as a JSON string of fields and directions, e.g., '{\"x\": 1, \"y\": -1}'"`
+
+	//Skip is the number of matching documents to skip before exporting
+	Skip int `long:"skip" description:"number of documents to skip"`
+
+	//Limit is the maximum number of documents to export (0 for no limit)
+	Limit int `long:"limit" description:"maximum number of documents to export"`
+
+	//Pipeline is an aggregation pipeline whose results are exported instead
+	//of the documents of the collection, either inline or in a file
+	Pipeline string `long:"pipeline" description:"aggregation pipeline, as a JSON array of stages or a file containing one, e.g., '[{\"$group\": {\"_id\": \"$x\"}}]'"`
+
+	//AllowDiskUse lets the stages of the pipeline write temporary files on
+	//the server, for pipelines over the memory limit of a stage
+	AllowDiskUse bool `long:"allowDiskUse" description:"allow the aggregation pipeline to use disk space on the server"`
+
+	//Partitions is the number of _id ranges the collection is split into and
+	//read concurrently (0 or 1 to read it with a single cursor)
+	Partitions int `long:"partitions" description:"number of _id ranges of the collection to read concurrently"`
 	//SlaveOk
 	//ForceTableScan
 }
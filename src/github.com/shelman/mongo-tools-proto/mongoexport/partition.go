@@ -0,0 +1,405 @@
+package mongoexport
+
+import (
+	"fmt"
+	"github.com/shelman/mongo-tools-proto/common/util"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//samplesPerPartition is the number of _id values sampled for each partition
+//when the boundaries of the partitions can not be found with splitVector.
+const samplesPerPartition = 20
+
+//The codes of the server errors that mean splitVector can not be run, or that
+//the server is too old for the $sample stage of aggregation pipelines (before
+//3.2).
+const (
+	errorCodeUnauthorized      = 13
+	errorCodeCommandNotFound   = 59
+	errorCodeUnrecognizedStage = 16436
+)
+
+//partitionRange is a range of the _id index of the collection, read by a
+//single cursor of a partitioned export.
+type partitionRange struct {
+	//Min is the inclusive lower bound of the range and Max its exclusive
+	//upper bound, as _id index keys; a nil bound leaves the range open.
+	Min bson.M
+	Max bson.M
+}
+
+//partitions returns the number of partitions the collection is read in.
+func (exp *MongoExport) partitions() int {
+	if exp.InputOpts == nil {
+		return 0
+	}
+	return exp.InputOpts.Partitions
+}
+
+//exportPartitions splits the collection into ranges of _id values and reads
+//them concurrently, either interleaving their documents into the one output
+//or, with --splitOutput, writing each range to its own file. It returns the
+//total number of documents exported.
+func (exp *MongoExport) exportPartitions(collection *mgo.Collection,
+	progress *util.ProgressReporter) (int64, error) {
+	boundaries, err := exp.getPartitionBoundaries(collection)
+	if err != nil {
+		return 0, err
+	}
+	partitions := partitionRanges(boundaries)
+
+	if exp.OutputOpts.SplitOutput {
+		return exp.exportPartitionFiles(collection, partitions, progress)
+	}
+
+	out, err := exp.getOutputWriter()
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	exportOutput, err := exp.getExportOutput(progress.Writer(out))
+	if err != nil {
+		return 0, err
+	}
+
+	documents := make(chan bson.Raw, len(partitions))
+	errs := make(chan error, len(partitions))
+	//done stops the readers if writing fails
+	done := make(chan struct{})
+	defer close(done)
+
+	var readers sync.WaitGroup
+	for _, partition := range partitions {
+		readers.Add(1)
+		go func(partition partitionRange) {
+			defer readers.Done()
+			if err := exp.readPartition(collection, partition, documents,
+				done); err != nil {
+				errs <- err
+			}
+		}(partition)
+	}
+	go func() {
+		readers.Wait()
+		close(documents)
+	}()
+
+	progress.Start()
+	defer progress.Stop()
+
+	//Write headers
+	err = exportOutput.WriteHeader()
+	if err != nil {
+		return 0, err
+	}
+
+	docsCount := int64(0)
+	//Write document content, from whichever partition has some ready
+	for document := range documents {
+		select {
+		case err = <-errs:
+			return docsCount, err
+		default:
+		}
+		err = exportRawDocument(exportOutput, document)
+		if err != nil {
+			return docsCount, err
+		}
+		docsCount++
+		progress.AddDocuments(1)
+	}
+	select {
+	case err = <-errs:
+		return docsCount, err
+	default:
+	}
+
+	//Write footers
+	err = exportOutput.WriteFooter()
+	if err != nil {
+		return docsCount, err
+	}
+
+	exportOutput.Flush()
+
+	return docsCount, nil
+}
+
+//readPartition sends the documents in the given range of the collection to
+//documents, until there are none left or done is closed.
+func (exp *MongoExport) readPartition(collection *mgo.Collection,
+	partition partitionRange, documents chan<- bson.Raw,
+	done <-chan struct{}) error {
+	//each partition is read over its own connection
+	session := exp.SessionProvider.GetSession()
+	defer session.Close()
+
+	find, err := exp.getPartitionQuery(collection.With(session), partition)
+	if err != nil {
+		return err
+	}
+	cursor := find.Iter()
+	defer cursor.Close()
+
+	for {
+		var document bson.Raw
+		if !cursor.Next(&document) {
+			break
+		}
+		select {
+		case documents <- document:
+		case <-done:
+			return nil
+		}
+	}
+	return cursor.Err()
+}
+
+//exportPartitionFiles exports each of the given ranges of the collection to
+//its own file, concurrently.
+func (exp *MongoExport) exportPartitionFiles(collection *mgo.Collection,
+	partitions []partitionRange, progress *util.ProgressReporter) (int64, error) {
+	progress.Start()
+	defer progress.Stop()
+
+	docsCount := int64(0)
+	errs := make(chan error, len(partitions))
+	for index, partition := range partitions {
+		go func(index int, partition partitionRange) {
+			count, err := exp.exportPartitionFile(collection, index, partition,
+				progress)
+			atomic.AddInt64(&docsCount, count)
+			errs <- err
+		}(index, partition)
+	}
+
+	var firstErr error
+	for range partitions {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return docsCount, firstErr
+}
+
+//exportPartitionFile exports the given range of the collection to the file
+//numbered index.
+func (exp *MongoExport) exportPartitionFile(collection *mgo.Collection, index int,
+	partition partitionRange, progress *util.ProgressReporter) (int64, error) {
+	//each partition is read over its own connection
+	session := exp.SessionProvider.GetSession()
+	defer session.Close()
+
+	find, err := exp.getPartitionQuery(collection.With(session), partition)
+	if err != nil {
+		return 0, err
+	}
+
+	out, err := os.Create(getPartitionFileName(exp.OutputOpts.OutputFile, index))
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	exportOutput, err := exp.getExportOutput(progress.Writer(out))
+	if err != nil {
+		return 0, err
+	}
+
+	cursor := find.Iter()
+	defer cursor.Close()
+
+	return exp.exportCursor(cursor, exportOutput, progress)
+}
+
+//getPartitionQuery returns the query for the documents to export within the
+//given range of the collection. The range is given as bounds of the _id index
+//($min and $max) rather than as a filter on _id, which would only match _id
+//values of the same BSON type as the bounds.
+func (exp *MongoExport) getPartitionQuery(collection *mgo.Collection,
+	partition partitionRange) (*mgo.Query, error) {
+	query, err := exp.getQuery()
+	if err != nil {
+		return nil, err
+	}
+	wrapped := bson.D{{Name: "$query", Value: query}}
+	if partition.Min != nil {
+		wrapped = append(wrapped, bson.DocElem{Name: "$min", Value: partition.Min})
+	}
+	if partition.Max != nil {
+		wrapped = append(wrapped, bson.DocElem{Name: "$max", Value: partition.Max})
+	}
+	wrapped = append(wrapped, bson.DocElem{Name: "$hint",
+		Value: bson.M{"_id": 1}})
+	return exp.selectFields(collection.Find(wrapped))
+}
+
+//getPartitionBoundaries returns the _id values that split the collection into
+//--partitions ranges of about the same size. There may be fewer boundaries
+//than needed for small collections, and there are none if the server can
+//neither split the collection nor sample it.
+func (exp *MongoExport) getPartitionBoundaries(collection *mgo.Collection) ([]interface{}, error) {
+	partitions := exp.partitions()
+	keys, err := getSplitVectorKeys(collection, partitions)
+	if err != nil && !isSplitVectorUnavailable(err) {
+		return nil, fmt.Errorf("error finding the boundaries of the "+
+			"partitions: %v", err)
+	}
+	if err != nil {
+		//splitVector can't be run through mongos, or without the privilege
+		//to, so fall back on sampling _id values
+		keys, err = getSampledKeys(collection, partitions*samplesPerPartition)
+		if err != nil && isSampleUnavailable(err) {
+			fmt.Fprintf(os.Stderr, "warning: the collection can not be "+
+				"partitioned, as splitVector can not be run and $sample "+
+				"needs MongoDB 3.2 or later; exporting it in a single "+
+				"partition\n")
+			return nil, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error finding the boundaries of the "+
+				"partitions: %v", err)
+		}
+	}
+	return pickBoundaries(keys, partitions), nil
+}
+
+//getSplitVectorKeys returns the _id values the server's splitVector command
+//splits the collection at, for chunks of about 1/partitions of its size.
+func getSplitVectorKeys(collection *mgo.Collection, partitions int) ([]interface{}, error) {
+	var stats struct {
+		Size int64 `bson:"size"`
+	}
+	err := collection.Database.Run(bson.D{
+		{Name: "collStats", Value: collection.Name},
+	}, &stats)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := stats.Size / int64(partitions)
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+
+	var result struct {
+		SplitKeys []bson.M `bson:"splitKeys"`
+	}
+	err = collection.Database.Run(bson.D{
+		{Name: "splitVector", Value: collection.FullName},
+		{Name: "keyPattern", Value: bson.M{"_id": 1}},
+		{Name: "maxChunkSizeBytes", Value: chunkSize},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, 0, len(result.SplitKeys))
+	for _, key := range result.SplitKeys {
+		keys = append(keys, key["_id"])
+	}
+	return keys, nil
+}
+
+//isSplitVectorUnavailable returns whether the given error, from running
+//splitVector, means that the command can't be run at all - because it was
+//sent to a mongos, or the user isn't authorized to run it - rather than that
+//it failed.
+func isSplitVectorUnavailable(err error) bool {
+	queryErr, ok := err.(*mgo.QueryError)
+	if !ok {
+		return false
+	}
+	switch queryErr.Code {
+	case errorCodeUnauthorized, errorCodeCommandNotFound:
+		return true
+	}
+	//older servers give these errors without a code
+	message := strings.ToLower(queryErr.Message)
+	for _, unavailable := range []string{"no such cmd", "no such command",
+		"not authorized", "unauthorized"} {
+		if strings.Contains(message, unavailable) {
+			return true
+		}
+	}
+	return false
+}
+
+//getSampledKeys returns a random sample of the _id values of the collection,
+//in index order and without duplicates.
+func getSampledKeys(collection *mgo.Collection, size int) ([]interface{}, error) {
+	var samples []struct {
+		Id interface{} `bson:"_id"`
+	}
+	err := collection.Pipe([]bson.M{
+		{"$sample": bson.M{"size": size}},
+		{"$project": bson.M{"_id": 1}},
+		{"$sort": bson.M{"_id": 1}},
+	}).All(&samples)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]interface{}, 0, len(samples))
+	for _, sample := range samples {
+		//$sample may return the same document more than once
+		if len(keys) > 0 && reflect.DeepEqual(keys[len(keys)-1], sample.Id) {
+			continue
+		}
+		keys = append(keys, sample.Id)
+	}
+	return keys, nil
+}
+
+//isSampleUnavailable returns whether the given error, from sampling _id
+//values, means that the server does not support the $sample stage.
+func isSampleUnavailable(err error) bool {
+	queryErr, ok := err.(*mgo.QueryError)
+	if !ok {
+		return false
+	}
+	return queryErr.Code == errorCodeUnrecognizedStage ||
+		strings.Contains(strings.ToLower(queryErr.Message),
+			"unrecognized pipeline stage name")
+}
+
+//pickBoundaries picks the keys, out of the given ordered keys, that split the
+//collection into the given number of partitions most evenly - assuming the
+//keys split it evenly to begin with. All the keys are picked if there are too
+//few of them.
+func pickBoundaries(keys []interface{}, partitions int) []interface{} {
+	if len(keys) < partitions {
+		return keys
+	}
+	//the keys split the collection into len(keys)+1 pieces
+	boundaries := make([]interface{}, 0, partitions-1)
+	for index := 1; index < partitions; index++ {
+		boundaries = append(boundaries, keys[index*(len(keys)+1)/partitions-1])
+	}
+	return boundaries
+}
+
+//partitionRanges returns the ranges between the given ordered boundaries,
+//from the start of the collection to its end.
+func partitionRanges(boundaries []interface{}) []partitionRange {
+	ranges := make([]partitionRange, len(boundaries)+1)
+	for index, boundary := range boundaries {
+		key := bson.M{"_id": boundary}
+		ranges[index].Max = key
+		ranges[index+1].Min = key
+	}
+	return ranges
+}
+
+//getPartitionFileName returns the name of the output file of a partition: the
+//output file name, numbered before its extension, e.g. "out.2.json".
+func getPartitionFileName(outputFile string, index int) string {
+	extension := filepath.Ext(outputFile)
+	return fmt.Sprintf("%v.%v%v", strings.TrimSuffix(outputFile, extension),
+		index, extension)
+}
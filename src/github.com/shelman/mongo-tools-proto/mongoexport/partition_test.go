@@ -0,0 +1,137 @@
+package mongoexport
+
+import (
+	commonopts "github.com/shelman/mongo-tools-proto/common/options"
+	"github.com/shelman/mongo-tools-proto/mongoexport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestPickBoundaries(t *testing.T) {
+	Convey("Boundaries should split the keys as evenly as possible", t, func() {
+		keys := []interface{}{1, 2, 3, 4, 5, 6, 7}
+		So(pickBoundaries(keys, 2), ShouldResemble, []interface{}{4})
+		So(pickBoundaries(keys, 4), ShouldResemble, []interface{}{2, 4, 6})
+		So(pickBoundaries(keys, 8), ShouldResemble, keys)
+	})
+
+	Convey("All the keys should be picked if there are too few", t, func() {
+		So(pickBoundaries([]interface{}{1, 2}, 5), ShouldResemble,
+			[]interface{}{1, 2})
+		So(pickBoundaries(nil, 3), ShouldBeEmpty)
+	})
+}
+
+func TestPartitionRanges(t *testing.T) {
+	Convey("Ranges should cover the collection from end to end", t, func() {
+		So(partitionRanges(nil), ShouldResemble, []partitionRange{{}})
+		So(partitionRanges([]interface{}{"m", "t"}), ShouldResemble,
+			[]partitionRange{
+				{Max: bson.M{"_id": "m"}},
+				{Min: bson.M{"_id": "m"}, Max: bson.M{"_id": "t"}},
+				{Min: bson.M{"_id": "t"}},
+			})
+	})
+}
+
+func TestIsSplitVectorUnavailable(t *testing.T) {
+	Convey("Running through mongos or unauthorized should allow sampling", t,
+		func() {
+			for _, err := range []error{
+				&mgo.QueryError{Code: 59, Message: "no such command: splitVector"},
+				&mgo.QueryError{Message: "no such cmd: splitVector"},
+				&mgo.QueryError{Code: 13, Message: "not authorized on db"},
+				&mgo.QueryError{Message: "unauthorized"},
+			} {
+				So(isSplitVectorUnavailable(err), ShouldBeTrue)
+			}
+		})
+
+	Convey("Other errors should not allow sampling", t, func() {
+		for _, err := range []error{
+			&mgo.QueryError{Code: 2, Message: "couldn't find index over splitting key"},
+			io.EOF,
+		} {
+			So(isSplitVectorUnavailable(err), ShouldBeFalse)
+		}
+	})
+}
+
+func TestIsSampleUnavailable(t *testing.T) {
+	Convey("Servers without $sample should export a single partition", t,
+		func() {
+			for _, err := range []error{
+				&mgo.QueryError{Code: 16436, Message: "exception: " +
+					"Unrecognized pipeline stage name: '$sample'"},
+				&mgo.QueryError{Message: "Unrecognized pipeline stage name: " +
+					"'$sample'"},
+			} {
+				So(isSampleUnavailable(err), ShouldBeTrue)
+			}
+		})
+
+	Convey("Other errors should not", t, func() {
+		for _, err := range []error{
+			&mgo.QueryError{Code: 13, Message: "not authorized on db"},
+			io.EOF,
+		} {
+			So(isSampleUnavailable(err), ShouldBeFalse)
+		}
+	})
+}
+
+func TestGetPartitionFileName(t *testing.T) {
+	Convey("Partition files should be numbered before their extension", t,
+		func() {
+			So(getPartitionFileName("out.json", 2), ShouldEqual, "out.2.json")
+			So(getPartitionFileName("dir.d/out", 0), ShouldEqual, "dir.d/out.0")
+		})
+}
+
+func TestValidatePartitionOptions(t *testing.T) {
+	Convey("With a MongoExport instance", t, func() {
+		exp := MongoExport{
+			ToolOptions: &commonopts.ToolOptions{
+				Namespace: &commonopts.Namespace{DB: "db", Collection: "c"},
+			},
+			OutputOpts: &options.OutputFormatOptions{},
+			InputOpts:  &options.InputOptions{},
+		}
+
+		Convey("partitions should be accepted, with a query", func() {
+			exp.InputOpts.Partitions = 4
+			exp.InputOpts.Query = `{"a": 1}`
+			So(exp.ValidateSettings(), ShouldBeNil)
+			exp.OutputOpts.SplitOutput = true
+			exp.OutputOpts.OutputFile = "out.json"
+			So(exp.ValidateSettings(), ShouldBeNil)
+		})
+
+		Convey("partitions should not be negative or combined with a sort, "+
+			"skip, limit or pipeline", func() {
+			for _, inputOpts := range []*options.InputOptions{
+				{Partitions: -1},
+				{Partitions: 2, Sort: `{"a": 1}`},
+				{Partitions: 2, Skip: 1},
+				{Partitions: 2, Limit: 1},
+				{Partitions: 2, Pipeline: `[{"$unwind": "$a"}]`},
+			} {
+				exp.InputOpts = inputOpts
+				So(exp.ValidateSettings(), ShouldNotBeNil)
+			}
+		})
+
+		Convey("split output should need partitions and an output file",
+			func() {
+				exp.OutputOpts.SplitOutput = true
+				exp.OutputOpts.OutputFile = "out.json"
+				So(exp.ValidateSettings(), ShouldNotBeNil)
+				exp.InputOpts.Partitions = 2
+				exp.OutputOpts.OutputFile = ""
+				So(exp.ValidateSettings(), ShouldNotBeNil)
+			})
+	})
+}
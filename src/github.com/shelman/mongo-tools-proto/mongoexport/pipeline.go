@@ -0,0 +1,134 @@
+package mongoexport
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/shelman/mongo-tools-proto/common/bson_ext"
+	"io"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+//extendedJSONKeys are the field names of the documents that stand for special
+//BSON values in extended JSON, e.g. {"$oid": "..."} or {"$date": "..."}.
+var extendedJSONKeys = map[string]bool{
+	"$date":       true,
+	"$oid":        true,
+	"$undefined":  true,
+	"$maxKey":     true,
+	"$minKey":     true,
+	"$numberLong": true,
+	"$regex":      true,
+	"$options":    true,
+	"$binary":     true,
+	"$type":       true,
+}
+
+//getPipelineFromArg takes an aggregation pipeline - either inline, as a JSON
+//array of stages, or the name of a file containing one - and converts it to
+//the stages to pass to the aggregate command. Returns an error if the pipeline
+//is not valid JSON, or extended JSON.
+func getPipelineFromArg(pipelineRaw string) ([]bson.D, error) {
+	if !strings.HasPrefix(strings.TrimSpace(pipelineRaw), "[") {
+		contents, err := ioutil.ReadFile(pipelineRaw)
+		if err != nil {
+			return nil, fmt.Errorf("error reading pipeline file: %v", err)
+		}
+		pipelineRaw = string(contents)
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(pipelineRaw))
+	token, err := decoder.Token()
+	if err != nil || token != json.Delim('[') {
+		return nil, fmt.Errorf("Pipeline is not a JSON array of stages")
+	}
+	pipeline := []bson.D{}
+	for decoder.More() {
+		stage, err := decodeOrderedJSON(decoder)
+		if err != nil {
+			return nil, fmt.Errorf("Pipeline is not valid JSON: %v", err)
+		}
+		stageDoc, ok := stage.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("Pipeline stage %v is not a document",
+				len(pipeline))
+		}
+		if len(stageDoc) != 1 {
+			return nil, fmt.Errorf("Pipeline stage %v must have exactly one "+
+				"field, the name of the stage", len(pipeline))
+		}
+		pipeline = append(pipeline, stageDoc)
+	}
+	if _, err = decoder.Token(); err != nil {
+		return nil, fmt.Errorf("Pipeline is not valid JSON: %v", err)
+	}
+	if _, err = decoder.Token(); err != io.EOF {
+		return nil, fmt.Errorf("Pipeline has trailing data after its stages")
+	}
+	return pipeline, nil
+}
+
+//decodeOrderedJSON decodes the next JSON value from the decoder, keeping the
+//order of the fields of documents - which matters within stages such as $sort
+//and $group - by decoding them as bson.D. Extended JSON values are converted
+//to the BSON values they stand for.
+func decodeOrderedJSON(decoder *json.Decoder) (interface{}, error) {
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch token {
+	case json.Delim('{'):
+		var doc bson.D
+		for decoder.More() {
+			key, err := decoder.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			doc = append(doc, bson.DocElem{Name: key.(string), Value: value})
+		}
+		if _, err = decoder.Token(); err != nil {
+			return nil, err
+		}
+		return convertExtendedJSON(doc)
+	case json.Delim('['):
+		array := []interface{}{}
+		for decoder.More() {
+			value, err := decodeOrderedJSON(decoder)
+			if err != nil {
+				return nil, err
+			}
+			array = append(array, value)
+		}
+		if _, err = decoder.Token(); err != nil {
+			return nil, err
+		}
+		return array, nil
+	}
+	return token, nil
+}
+
+//convertExtendedJSON returns the BSON value an extended JSON document stands
+//for, or the document itself if it is an ordinary document.
+func convertExtendedJSON(doc bson.D) (interface{}, error) {
+	if len(doc) == 0 {
+		return bson.D{}, nil
+	}
+	asMap := map[string]interface{}{}
+	for _, field := range doc {
+		if !extendedJSONKeys[field.Name] {
+			return doc, nil
+		}
+		asMap[field.Name] = field.Value
+	}
+	value, err := bson_ext.ParseExtendedJSON(asMap)
+	if err != nil {
+		return nil, fmt.Errorf("Error in pipeline: %v", err)
+	}
+	return value, nil
+}
@@ -0,0 +1,108 @@
+package mongoexport
+
+import (
+	"github.com/shelman/mongo-tools-proto/common/bson_ext"
+	commonopts "github.com/shelman/mongo-tools-proto/common/options"
+	"github.com/shelman/mongo-tools-proto/mongoexport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"testing"
+)
+
+func TestGetPipelineFromArg(t *testing.T) {
+	Convey("Parsing a pipeline should keep the fields of its stages in "+
+		"order", t, func() {
+		pipeline, err := getPipelineFromArg(`[
+			{"$match": {"n": {"$gt": {"$numberLong": "5"}}, "tags": {"$in": [{"$oid": "53cfd31a8dc6d9a3d3ab5d6a"}]}}},
+			{"$sort": {"b": 1, "a": -1}},
+			{"$group": {"_id": "$b", "total": {"$sum": 1}}}
+		]`)
+		So(err, ShouldBeNil)
+		So(pipeline, ShouldResemble, []bson.D{
+			{{Name: "$match", Value: bson.D{
+				{Name: "n", Value: bson.D{
+					{Name: "$gt", Value: bson_ext.NumberLongExt(5)},
+				}},
+				{Name: "tags", Value: bson.D{
+					{Name: "$in", Value: []interface{}{
+						bson.ObjectIdHex("53cfd31a8dc6d9a3d3ab5d6a"),
+					}},
+				}},
+			}}},
+			{{Name: "$sort", Value: bson.D{
+				{Name: "b", Value: 1.0},
+				{Name: "a", Value: -1.0},
+			}}},
+			{{Name: "$group", Value: bson.D{
+				{Name: "_id", Value: "$b"},
+				{Name: "total", Value: bson.D{{Name: "$sum", Value: 1.0}}},
+			}}},
+		})
+	})
+
+	Convey("A pipeline should be read from a file if it is not inline", t,
+		func() {
+			file, err := ioutil.TempFile("", "pipeline")
+			So(err, ShouldBeNil)
+			defer os.Remove(file.Name())
+			_, err = file.WriteString(`[{"$limit": 3}]`)
+			So(err, ShouldBeNil)
+			So(file.Close(), ShouldBeNil)
+
+			pipeline, err := getPipelineFromArg(file.Name())
+			So(err, ShouldBeNil)
+			So(pipeline, ShouldResemble, []bson.D{
+				{{Name: "$limit", Value: 3.0}},
+			})
+		})
+
+	Convey("Bad pipelines should cause an error", t, func() {
+		for _, pipelineRaw := range []string{`[1]`, `[{"$match": {}`,
+			`[{"$match": {}, "$limit": 1}]`, `[{}]`, `[] []`,
+			`[{"$match": {"d": {"$date": 5}}}]`, "no/such/pipeline/file"} {
+			_, err := getPipelineFromArg(pipelineRaw)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestValidatePipelineOptions(t *testing.T) {
+	Convey("With a MongoExport instance", t, func() {
+		exp := MongoExport{
+			ToolOptions: &commonopts.ToolOptions{
+				Namespace: &commonopts.Namespace{DB: "db", Collection: "c"},
+			},
+			OutputOpts: &options.OutputFormatOptions{},
+			InputOpts:  &options.InputOptions{},
+		}
+
+		Convey("a pipeline should be accepted, with or without disk use",
+			func() {
+				exp.InputOpts.Pipeline = `[{"$unwind": "$a"}]`
+				So(exp.ValidateSettings(), ShouldBeNil)
+				exp.InputOpts.AllowDiskUse = true
+				So(exp.ValidateSettings(), ShouldBeNil)
+			})
+
+		Convey("a pipeline should not be combined with a query, sort, skip "+
+			"or limit", func() {
+			for _, inputOpts := range []*options.InputOptions{
+				{Query: `{"a": 1}`},
+				{Sort: `{"a": 1}`},
+				{Skip: 1},
+				{Limit: 1},
+			} {
+				inputOpts.Pipeline = `[{"$unwind": "$a"}]`
+				exp.InputOpts = inputOpts
+				So(exp.ValidateSettings(), ShouldNotBeNil)
+			}
+		})
+
+		Convey("disk use should only be allowed with a pipeline", func() {
+			exp.InputOpts.AllowDiskUse = true
+			So(exp.ValidateSettings(), ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,78 @@
+package mongoexport
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/shelman/mongo-tools-proto/common/bson_ext"
+	"github.com/shelman/mongo-tools-proto/common/util"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"strings"
+)
+
+type TSVExportOutput struct {
+	//Fields is a list of field names in the bson documents to be exported.
+	//A field can also use dot-delimited modifiers to address nested structures,
+	//for example "location.city" or "addresses.0"
+	Fields []string
+
+	//NumExported maintains a running total of the number of documents written
+	NumExported int64
+
+	out *bufio.Writer
+}
+
+// NewTSVExportOutput returns a TSVExportOutput configured to write output to the
+// given io.Writer, extracting the specified fields only.
+func NewTSVExportOutput(fields []string, out io.Writer) *TSVExportOutput {
+	return &TSVExportOutput{
+		fields,
+		0,
+		bufio.NewWriter(out),
+	}
+}
+
+// WriteHeader writes a tab-delimited list of fields as the output header row
+func (tsvExporter *TSVExportOutput) WriteHeader() error {
+	return tsvExporter.writeRow(tsvExporter.Fields)
+}
+
+func (tsvExporter *TSVExportOutput) WriteFooter() error {
+	//no tsv footer
+	return nil
+}
+
+func (tsvExporter *TSVExportOutput) Flush() error {
+	return tsvExporter.out.Flush()
+}
+
+// ExportDocument writes a line to output with the TSV representation of a doc.
+// Tabs, line breaks and backslashes within values are escaped as \t, \n, \r
+// and \\, the way mongoimport expects them.
+func (tsvExporter *TSVExportOutput) ExportDocument(document bson.M) error {
+	rowOut := make([]string, 0, len(tsvExporter.Fields))
+	extendedDoc := bson_ext.GetExtendedBSON(document)
+	for _, fieldName := range tsvExporter.Fields {
+		fieldVal, err := extractFieldByName(fieldName, extendedDoc)
+		if err != nil {
+			return err
+		}
+		rowOut = append(rowOut, fmt.Sprintf("%v", fieldVal))
+	}
+	err := tsvExporter.writeRow(rowOut)
+	if err != nil {
+		return err
+	}
+	tsvExporter.NumExported++
+	return nil
+}
+
+// writeRow writes the given values, escaped, as a single line of output
+func (tsvExporter *TSVExportOutput) writeRow(values []string) error {
+	escaped := make([]string, 0, len(values))
+	for _, value := range values {
+		escaped = append(escaped, util.EscapeTSV(value))
+	}
+	_, err := tsvExporter.out.WriteString(strings.Join(escaped, "\t") + "\n")
+	return err
+}
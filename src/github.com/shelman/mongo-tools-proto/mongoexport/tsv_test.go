@@ -0,0 +1,45 @@
+package mongoexport
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestWriteTSV(t *testing.T) {
+	Convey("With a TSV export output", t, func() {
+		fields := []string{"_id", "x", "z.1.a"}
+		out := &bytes.Buffer{}
+
+		Convey("Headers should be written correctly", func() {
+			tsvExporter := NewTSVExportOutput(fields, out)
+			err := tsvExporter.WriteHeader()
+			So(err, ShouldBeNil)
+			tsvExporter.Flush()
+			So(out.String(), ShouldEqual, "_id\tx\tz.1.a\n")
+		})
+
+		Convey("Exported document with missing fields should print as blank", func() {
+			tsvExporter := NewTSVExportOutput(fields, out)
+			tsvExporter.ExportDocument(bson.M{"_id": "12345"})
+			tsvExporter.WriteFooter()
+			tsvExporter.Flush()
+			So(out.String(), ShouldEqual, "12345\t\t\n")
+		})
+
+		Convey("Tabs, line breaks and backslashes should be escaped", func() {
+			tsvExporter := NewTSVExportOutput(fields, out)
+			tsvExporter.ExportDocument(bson.M{"_id": 1, "x": "a\tb\r\nc\\d",
+				"z": []interface{}{"x", bson.M{"a": "T"}}})
+			tsvExporter.Flush()
+			So(out.String(), ShouldEqual, `1	a\tb\r\nc\\d	T`+"\n")
+			So(tsvExporter.NumExported, ShouldEqual, 1)
+		})
+
+		Reset(func() {
+			out.Reset()
+		})
+
+	})
+}
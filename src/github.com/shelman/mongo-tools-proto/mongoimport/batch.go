@@ -0,0 +1,318 @@
+package mongoimport
+
+import (
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+)
+
+const (
+	// MaxBSONSize is the largest document the server will accept, and also
+	// the largest write command it will accept
+	MaxBSONSize = 16 * 1024 * 1024
+
+	// commandOverhead is the room kept within MaxBSONSize for the rest of a
+	// write command: its other fields and the array holding its documents
+	commandOverhead = 16 * 1024
+
+	// statementOverhead is the most an update or delete statement adds to
+	// the size of its document and selector: its array index and the names
+	// of its fields, along with the other values of a delete
+	statementOverhead = 32
+
+	// MaxWriteBatchSize is the largest number of documents the server will
+	// accept in a single write command
+	MaxWriteBatchSize = 1000
+)
+
+// writeOp identifies the kind of write command a batch is sent with
+type writeOp int
+
+const (
+	insertOp writeOp = iota
+	// upsertOp replaces the document matching the selector
+	upsertOp
+	// mergeOp sets the document's fields on the document matching the
+	// selector
+	mergeOp
+	// deleteOp removes all documents matching the selector
+	deleteOp
+)
+
+// pendingDocument is a decoded document on its way to an insertion worker,
+// along with how it should be written
+type pendingDocument struct {
+	op       writeOp
+	document bson.M
+	// selector is the query matching existing documents (all but insertOp)
+	selector bson.M
+	// record is the input record the document was read from
+	record InputRecord
+	// raw, if set, is the document exactly as it was read from BSON input
+	raw bson.Raw
+}
+
+// body returns what is sent to the server for the document: the document
+// itself for inserts and upserts, the update merging it for merges and the
+// selector for deletes
+func (pending pendingDocument) body() bson.M {
+	switch pending.op {
+	case mergeOp:
+		return constructMergeDocument(pending.document)
+	case deleteOp:
+		return pending.selector
+	}
+	return pending.document
+}
+
+// encode returns the BSON encoding of the body of the document - its raw form
+// if it has one and the body is the document itself
+func (pending pendingDocument) encode() (bson.Raw, error) {
+	if pending.raw.Data != nil &&
+		(pending.op == insertOp || pending.op == upsertOp) {
+		return pending.raw, nil
+	}
+	return encodeDocument(pending.body())
+}
+
+// writeError holds a single entry of the 'writeErrors' array returned by the
+// server for a write command. Index refers to the position of the failed
+// document in the batch that was sent.
+type writeError struct {
+	Index  int    `bson:"index"`
+	Code   int    `bson:"code"`
+	ErrMsg string `bson:"errmsg"`
+}
+
+func (we *writeError) Error() string {
+	return we.ErrMsg
+}
+
+// writeConcernError is the 'writeConcernError' returned by the server for a
+// write command whose writes were applied but could not be confirmed to
+// satisfy the write concern
+type writeConcernError struct {
+	Code   int    `bson:"code"`
+	ErrMsg string `bson:"errmsg"`
+}
+
+func (wce *writeConcernError) Error() string {
+	return fmt.Sprintf("write concern error: %v", wce.ErrMsg)
+}
+
+// writeCommandResult is the server's response to an insert, update or delete
+// command
+type writeCommandResult struct {
+	N                 int                `bson:"n"`
+	WriteErrors       []writeError       `bson:"writeErrors"`
+	WriteConcernError *writeConcernError `bson:"writeConcernError"`
+}
+
+// documentBatch buffers documents that are to be written to the server in a
+// single write command. A batch is full once adding another document would
+// exceed either its document count or byte size limit. The byte size limit
+// applies to the documents alone, so it is capped below MaxBSONSize to leave
+// room for the rest of the command. For every buffered document, the batch
+// keeps track of the input record it was read from so that write errors can be
+// attributed to that record.
+type documentBatch struct {
+	// op is the kind of write command used to send all the documents
+	op writeOp
+	// ordered makes the server stop at the first document that fails to be
+	// written rather than carry on with the rest of the batch
+	ordered bool
+	// documents holds the BSON encoding of each document's body in the batch
+	documents []bson.Raw
+	// selectors holds the query for each document (all but insertOp)
+	selectors []bson.M
+	// records holds the input record for each document
+	records []InputRecord
+	// size is the sum of the sizes of all statements in the batch
+	size int
+	// maxDocs is the maximum number of documents in the batch
+	maxDocs int
+	// maxBytes is the maximum number of bytes in the batch
+	maxBytes int
+}
+
+// newDocumentBatch returns an empty, ordered documentBatch with the given
+// limits. Limits that are unset or above what the server accepts are capped
+// accordingly.
+func newDocumentBatch(maxDocs, maxBytes int) *documentBatch {
+	if maxDocs <= 0 || maxDocs > MaxWriteBatchSize {
+		maxDocs = MaxWriteBatchSize
+	}
+	if maxBytes <= 0 || maxBytes > MaxBSONSize-commandOverhead {
+		maxBytes = MaxBSONSize - commandOverhead
+	}
+	return &documentBatch{
+		ordered:  true,
+		maxDocs:  maxDocs,
+		maxBytes: maxBytes,
+	}
+}
+
+// Len returns the number of documents currently in the batch
+func (batch *documentBatch) Len() int {
+	return len(batch.documents)
+}
+
+// statementSize returns the number of bytes a document of the given operation
+// takes up in a write command: its own size, plus that of its selector and the
+// rest of its statement for updates and deletes
+func statementSize(op writeOp, document bson.Raw, selector bson.M) int {
+	switch op {
+	case upsertOp, mergeOp:
+		// an unencodable selector fails the write command itself
+		encoded, _ := bson.Marshal(selector)
+		return len(document.Data) + len(encoded) + statementOverhead
+	case deleteOp:
+		// the document is the selector of a delete
+		return len(document.Data) + statementOverhead
+	}
+	return len(document.Data)
+}
+
+// Fits returns true if a document of the given operation and statement size
+// can be added to the batch without exceeding its limits. An empty batch
+// accepts any document so that oversized documents still reach the server,
+// which then reports them as write errors.
+func (batch *documentBatch) Fits(op writeOp, size int) bool {
+	if batch.Len() == 0 {
+		return true
+	}
+	return batch.op == op &&
+		batch.Len() < batch.maxDocs &&
+		batch.size+size <= batch.maxBytes
+}
+
+// Add appends an encoded document to the batch. The caller must ensure the
+// document Fits beforehand.
+func (batch *documentBatch) Add(op writeOp, document bson.Raw, selector bson.M,
+	record InputRecord) {
+	batch.op = op
+	batch.documents = append(batch.documents, document)
+	batch.selectors = append(batch.selectors, selector)
+	batch.records = append(batch.records, record)
+	batch.size += statementSize(op, document, selector)
+}
+
+// Reset empties the batch so that it can be reused
+func (batch *documentBatch) Reset() {
+	batch.documents = batch.documents[:0]
+	batch.selectors = batch.selectors[:0]
+	batch.records = batch.records[:0]
+	batch.size = 0
+}
+
+// command returns the write command for the documents in the batch from the
+// given index onward
+func (batch *documentBatch) command(collection *mgo.Collection,
+	from int) bson.D {
+	switch batch.op {
+	case upsertOp, mergeOp:
+		updates := make([]bson.M, 0, batch.Len()-from)
+		for index := from; index < batch.Len(); index++ {
+			updates = append(updates, bson.M{
+				"q":      batch.selectors[index],
+				"u":      batch.documents[index],
+				"upsert": true,
+			})
+		}
+		return bson.D{
+			{Name: "update", Value: collection.Name},
+			{Name: "updates", Value: updates},
+			{Name: "ordered", Value: batch.ordered},
+		}
+	case deleteOp:
+		deletes := make([]bson.M, 0, batch.Len()-from)
+		for index := from; index < batch.Len(); index++ {
+			deletes = append(deletes, bson.M{
+				"q":     batch.documents[index],
+				"limit": 0,
+			})
+		}
+		return bson.D{
+			{Name: "delete", Value: collection.Name},
+			{Name: "deletes", Value: deletes},
+			{Name: "ordered", Value: batch.ordered},
+		}
+	}
+	return bson.D{
+		{Name: "insert", Value: collection.Name},
+		{Name: "documents", Value: batch.documents[from:]},
+		{Name: "ordered", Value: batch.ordered},
+	}
+}
+
+// Write sends the batch to the given collection as write commands, with the
+// write concern of the collection's session. The server stops applying an
+// ordered write at the first document that fails; when that happens the error
+// is passed to onError along with the input record of the offending document,
+// and - unless onError returns a non-nil error - the rest of the batch is
+// resent. An unordered write carries on past failed documents, so their
+// errors are all passed to onError in turn. A write that does not satisfy the
+// write concern is an error. Write returns the number of documents
+// successfully written - or, for deletes, the number of documents the server
+// reports as deleted, as a selector may match any number of them.
+func (batch *documentBatch) Write(collection *mgo.Collection,
+	onError func(record InputRecord, err error) error) (int64, error) {
+	writeConcern := writeConcernDocument(collection.Database.Session.Safe())
+	written := int64(0)
+	for from := 0; from < batch.Len(); {
+		command := batch.command(collection, from)
+		if writeConcern != nil {
+			command = append(command, bson.DocElem{Name: "writeConcern",
+				Value: writeConcern})
+		}
+		result := writeCommandResult{}
+		err := collection.Database.Run(command, &result)
+		if err != nil {
+			return written, err
+		}
+		if batch.op == deleteOp {
+			written += int64(result.N)
+		}
+		if result.WriteConcernError != nil {
+			return written, result.WriteConcernError
+		}
+		if len(result.WriteErrors) == 0 {
+			if batch.op != deleteOp {
+				written += int64(batch.Len() - from)
+			}
+			break
+		}
+		if !batch.ordered {
+			if batch.op != deleteOp {
+				written += int64(batch.Len() - from - len(result.WriteErrors))
+			}
+			for index := range result.WriteErrors {
+				failed := from + result.WriteErrors[index].Index
+				err = onError(batch.records[failed], &result.WriteErrors[index])
+				if err != nil {
+					return written, err
+				}
+			}
+			break
+		}
+		failed := from + result.WriteErrors[0].Index
+		if batch.op != deleteOp {
+			written += int64(failed - from)
+		}
+		err = onError(batch.records[failed], &result.WriteErrors[0])
+		if err != nil {
+			return written, err
+		}
+		from = failed + 1
+	}
+	return written, nil
+}
+
+// encodeDocument returns the BSON encoding of the given document
+func encodeDocument(document bson.M) (bson.Raw, error) {
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return bson.Raw{}, fmt.Errorf("error encoding document: %v", err)
+	}
+	return bson.Raw{Kind: 0x03, Data: data}, nil
+}
@@ -0,0 +1,141 @@
+package mongoimport
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestDocumentBatch(t *testing.T) {
+	Convey("Given a document batch", t, func() {
+		Convey("unset or out of range limits should be capped at the server "+
+			"limits", func() {
+			batch := newDocumentBatch(0, MaxBSONSize+1)
+			So(batch.maxDocs, ShouldEqual, MaxWriteBatchSize)
+			So(batch.maxBytes, ShouldEqual, MaxBSONSize-commandOverhead)
+		})
+		Convey("the byte size limit should leave room for the rest of the "+
+			"write command", func() {
+			batch := newDocumentBatch(0, MaxBSONSize)
+			So(batch.maxBytes, ShouldBeLessThan, MaxBSONSize)
+			So(newDocumentBatch(0, 1024).maxBytes, ShouldEqual, 1024)
+		})
+		Convey("no more documents should fit once the document count limit "+
+			"is reached", func() {
+			batch := newDocumentBatch(2, 0)
+			document, err := encodeDocument(bson.M{"a": 1})
+			So(err, ShouldBeNil)
+			batch.Add(insertOp, document, nil, InputRecord{Number: 1})
+			So(batch.Fits(insertOp, len(document.Data)), ShouldBeTrue)
+			batch.Add(insertOp, document, nil, InputRecord{Number: 2})
+			So(batch.Fits(insertOp, len(document.Data)), ShouldBeFalse)
+			So(batch.Len(), ShouldEqual, 2)
+		})
+		Convey("no more documents should fit once the byte size limit would "+
+			"be exceeded", func() {
+			document, err := encodeDocument(bson.M{"a": 1})
+			So(err, ShouldBeNil)
+			batch := newDocumentBatch(0, 2*len(document.Data)-1)
+			batch.Add(insertOp, document, nil, InputRecord{Number: 1})
+			So(batch.Fits(insertOp, len(document.Data)), ShouldBeFalse)
+		})
+		Convey("an empty batch should accept a document over the byte size "+
+			"limit", func() {
+			batch := newDocumentBatch(0, 1)
+			So(batch.Fits(insertOp, 2), ShouldBeTrue)
+		})
+		Convey("documents for a different write operation should not fit",
+			func() {
+				batch := newDocumentBatch(0, 0)
+				document, err := encodeDocument(bson.M{"a": 1})
+				So(err, ShouldBeNil)
+				batch.Add(insertOp, document, nil, InputRecord{Number: 1})
+				So(batch.Fits(upsertOp, len(document.Data)), ShouldBeFalse)
+			})
+		Convey("updates and deletes should count their whole statement "+
+			"towards the byte size limit", func() {
+			document, err := encodeDocument(bson.M{"a": 1})
+			So(err, ShouldBeNil)
+			selector := bson.M{"_id": "some selector"}
+			So(statementSize(insertOp, document, selector), ShouldEqual,
+				len(document.Data))
+			// the statements, as the last of a full batch, in a document of
+			// their own which only adds its length and terminating byte
+			for op, statement := range map[writeOp]bson.M{
+				upsertOp: {"q": selector, "u": document, "upsert": true},
+				mergeOp:  {"q": selector, "u": document, "upsert": true},
+				deleteOp: {"q": document, "limit": 0},
+			} {
+				encoded, err := bson.Marshal(bson.M{"999": statement})
+				So(err, ShouldBeNil)
+				So(statementSize(op, document, selector),
+					ShouldBeGreaterThanOrEqualTo, len(encoded)-5)
+			}
+
+			batch := newDocumentBatch(0, 0)
+			batch.Add(upsertOp, document, selector, InputRecord{Number: 1})
+			So(batch.size, ShouldEqual, statementSize(upsertOp, document,
+				selector))
+		})
+		Convey("resetting the batch should remove all documents", func() {
+			batch := newDocumentBatch(0, 0)
+			document, err := encodeDocument(bson.M{"a": 1})
+			So(err, ShouldBeNil)
+			batch.Add(insertOp, document, nil, InputRecord{Number: 1})
+			batch.Reset()
+			So(batch.Len(), ShouldEqual, 0)
+			So(batch.size, ShouldEqual, 0)
+		})
+		Convey("merges should be sent as upserting update commands", func() {
+			batch := newDocumentBatch(0, 0)
+			pending := pendingDocument{op: mergeOp,
+				document: bson.M{"_id": 1, "a": 2}, selector: bson.M{"_id": 1}}
+			body, err := encodeDocument(pending.body())
+			So(err, ShouldBeNil)
+			batch.Add(mergeOp, body, pending.selector, InputRecord{Number: 1})
+			command := batch.command(&mgo.Collection{Name: "c"}, 0)
+			So(command[0], ShouldResemble, bson.DocElem{Name: "update",
+				Value: "c"})
+			updates := command[1].Value.([]bson.M)
+			So(len(updates), ShouldEqual, 1)
+			So(updates[0]["q"], ShouldResemble, bson.M{"_id": 1})
+			So(updates[0]["u"], ShouldResemble, body)
+			So(updates[0]["upsert"], ShouldBeTrue)
+		})
+		Convey("deletes should be sent as delete commands matching the "+
+			"selectors", func() {
+			batch := newDocumentBatch(0, 0)
+			for number := int64(1); number <= 2; number++ {
+				pending := pendingDocument{op: deleteOp,
+					document: bson.M{"a": number, "b": "x"},
+					selector: bson.M{"a": number}}
+				body, err := encodeDocument(pending.body())
+				So(err, ShouldBeNil)
+				batch.Add(deleteOp, body, pending.selector,
+					InputRecord{Number: number})
+			}
+			command := batch.command(&mgo.Collection{Name: "c"}, 1)
+			So(command[0], ShouldResemble, bson.DocElem{Name: "delete",
+				Value: "c"})
+			deletes := command[1].Value.([]bson.M)
+			So(len(deletes), ShouldEqual, 1)
+			selector := bson.M{}
+			So(deletes[0]["q"].(bson.Raw).Unmarshal(&selector), ShouldBeNil)
+			So(selector, ShouldResemble, bson.M{"a": int64(2)})
+			So(deletes[0]["limit"], ShouldEqual, 0)
+		})
+		Convey("unordered batches should be sent as unordered commands",
+			func() {
+				batch := newDocumentBatch(0, 0)
+				So(batch.ordered, ShouldBeTrue)
+				batch.ordered = false
+				body, err := encodeDocument(bson.M{"a": 1})
+				So(err, ShouldBeNil)
+				batch.Add(insertOp, body, nil, InputRecord{Number: 1})
+				command := batch.command(&mgo.Collection{Name: "c"}, 0)
+				So(command[2], ShouldResemble, bson.DocElem{Name: "ordered",
+					Value: false})
+			})
+	})
+}
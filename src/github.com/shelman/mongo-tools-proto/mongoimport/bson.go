@@ -0,0 +1,101 @@
+package mongoimport
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"labix.org/v2/mgo/bson"
+)
+
+// maxBSONInputSize is the largest document accepted in BSON input: the
+// server's own limit, which leaves room for the internal fields of documents
+// such as those of the oplog, is somewhat over MaxBSONSize. Anything larger
+// means the input is corrupt.
+const maxBSONInputSize = MaxBSONSize + 16*1024
+
+// BSONImportInput is an implementation of ImportInput that reads BSON
+// documents written back to back, as mongodump and mongoexport --bson write
+// them. Each record's raw text is the document's BSON encoding, so that it can
+// be written to the server exactly as it was read.
+type BSONImportInput struct {
+	// NumImported indicates the number of BSON documents successfully read
+	// from the input source
+	NumImported int64
+	in          io.Reader
+	// offset is the number of bytes read from the input source
+	offset int64
+	// lastRecord describes the document last read from the input source
+	lastRecord InputRecord
+}
+
+// NewBSONImportInput creates a new BSONImportInput configured to read
+// documents from the given io.Reader
+func NewBSONImportInput(in io.Reader) *BSONImportInput {
+	return &BSONImportInput{in: in}
+}
+
+// ResumeFrom continues reading BSON from the given io.Reader, which must be
+// positioned just after the given record
+func (bsonImporter *BSONImportInput) ResumeFrom(in io.Reader,
+	record InputRecord) {
+	bsonImporter.in = in
+	bsonImporter.offset = record.Offset
+	bsonImporter.lastRecord = record
+	bsonImporter.NumImported = record.Number
+}
+
+// LastRecord returns the BSON document last read from the input source
+func (bsonImporter *BSONImportInput) LastRecord() InputRecord {
+	return bsonImporter.lastRecord
+}
+
+// SetHeader is a no-op for BSON imports
+func (bsonImporter *BSONImportInput) SetHeader() error {
+	return nil
+}
+
+// ImportDocument reads the next BSON document from the input source. Since
+// documents are framed by their size, a document that can not be decoded can
+// be skipped - but not one whose size is invalid or that is cut short.
+func (bsonImporter *BSONImportInput) ImportDocument() (bson.M, error) {
+	var header [4]byte
+	_, err := io.ReadFull(bsonImporter.in, header[:])
+	if err == io.ErrUnexpectedEOF {
+		return nil, fmt.Errorf("BSON document at byte %v is cut short",
+			bsonImporter.offset)
+	} else if err != nil {
+		return nil, err
+	}
+	size := int64(binary.LittleEndian.Uint32(header[:]))
+	if size < 5 || size > maxBSONInputSize {
+		return nil, fmt.Errorf("invalid BSON document size %v at byte %v",
+			size, bsonImporter.offset)
+	}
+
+	// decoded documents may refer to the data, so it is never reused
+	data := make([]byte, size)
+	copy(data, header[:])
+	if _, err = io.ReadFull(bsonImporter.in, data[4:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("BSON document at byte %v is cut short",
+				bsonImporter.offset)
+		}
+		return nil, err
+	}
+	bsonImporter.offset += size
+	bsonImporter.lastRecord = InputRecord{
+		Raw:    data,
+		Offset: bsonImporter.offset,
+	}
+
+	document := bson.M{}
+	if data[size-1] != 0 {
+		return document, fmt.Errorf("BSON document is not terminated by a " +
+			"null byte")
+	}
+	if err = bson.Unmarshal(data, &document); err != nil {
+		return bson.M{}, fmt.Errorf("invalid BSON document: %v", err)
+	}
+	bsonImporter.NumImported++
+	return document, nil
+}
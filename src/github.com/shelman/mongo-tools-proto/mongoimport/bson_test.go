@@ -0,0 +1,126 @@
+package mongoimport
+
+import (
+	"bytes"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+// bsonInput returns the given documents encoded back to back
+func bsonInput(documents ...interface{}) []byte {
+	input := []byte{}
+	for _, document := range documents {
+		data, err := bson.Marshal(document)
+		So(err, ShouldBeNil)
+		input = append(input, data...)
+	}
+	return input
+}
+
+func TestBSONImportDocument(t *testing.T) {
+	Convey("With a BSON import input", t, func() {
+		Convey("documents should be read along with their raw form", func() {
+			first := bson.D{{Name: "b", Value: 1}, {Name: "a", Value: int64(2)}}
+			second := bson.M{"c": "three"}
+			input := bsonInput(first, second)
+			bsonImporter := NewBSONImportInput(bytes.NewReader(input))
+
+			document, err := bsonImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"b": 1, "a": int64(2)})
+			firstData, _ := bson.Marshal(first)
+			So(bsonImporter.LastRecord().Raw, ShouldResemble, firstData)
+			So(bsonImporter.LastRecord().Offset, ShouldEqual, len(firstData))
+
+			document, err = bsonImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document, ShouldResemble, second)
+			So(bsonImporter.LastRecord().Offset, ShouldEqual, len(input))
+
+			_, err = bsonImporter.ImportDocument()
+			So(err, ShouldEqual, io.EOF)
+			So(bsonImporter.NumImported, ShouldEqual, 2)
+		})
+
+		Convey("a document that can not be decoded should be skipped", func() {
+			input := bsonInput(bson.M{"a": 1}, bson.M{"b": 2})
+			// replace the type of the field "a" with an unknown one
+			input[4] = 0x7e
+			bsonImporter := NewBSONImportInput(bytes.NewReader(input))
+			document, err := bsonImporter.ImportDocument()
+			So(err, ShouldNotBeNil)
+			So(document, ShouldNotBeNil)
+			document, err = bsonImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"b": 2})
+		})
+
+		Convey("a document that is cut short or has an invalid size should "+
+			"stop the import", func() {
+			input := bsonInput(bson.M{"a": 1})
+			for _, badInput := range [][]byte{
+				input[:2],
+				input[:len(input)-1],
+				{1, 0, 0, 0, 0},
+				{0xff, 0xff, 0xff, 0x7f, 0},
+			} {
+				bsonImporter := NewBSONImportInput(bytes.NewReader(badInput))
+				document, err := bsonImporter.ImportDocument()
+				So(err, ShouldNotBeNil)
+				So(err, ShouldNotEqual, io.EOF)
+				So(document, ShouldBeNil)
+			}
+		})
+
+		Convey("reading should resume after a given record", func() {
+			input := bsonInput(bson.M{"a": 1}, bson.M{"b": 2})
+			bsonImporter := NewBSONImportInput(bytes.NewReader(input))
+			_, err := bsonImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			record := bsonImporter.LastRecord()
+			record.Number = 1
+
+			resumed := NewBSONImportInput(bytes.NewReader(nil))
+			resumed.ResumeFrom(bytes.NewReader(input[record.Offset:]), record)
+			document, err := resumed.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"b": 2})
+			So(resumed.LastRecord().Offset, ShouldEqual, len(input))
+			So(resumed.NumImported, ShouldEqual, 2)
+		})
+	})
+}
+
+func TestPendingDocumentEncode(t *testing.T) {
+	Convey("Given a document read from BSON", t, func() {
+		data, err := bson.Marshal(bson.D{{Name: "z", Value: 1},
+			{Name: "_id", Value: 2}})
+		So(err, ShouldBeNil)
+		raw := bson.Raw{Kind: 0x03, Data: data}
+		document := bson.M{"z": 1, "_id": 2}
+
+		Convey("inserts and upserts should send it as it was read", func() {
+			for _, op := range []writeOp{insertOp, upsertOp} {
+				pending := pendingDocument{op: op, document: document, raw: raw}
+				encoded, err := pending.encode()
+				So(err, ShouldBeNil)
+				So(encoded, ShouldResemble, raw)
+			}
+		})
+
+		Convey("merges and deletes should encode their own body", func() {
+			for _, op := range []writeOp{mergeOp, deleteOp} {
+				pending := pendingDocument{op: op, document: document,
+					selector: bson.M{"_id": 2}, raw: raw}
+				encoded, err := pending.encode()
+				So(err, ShouldBeNil)
+				// the body is a map, so only its fields can be compared
+				decoded := bson.M{}
+				So(bson.Unmarshal(encoded.Data, &decoded), ShouldBeNil)
+				So(decoded, ShouldResemble, pending.body())
+			}
+		})
+	})
+}
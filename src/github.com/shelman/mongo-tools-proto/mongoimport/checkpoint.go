@@ -0,0 +1,171 @@
+package mongoimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Checkpoint records how far an import has progressed through its input
+// source: every record up to and including Record has been dealt with - that
+// is, written to the server, or skipped or rejected because of an error.
+type Checkpoint struct {
+	// File is the absolute path of the input source
+	File string `json:"file"`
+	// Record is the number of the last record dealt with
+	Record int64 `json:"record"`
+	// Offset is the byte offset in File just past that record
+	Offset int64 `json:"offset"`
+	// LinesRead is the number of complete lines before Offset
+	LinesRead int64 `json:"linesRead"`
+}
+
+// inputRecord returns the position in the input source the checkpoint refers
+// to
+func (checkpoint *Checkpoint) inputRecord() InputRecord {
+	return InputRecord{
+		Number:    checkpoint.Record,
+		Offset:    checkpoint.Offset,
+		LinesRead: checkpoint.LinesRead,
+	}
+}
+
+// readCheckpoint reads the checkpoint stored at the given path. It returns a
+// nil Checkpoint if no such file exists.
+func readCheckpoint(path string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	checkpoint := &Checkpoint{}
+	if err = json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file '%v': %v", path, err)
+	}
+	return checkpoint, nil
+}
+
+// writeCheckpoint stores the given checkpoint at the given path. The file is
+// replaced atomically so that a crash never leaves a partial checkpoint.
+func writeCheckpoint(path string, checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	tempFile, err := ioutil.TempFile(filepath.Dir(path),
+		filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = tempFile.Write(append(data, '\n'))
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tempFile.Name())
+	}
+	return err
+}
+
+// seekInput advances the given input source to the given byte offset. Input
+// that can not be seeked - such as compressed input - is read up to the offset.
+func seekInput(in io.Reader, offset int64) error {
+	if seeker, ok := in.(io.Seeker); ok {
+		if _, err := seeker.Seek(offset, os.SEEK_SET); err == nil {
+			return nil
+		}
+	}
+	_, err := io.CopyN(ioutil.Discard, in, offset)
+	return err
+}
+
+// checkpointer keeps track of the input records dealt with by the insertion
+// workers, and periodically writes out a Checkpoint for the last record before
+// which every record has been dealt with. As the workers finish records out of
+// order, records past that point are held until the gap before them closes. A
+// checkpointer is safe for concurrent use.
+type checkpointer struct {
+	mutex sync.Mutex
+	// path is where checkpoints are written to
+	path string
+	// interval is the minimum time between two checkpoint writes
+	interval  time.Duration
+	lastWrite time.Time
+	// checkpoint is the latest checkpoint, which may not be written out yet
+	checkpoint Checkpoint
+	// done holds the records dealt with past the latest checkpoint
+	done map[int64]InputRecord
+}
+
+// newCheckpointer returns a checkpointer writing checkpoints for the given
+// input file to the given path, starting from the given record
+func newCheckpointer(path, file string, interval time.Duration,
+	start InputRecord) (*checkpointer, error) {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpointer{
+		path:      path,
+		interval:  interval,
+		lastWrite: time.Now(),
+		checkpoint: Checkpoint{
+			File:      absFile,
+			Record:    start.Number,
+			Offset:    start.Offset,
+			LinesRead: start.LinesRead,
+		},
+		done: make(map[int64]InputRecord),
+	}, nil
+}
+
+// Confirm marks the given records as dealt with, writing out a checkpoint if
+// the checkpoint interval has passed since the last one was written
+func (checkpoints *checkpointer) Confirm(records ...InputRecord) error {
+	checkpoints.mutex.Lock()
+	defer checkpoints.mutex.Unlock()
+	for _, record := range records {
+		record.Raw = nil
+		checkpoints.done[record.Number] = record
+	}
+	for {
+		record, ok := checkpoints.done[checkpoints.checkpoint.Record+1]
+		if !ok {
+			break
+		}
+		delete(checkpoints.done, record.Number)
+		checkpoints.checkpoint.Record = record.Number
+		checkpoints.checkpoint.Offset = record.Offset
+		checkpoints.checkpoint.LinesRead = record.LinesRead
+	}
+	if time.Since(checkpoints.lastWrite) < checkpoints.interval {
+		return nil
+	}
+	return checkpoints.write()
+}
+
+// Flush writes out the latest checkpoint
+func (checkpoints *checkpointer) Flush() error {
+	checkpoints.mutex.Lock()
+	defer checkpoints.mutex.Unlock()
+	return checkpoints.write()
+}
+
+func (checkpoints *checkpointer) write() error {
+	if err := writeCheckpoint(checkpoints.path,
+		checkpoints.checkpoint); err != nil {
+		return fmt.Errorf("error writing checkpoint: %v", err)
+	}
+	checkpoints.lastWrite = time.Now()
+	return nil
+}
@@ -0,0 +1,108 @@
+package mongoimport
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpointer(t *testing.T) {
+	Convey("Given a checkpointer", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "import.checkpoint")
+		checkpoints, err := newCheckpointer(path, "input.csv", 0,
+			InputRecord{Offset: 4, LinesRead: 1})
+		So(err, ShouldBeNil)
+
+		Convey("a missing checkpoint file should read as no checkpoint",
+			func() {
+				checkpoint, err := readCheckpoint(path)
+				So(err, ShouldBeNil)
+				So(checkpoint, ShouldBeNil)
+			})
+		Convey("the checkpoint should only advance past records without "+
+			"gaps before them", func() {
+			So(checkpoints.Confirm(InputRecord{Number: 2, Offset: 12,
+				LinesRead: 3}), ShouldBeNil)
+			checkpoint, err := readCheckpoint(path)
+			So(err, ShouldBeNil)
+			So(checkpoint.Record, ShouldEqual, 0)
+			So(checkpoint.Offset, ShouldEqual, 4)
+
+			So(checkpoints.Confirm(InputRecord{Number: 1, Offset: 8,
+				LinesRead: 2}), ShouldBeNil)
+			checkpoint, err = readCheckpoint(path)
+			So(err, ShouldBeNil)
+			So(checkpoint.Record, ShouldEqual, 2)
+			So(checkpoint.Offset, ShouldEqual, 12)
+			So(checkpoint.LinesRead, ShouldEqual, 3)
+			So(filepath.IsAbs(checkpoint.File), ShouldBeTrue)
+		})
+	})
+}
+
+func TestResumeFrom(t *testing.T) {
+	Convey("With an import input resumed after its first record", t, func() {
+		Convey("CSV input should continue with the next record", func() {
+			contents := "a,b\n1,\"x\ny\"\n2,z\n"
+			csvImporter := NewCSVImportInput(nil, strings.NewReader(contents))
+			So(csvImporter.SetHeader(), ShouldBeNil)
+			_, err := csvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			record := csvImporter.LastRecord()
+			record.Number = 1
+
+			resumed := NewCSVImportInput(csvImporter.Fields, nil)
+			resumed.ResumeFrom(strings.NewReader(contents[record.Offset:]),
+				record)
+			document, err := resumed.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document["a"], ShouldEqual, 2)
+			So(resumed.LastRecord().Line, ShouldEqual, 4)
+			So(resumed.LastRecord().Offset, ShouldEqual, len(contents))
+		})
+		Convey("TSV input should continue with the next record", func() {
+			contents := "1\tx\n2\ty\n"
+			tsvImporter := NewTSVImportInput([]string{"a", "b"},
+				strings.NewReader(contents))
+			_, err := tsvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			record := tsvImporter.LastRecord()
+
+			resumed := NewTSVImportInput([]string{"a", "b"}, nil)
+			resumed.ResumeFrom(strings.NewReader(contents[record.Offset:]),
+				record)
+			document, err := resumed.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document["a"], ShouldEqual, 2)
+			So(resumed.LastRecord().Line, ShouldEqual, 2)
+		})
+		Convey("JSON array input should continue with the next object, even "+
+			"on the same line", func() {
+			contents := "[{\"a\": 1}, {\"a\": 2},\n{\"a\": 3}]"
+			jsonImporter := NewJSONImportInput(true,
+				strings.NewReader(contents))
+			_, err := jsonImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			record := jsonImporter.LastRecord()
+			record.Number = 1
+
+			resumed := NewJSONImportInput(true, nil)
+			resumed.ResumeFrom(strings.NewReader(contents[record.Offset:]),
+				record)
+			document, err := resumed.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document["a"], ShouldEqual, 2)
+			So(resumed.LastRecord().Line, ShouldEqual, 1)
+			document, err = resumed.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document["a"], ShouldEqual, 3)
+			So(resumed.LastRecord().Line, ShouldEqual, 2)
+		})
+	})
+}
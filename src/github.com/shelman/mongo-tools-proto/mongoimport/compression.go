@@ -0,0 +1,211 @@
+package mongoimport
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// values accepted by --inputCompression
+const (
+	AutoCompression  = "auto"
+	NoCompression    = "none"
+	GzipCompression  = "gzip"
+	Bzip2Compression = "bzip2"
+	ZipCompression   = "zip"
+)
+
+// compressionMagic maps each compression format to the bytes every input in
+// that format starts with
+var compressionMagic = map[string][]byte{
+	GzipCompression:  {0x1f, 0x8b},
+	Bzip2Compression: []byte("BZh"),
+	ZipCompression:   []byte("PK\x03\x04"),
+}
+
+// compressionExtensions maps file extensions to the compression format they
+// denote
+var compressionExtensions = map[string]string{
+	".gz":  GzipCompression,
+	".bz2": Bzip2Compression,
+	".zip": ZipCompression,
+}
+
+// isValidCompression returns true if the given compression is one of the
+// values accepted by --inputCompression
+func isValidCompression(compression string) bool {
+	switch compression {
+	case AutoCompression, NoCompression, GzipCompression, Bzip2Compression,
+		ZipCompression:
+		return true
+	}
+	return false
+}
+
+// detectCompression returns the compression format of an input source, given
+// its first few bytes and - for files - its path. The magic bytes take
+// precedence over the file extension.
+func detectCompression(header []byte, path string) string {
+	for compression, magic := range compressionMagic {
+		if bytes.HasPrefix(header, magic) {
+			return compression
+		}
+	}
+	if compression, ok := compressionExtensions[strings.ToLower(
+		filepath.Ext(path))]; ok {
+		return compression
+	}
+	return NoCompression
+}
+
+// trimCompressionExtension removes the extension denoting a compression format
+// from the given file name, if it has one
+func trimCompressionExtension(fileName string) string {
+	extension := filepath.Ext(fileName)
+	if _, ok := compressionExtensions[strings.ToLower(extension)]; ok {
+		return strings.TrimSuffix(fileName, extension)
+	}
+	return fileName
+}
+
+// decompressedReader reads the decompressed contents of an input source. The
+// compression format is only detected - and the decompressor set up - on the
+// first read, so that merely opening an input such as stdin never blocks.
+// Closing a decompressedReader closes both the decompressor and the input
+// source.
+type decompressedReader struct {
+	in          io.ReadCloser
+	path        string
+	compression string
+	reader      io.Reader
+	// err is the error setting up the decompressor, if any
+	err     error
+	closers []io.Closer
+}
+
+// decompressInput returns a reader streaming the decompressed contents of the
+// given input source, read from the given path ("" for stdin). With
+// AutoCompression, the format is detected from the input itself.
+func decompressInput(in io.ReadCloser, path, compression string) io.ReadCloser {
+	return &decompressedReader{
+		in:          in,
+		path:        path,
+		compression: compression,
+		closers:     []io.Closer{in},
+	}
+}
+
+func (reader *decompressedReader) Read(p []byte) (int, error) {
+	if reader.reader == nil && reader.err == nil {
+		reader.err = reader.open()
+	}
+	if reader.err != nil {
+		return 0, reader.err
+	}
+	return reader.reader.Read(p)
+}
+
+// Seek moves to the given offset of an uncompressed file. Compressed input
+// and stdin can not be seeked.
+func (reader *decompressedReader) Seek(offset int64, whence int) (int64, error) {
+	if reader.reader == nil && reader.err == nil {
+		reader.err = reader.open()
+	}
+	if reader.err != nil {
+		return 0, reader.err
+	}
+	seeker, ok := reader.in.(io.Seeker)
+	if reader.compression != NoCompression || !ok || reader.path == "" {
+		return 0, fmt.Errorf("input can not be seeked")
+	}
+	position, err := seeker.Seek(offset, whence)
+	if err != nil {
+		return 0, err
+	}
+	reader.reader = bufio.NewReader(reader.in)
+	return position, nil
+}
+
+// open sets up the decompressor for the input source
+func (reader *decompressedReader) open() error {
+	bufferedIn := bufio.NewReader(reader.in)
+	if reader.compression == AutoCompression {
+		// a short or empty input is simply not compressed
+		header, _ := bufferedIn.Peek(4)
+		reader.compression = detectCompression(header, reader.path)
+	}
+	switch reader.compression {
+	case GzipCompression:
+		gzipReader, err := gzip.NewReader(bufferedIn)
+		if err != nil {
+			return fmt.Errorf("error reading gzip input: %v", err)
+		}
+		reader.reader = gzipReader
+		reader.closers = append(reader.closers, gzipReader)
+	case Bzip2Compression:
+		reader.reader = bzip2.NewReader(bufferedIn)
+	case ZipCompression:
+		entry, err := openZipEntry(reader.in, reader.path)
+		if err != nil {
+			return err
+		}
+		reader.reader = entry
+		reader.closers = append(reader.closers, entry)
+	default:
+		reader.reader = bufferedIn
+	}
+	return nil
+}
+
+func (reader *decompressedReader) Close() error {
+	var err error
+	for index := len(reader.closers) - 1; index >= 0; index-- {
+		if closeErr := reader.closers[index].Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// zipSource is an input source that a zip archive can be read from: a file,
+// possibly wrapped to count the bytes read from it
+type zipSource interface {
+	io.ReaderAt
+	Stat() (os.FileInfo, error)
+}
+
+// openZipEntry opens the single file in the zip archive read from the given
+// input source. A zip archive's directory is at its end, so the input source
+// must be a file rather than a stream.
+func openZipEntry(in io.Reader, path string) (io.ReadCloser, error) {
+	file, ok := in.(zipSource)
+	if !ok || path == "" {
+		return nil, fmt.Errorf("zip input can only be read from a file")
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	archive, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("error reading zip input: %v", err)
+	}
+	var entries []*zip.File
+	for _, entry := range archive.File {
+		if !entry.FileInfo().IsDir() {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) != 1 {
+		return nil, fmt.Errorf("zip input must contain exactly one file, "+
+			"found %v", len(entries))
+	}
+	return entries[0].Open()
+}
@@ -0,0 +1,109 @@
+package mongoimport
+
+import (
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestDetectCompression(t *testing.T) {
+	Convey("Given the start of an input source, on calling "+
+		"detectCompression", t, func() {
+		Convey("the magic bytes should determine the compression", func() {
+			So(detectCompression([]byte{0x1f, 0x8b, 8, 0}, "a.csv"),
+				ShouldEqual, GzipCompression)
+			So(detectCompression([]byte("BZh9"), ""), ShouldEqual,
+				Bzip2Compression)
+			So(detectCompression([]byte("PK\x03\x04"), ""), ShouldEqual,
+				ZipCompression)
+		})
+		Convey("the file extension should be used if the magic bytes are not "+
+			"recognized", func() {
+			So(detectCompression([]byte("1,2,"), "a.CSV.GZ"), ShouldEqual,
+				GzipCompression)
+			So(detectCompression([]byte("1,2,"), "a.csv"), ShouldEqual,
+				NoCompression)
+			So(detectCompression(nil, ""), ShouldEqual, NoCompression)
+		})
+		Convey("compression extensions should be trimmed from file names",
+			func() {
+				So(trimCompressionExtension("a.csv.bz2"), ShouldEqual, "a.csv")
+				So(trimCompressionExtension("a.csv"), ShouldEqual, "a.csv")
+			})
+	})
+}
+
+func TestDecompressInput(t *testing.T) {
+	Convey("Given compressed input files, on calling getInputReader", t,
+		func() {
+			readInput := func(file, compression string) (string, error) {
+				mongoImport := MongoImport{
+					InputOptions: &options.InputOptions{
+						File:             file,
+						InputCompression: compression,
+					},
+				}
+				in, err := mongoImport.getInputReader()
+				if err != nil {
+					return "", err
+				}
+				defer in.Close()
+				data, err := ioutil.ReadAll(in)
+				return string(data), err
+			}
+			for _, test := range []struct{ compressed, plain string }{
+				{"testdata/test.csv.gz", "testdata/test.csv"},
+				{"testdata/test.tsv.bz2", "testdata/test.tsv"},
+				{"testdata/test_plain.zip", "testdata/test_plain.json"},
+			} {
+				compressed, plain := test.compressed, test.plain
+				Convey(compressed+" should be decompressed", func() {
+					expected, err := ioutil.ReadFile(plain)
+					So(err, ShouldBeNil)
+					contents, err := readInput(compressed, AutoCompression)
+					So(err, ShouldBeNil)
+					So(contents, ShouldEqual, string(expected))
+				})
+			}
+			Convey("an explicit compression should override detection",
+				func() {
+					_, err := readInput("testdata/test.csv", GzipCompression)
+					So(err, ShouldNotBeNil)
+					contents, err := readInput("testdata/test.csv.gz",
+						NoCompression)
+					So(err, ShouldBeNil)
+					So(strings.HasPrefix(contents, "\x1f\x8b"), ShouldBeTrue)
+				})
+			Convey("zip input should be rejected if it is not a file", func() {
+				in := decompressInput(ioutil.NopCloser(strings.NewReader(
+					"PK\x03\x04")), "", AutoCompression)
+				_, err := ioutil.ReadAll(in)
+				So(err, ShouldNotBeNil)
+			})
+			Convey("uncompressed files should be seekable", func() {
+				file, err := os.Open("testdata/test.csv")
+				So(err, ShouldBeNil)
+				in := decompressInput(file, "testdata/test.csv",
+					AutoCompression)
+				defer in.Close()
+				So(seekInput(in, 6), ShouldBeNil)
+				data, err := ioutil.ReadAll(in)
+				So(err, ShouldBeNil)
+				So(string(data), ShouldStartWith, "3,5.4")
+			})
+			Convey("compressed files should be read up to the offset", func() {
+				file, err := os.Open("testdata/test.csv.gz")
+				So(err, ShouldBeNil)
+				in := decompressInput(file, "testdata/test.csv.gz",
+					AutoCompression)
+				defer in.Close()
+				So(seekInput(in, 6), ShouldBeNil)
+				data, err := ioutil.ReadAll(in)
+				So(err, ShouldBeNil)
+				So(string(data), ShouldStartWith, "3,5.4")
+			})
+		})
+}
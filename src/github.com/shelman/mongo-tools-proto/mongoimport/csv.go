@@ -1,7 +1,7 @@
 package mongoimport
 
 import (
-	"encoding/csv"
+	"fmt"
 	"io"
 	"labix.org/v2/mgo/bson"
 	"strconv"
@@ -13,53 +13,117 @@ import (
 type CSVImportInput struct {
 	// Fields is a list of field names in the BSON documents to be imported
 	Fields []string
+	// ColumnsHaveTypes indicates that header line entries declare the type of
+	// their column, e.g. "name.string()"
+	ColumnsHaveTypes bool
+	// parsers holds the parser for each of the Fields when the columns have
+	// declared types
+	parsers []FieldParser
+	// Dialect is the flavour of CSV the input source is written in
+	Dialect CSVDialect
 	// csvReader is the underlying reader used to read data in from the CSV
-	// or TSV file
-	csvReader *csv.Reader
+	// file
+	csvReader *csvRecordReader
+	// lineReader feeds csvReader, keeping track of the raw text of each record
+	lineReader *lineReader
+	// lastRecord describes the record last read from the input source
+	lastRecord InputRecord
 }
 
 // NewCSVImportInput returns a CSVImportInput configured to read input from the
 // given io.Reader, extracting the specified fields only.
 func NewCSVImportInput(fields []string, in io.Reader) *CSVImportInput {
-	csvReader := csv.NewReader(in)
-	// allow variable number of fields in document
-	csvReader.FieldsPerRecord = -1
-	return &CSVImportInput{
-		Fields:    fields,
-		csvReader: csvReader,
+	return NewCSVImportInputWithDialect(fields, DefaultCSVDialect, in)
+}
+
+// NewCSVImportInputWithDialect returns a CSVImportInput configured to read
+// input written in the given CSV dialect from the given io.Reader, extracting
+// the specified fields only.
+func NewCSVImportInputWithDialect(fields []string, dialect CSVDialect,
+	in io.Reader) *CSVImportInput {
+	csvImporter := &CSVImportInput{Fields: fields, Dialect: dialect}
+	csvImporter.setInput(newLineReader(in))
+	return csvImporter
+}
+
+// setInput makes the CSVImportInput read from the given lineReader. Records
+// may have a variable number of fields.
+func (csvImporter *CSVImportInput) setInput(lineReader *lineReader) {
+	csvImporter.csvReader = newCSVRecordReader(lineReader, csvImporter.Dialect)
+	csvImporter.lineReader = lineReader
+}
+
+// SkipLines skips the given number of lines at the current position of the
+// input source, regardless of their contents
+func (csvImporter *CSVImportInput) SkipLines(count int) error {
+	for i := 0; i < count; i++ {
+		if _, err := csvImporter.csvReader.readLine(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
 	}
+	return nil
+}
+
+// ResumeFrom continues reading the CSV from the given io.Reader, which must
+// be positioned just after the given record
+func (csvImporter *CSVImportInput) ResumeFrom(in io.Reader, record InputRecord) {
+	csvImporter.setInput(newLineReaderAt(in, record.Offset, record.LinesRead))
+	csvImporter.lastRecord = record
+}
+
+// readRecord reads the next record from the CSV, keeping track of its raw text
+func (csvImporter *CSVImportInput) readRecord() ([]string, error) {
+	csvImporter.lineReader.startCapture()
+	tokens, err := csvImporter.csvReader.Read()
+	csvImporter.lastRecord.Line, csvImporter.lastRecord.Raw =
+		csvImporter.lineReader.endCapture()
+	csvImporter.lastRecord.Offset = csvImporter.lineReader.offset
+	csvImporter.lastRecord.LinesRead = csvImporter.lineReader.lines
+	return tokens, err
+}
+
+// LastRecord returns the record last read from the CSV
+func (csvImporter *CSVImportInput) LastRecord() InputRecord {
+	return csvImporter.lastRecord
 }
 
 // SetHeader sets the header field for a CSV
 func (csvImporter *CSVImportInput) SetHeader() error {
-	headers, err := csvImporter.csvReader.Read()
+	headers, err := csvImporter.readRecord()
 	if err != nil {
 		return err
 	}
 
-	for _, header := range headers {
-		csvImporter.Fields = append(csvImporter.Fields, header)
+	if csvImporter.ColumnsHaveTypes {
+		fields, parsers, err := ParseTypedFields(headers)
+		if err != nil {
+			return err
+		}
+		csvImporter.Fields = append(csvImporter.Fields, fields...)
+		csvImporter.parsers = append(csvImporter.parsers, parsers...)
+	} else {
+		for _, header := range headers {
+			csvImporter.Fields = append(csvImporter.Fields, header)
+		}
 	}
-	return nil
+	return validateFields(csvImporter.Fields)
 }
 
 // ImportDocument reads a line of input with the CSV representation of a doc and
 // returns the BSON equivalent.
 func (csvImporter *CSVImportInput) ImportDocument() (bson.M, error) {
-	tokens, err := csvImporter.csvReader.Read()
+	tokens, err := csvImporter.readRecord()
+	if _, ok := err.(*csvSyntaxError); ok {
+		// the record was read in full, so it can be skipped
+		return bson.M{}, err
+	}
 	if err != nil {
 		return nil, err
 	}
-	document := bson.M{}
-	for index, token := range tokens {
-		parsedValue := getParsedValue(token)
-		if index < len(csvImporter.Fields) {
-			document[csvImporter.Fields[index]] = parsedValue
-		} else {
-			document["field"+strconv.Itoa(index)] = parsedValue
-		}
-	}
-	return document, nil
+	return tokensToBSON(csvImporter.Fields, csvImporter.parsers, tokens)
 }
 
 // getParsedValue returns the appropriate concrete type for the given token
@@ -77,3 +141,81 @@ func getParsedValue(token string) interface{} {
 	}
 	return token
 }
+
+// validateFields ensures that the given field names can be used to build a
+// document. A dot in a field name denotes a nested field, with numeric path
+// segments denoting array indexes - e.g. "addresses.0.city". It is an error for
+// a field to be both a value and a container of other fields (e.g. "a" and
+// "a.b"), or to be used as both an array and a subdocument (e.g. "a.0" and
+// "a.b").
+func validateFields(fields []string) error {
+	// containers maps each parent path to whether it holds an array
+	containers := map[string]bool{}
+	values := map[string]bool{}
+	for _, field := range fields {
+		values[field] = true
+		parts := strings.Split(field, ".")
+		for index, part := range parts {
+			if part == "" && len(parts) > 1 {
+				return fmt.Errorf("field '%v' has an empty path segment",
+					field)
+			}
+			if index == 0 {
+				continue
+			}
+			parent := strings.Join(parts[:index], ".")
+			isArray := isArrayIndex(part)
+			if wasArray, ok := containers[parent]; ok && wasArray != isArray {
+				return fmt.Errorf("field '%v' is used as both an array and a "+
+					"subdocument", parent)
+			}
+			containers[parent] = isArray
+		}
+	}
+	for field := range values {
+		if _, ok := containers[field]; ok {
+			return fmt.Errorf("field '%v' is used as both a value and a "+
+				"parent of other fields", field)
+		}
+	}
+	return nil
+}
+
+// isArrayIndex returns true if the given path segment is an array index
+func isArrayIndex(part string) bool {
+	index, err := strconv.Atoi(part)
+	return err == nil && index >= 0 && strconv.Itoa(index) == part
+}
+
+// setNestedValue sets the value of the given field in the document, creating
+// any subdocuments and arrays named along its dotted path. Numeric path
+// segments index into arrays, which are padded with nil values as necessary.
+// This is the reverse of mongoexport's extractFieldByName.
+func setNestedValue(document bson.M, field string, value interface{}) {
+	parts := strings.Split(field, ".")
+	document[parts[0]] = setPathValue(document[parts[0]], parts[1:], value)
+}
+
+// setPathValue returns container with the value set at the given path,
+// creating the container if it does not yet exist
+func setPathValue(container interface{}, path []string,
+	value interface{}) interface{} {
+	if len(path) == 0 {
+		return value
+	}
+	if isArrayIndex(path[0]) {
+		array, _ := container.([]interface{})
+		index, _ := strconv.Atoi(path[0])
+		for len(array) <= index {
+			array = append(array, nil)
+		}
+		array[index] = setPathValue(array[index], path[1:], value)
+		return array
+	}
+	subdocument, ok := asDocument(container)
+	if !ok {
+		subdocument = bson.M{}
+	}
+	subdocument[path[0]] = setPathValue(subdocument[path[0]], path[1:], value)
+	return subdocument
+}
@@ -0,0 +1,194 @@
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// CSVDialect describes the flavour of CSV an input source is written in
+type CSVDialect struct {
+	// Delimiter separates the fields of a record
+	Delimiter rune
+	// Quote encloses fields that contain delimiters, quotes or line breaks
+	Quote rune
+	// Escape makes the character following it literal. When it is the same
+	// as Quote, a quote is escaped by doubling it within a quoted field.
+	Escape rune
+	// Comment, if set, starts lines that are ignored
+	Comment rune
+	// TrimLeadingSpace ignores white space at the start of each field
+	TrimLeadingSpace bool
+	// LazyQuotes allows quotes within unquoted fields, and quotes that are
+	// not escaped within quoted fields
+	LazyQuotes bool
+}
+
+// DefaultCSVDialect is the CSV dialect of RFC 4180
+var DefaultCSVDialect = CSVDialect{
+	Delimiter: ',',
+	Quote:     '"',
+	Escape:    '"',
+}
+
+// csvSyntaxError describes a record that is not valid CSV in the reader's
+// dialect. The record has been read in full, so reading can carry on with the
+// next one.
+type csvSyntaxError struct {
+	Message string
+}
+
+func (err *csvSyntaxError) Error() string {
+	return err.Message
+}
+
+// csvRecordReader reads records from a CSV input source written in a given
+// dialect. Much like encoding/csv's Reader, it skips empty lines, and line
+// breaks within quoted fields are always read as '\n'.
+type csvRecordReader struct {
+	lines   *lineReader
+	in      *bufio.Reader
+	dialect CSVDialect
+}
+
+// newCSVRecordReader returns a csvRecordReader reading from the given
+// lineReader in the given dialect
+func newCSVRecordReader(lines *lineReader,
+	dialect CSVDialect) *csvRecordReader {
+	return &csvRecordReader{
+		lines:   lines,
+		in:      bufio.NewReader(lines),
+		dialect: dialect,
+	}
+}
+
+// readLine returns the next line of input without its line break. io.EOF is
+// only returned once there is no input left.
+func (reader *csvRecordReader) readLine() (string, error) {
+	line, err := reader.in.ReadString('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		return "", err
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), nil
+}
+
+// Read returns the fields of the next record. A record with a syntax error is
+// read up to the end of the line the error is found on, and a *csvSyntaxError
+// returned for it.
+func (reader *csvRecordReader) Read() ([]string, error) {
+	var line string
+	for {
+		var err error
+		line, err = reader.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line != "" && (reader.dialect.Comment == 0 ||
+			!strings.HasPrefix(line, string(reader.dialect.Comment))) {
+			break
+		}
+		// skipped lines are not part of the raw text of the record; bufio
+		// only ever reads a line at a time from the lineReader, so nothing
+		// beyond the skipped line has been captured
+		if reader.in.Buffered() == 0 {
+			reader.lines.discardCaptured()
+		}
+	}
+
+	var fields []string
+	for {
+		field, rest, more, err := reader.readField(line)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+		if !more {
+			return fields, nil
+		}
+		line = rest
+	}
+}
+
+// readField reads a single field from the start of the given line - and,
+// for quoted fields, from the following lines as needed. It returns the
+// field, the rest of the line after the delimiter ending the field and
+// whether there was such a delimiter.
+func (reader *csvRecordReader) readField(line string) (string, string, bool,
+	error) {
+	dialect := reader.dialect
+	if dialect.TrimLeadingSpace {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+	}
+	quote := string(dialect.Quote)
+	quoted := strings.HasPrefix(line, quote)
+	if quoted {
+		line = line[len(quote):]
+	}
+
+	field := bytes.Buffer{}
+	for {
+		if line == "" {
+			if !quoted {
+				return field.String(), "", false, nil
+			}
+			// a quoted field carries on over line breaks
+			next, err := reader.readLine()
+			if err == io.EOF {
+				if dialect.LazyQuotes {
+					return field.String(), "", false, nil
+				}
+				return "", "", false, &csvSyntaxError{fmt.Sprintf(
+					"missing closing %v in quoted field", quote)}
+			}
+			if err != nil {
+				return "", "", false, err
+			}
+			field.WriteByte('\n')
+			line = next
+			continue
+		}
+
+		char, size := utf8.DecodeRuneInString(line)
+		line = line[size:]
+		switch {
+		case char == dialect.Escape && char != dialect.Quote && line != "":
+			char, size = utf8.DecodeRuneInString(line)
+			line = line[size:]
+			field.WriteRune(char)
+		case char == dialect.Delimiter && !quoted:
+			return field.String(), line, true, nil
+		case char == dialect.Quote && !quoted:
+			if !dialect.LazyQuotes {
+				return "", "", false, &csvSyntaxError{fmt.Sprintf(
+					"bare %v in non-quoted field", quote)}
+			}
+			field.WriteRune(char)
+		case char == dialect.Quote:
+			if dialect.Escape == dialect.Quote && strings.HasPrefix(line,
+				quote) {
+				line = line[len(quote):]
+				field.WriteRune(char)
+				continue
+			}
+			if line == "" {
+				return field.String(), "", false, nil
+			}
+			if strings.HasPrefix(line, string(dialect.Delimiter)) {
+				return field.String(), line[len(string(dialect.Delimiter)):],
+					true, nil
+			}
+			if !dialect.LazyQuotes {
+				return "", "", false, &csvSyntaxError{fmt.Sprintf(
+					"extraneous %v in quoted field", quote)}
+			}
+			field.WriteRune(char)
+		default:
+			field.WriteRune(char)
+		}
+	}
+}
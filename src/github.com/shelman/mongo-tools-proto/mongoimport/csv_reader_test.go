@@ -0,0 +1,185 @@
+package mongoimport
+
+import (
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// readCSVRecords reads all the records of the given CSV text
+func readCSVRecords(contents string, dialect CSVDialect) ([][]string,
+	error) {
+	reader := newCSVRecordReader(newLineReader(strings.NewReader(contents)),
+		dialect)
+	var records [][]string
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return records, nil
+		}
+		if err != nil {
+			return records, err
+		}
+		records = append(records, record)
+	}
+}
+
+func TestCSVRecordReader(t *testing.T) {
+	Convey("Given a CSV record reader", t, func() {
+		Convey("the default dialect should read RFC 4180 CSV, skipping "+
+			"empty lines", func() {
+			records, err := readCSVRecords("a,\"b,c\",\"d\"\"e\"\r\n\n"+
+				"\"f\ng\",h\n", DefaultCSVDialect)
+			So(err, ShouldBeNil)
+			So(records, ShouldResemble, [][]string{
+				{"a", "b,c", "d\"e"},
+				{"f\ng", "h"},
+			})
+		})
+		Convey("the delimiter and quote characters should be configurable",
+			func() {
+				dialect := DefaultCSVDialect
+				dialect.Delimiter = ';'
+				dialect.Quote = '\''
+				dialect.Escape = '\''
+				records, err := readCSVRecords("1;'a;''b'';c';\"d\"\n",
+					dialect)
+				So(err, ShouldBeNil)
+				So(records, ShouldResemble, [][]string{
+					{"1", "a;'b';c", "\"d\""},
+				})
+			})
+		Convey("an escape character should make the character after it "+
+			"literal", func() {
+			dialect := DefaultCSVDialect
+			dialect.Delimiter = '|'
+			dialect.Escape = '\\'
+			records, err := readCSVRecords("a\\|b|\"c\\\"d\\\\\"|e\\\n",
+				dialect)
+			So(err, ShouldBeNil)
+			So(records, ShouldResemble, [][]string{
+				{"a|b", "c\"d\\", "e\\"},
+			})
+		})
+		Convey("comment lines should be skipped", func() {
+			dialect := DefaultCSVDialect
+			dialect.Comment = '#'
+			records, err := readCSVRecords("# header\na,#b\n#\nc\n", dialect)
+			So(err, ShouldBeNil)
+			So(records, ShouldResemble, [][]string{{"a", "#b"}, {"c"}})
+		})
+		Convey("leading space should only be trimmed if specified", func() {
+			dialect := DefaultCSVDialect
+			records, err := readCSVRecords("a,  b\n", dialect)
+			So(err, ShouldBeNil)
+			So(records, ShouldResemble, [][]string{{"a", "  b"}})
+			dialect.TrimLeadingSpace = true
+			records, err = readCSVRecords("a,  b, \"c\"\n", dialect)
+			So(err, ShouldBeNil)
+			So(records, ShouldResemble, [][]string{{"a", "b", "c"}})
+		})
+		Convey("stray quotes should only be allowed with lazy quotes",
+			func() {
+				dialect := DefaultCSVDialect
+				for _, contents := range []string{"a\"b\n", "\"a\"b\"\n",
+					"\"a\n"} {
+					_, err := readCSVRecords(contents, dialect)
+					So(err, ShouldNotBeNil)
+				}
+				dialect.LazyQuotes = true
+				records, err := readCSVRecords("a\"b,\"c\"d\",\"e\n",
+					dialect)
+				So(err, ShouldBeNil)
+				So(records, ShouldResemble, [][]string{{"a\"b", "c\"d", "e"}})
+			})
+	})
+}
+
+func TestCSVDialectImport(t *testing.T) {
+	Convey("With a CSV import input in a custom dialect", t, func() {
+		dialect := DefaultCSVDialect
+		dialect.Delimiter = ';'
+		dialect.Comment = '#'
+		Convey("skipped lines should precede the header", func() {
+			contents := "exported on 2014-07-01\n\na;b\n1;2\n"
+			csvImporter := NewCSVImportInputWithDialect(nil, dialect,
+				strings.NewReader(contents))
+			So(csvImporter.SkipLines(2), ShouldBeNil)
+			So(csvImporter.SetHeader(), ShouldBeNil)
+			So(csvImporter.Fields, ShouldResemble, []string{"a", "b"})
+			document, err := csvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document["a"], ShouldEqual, 1)
+			So(document["b"], ShouldEqual, 2)
+		})
+		Convey("the raw text of a record should exclude comments before it",
+			func() {
+				contents := "1;2\n# a comment\n\n3;\"4\n5\"\n"
+				csvImporter := NewCSVImportInputWithDialect([]string{"a", "b"},
+					dialect, strings.NewReader(contents))
+				_, err := csvImporter.ImportDocument()
+				So(err, ShouldBeNil)
+				document, err := csvImporter.ImportDocument()
+				So(err, ShouldBeNil)
+				So(document["b"], ShouldEqual, "4\n5")
+				record := csvImporter.LastRecord()
+				So(record.Line, ShouldEqual, 4)
+				So(string(record.Raw), ShouldEqual, "3;\"4\n5\"\n")
+				So(record.LinesRead, ShouldEqual, 5)
+			})
+		Convey("a record with a syntax error should be returned with the "+
+			"error, and reading should carry on with the next", func() {
+			contents := "1;2\n3;x\"y\n\"4\"z;5\n6;7\n"
+			csvImporter := NewCSVImportInputWithDialect([]string{"a", "b"},
+				dialect, strings.NewReader(contents))
+			_, err := csvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			for _, raw := range []string{"3;x\"y\n", "\"4\"z;5\n"} {
+				document, err := csvImporter.ImportDocument()
+				So(err, ShouldHaveSameTypeAs, &csvSyntaxError{})
+				So(document, ShouldNotBeNil)
+				So(string(csvImporter.LastRecord().Raw), ShouldEqual, raw)
+			}
+			document, err := csvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(document["a"], ShouldEqual, 6)
+			So(csvImporter.LastRecord().Line, ShouldEqual, 4)
+		})
+	})
+}
+
+func TestCSVSyntaxErrorImport(t *testing.T) {
+	Convey("Given a mongoimport instance reading CSV with a syntax error",
+		t, func() {
+			file, err := ioutil.TempFile("", "mongoimport_")
+			So(err, ShouldBeNil)
+			defer os.Remove(file.Name())
+			_, err = file.WriteString("1,2\n3,x\"y\n4,5\n")
+			So(err, ShouldBeNil)
+			So(file.Close(), ShouldBeNil)
+			mongoImport := MongoImport{
+				ToolOptions: getBasicToolOptions(),
+				InputOptions: &options.InputOptions{
+					Type:   CSV,
+					File:   file.Name(),
+					Fields: "a,b",
+				},
+				IngestOptions: &options.IngestOptions{DryRun: true},
+			}
+			Convey("the bad record should be skipped", func() {
+				numImported, err := mongoImport.ImportDocuments()
+				So(err, ShouldBeNil)
+				So(numImported, ShouldEqual, 2)
+				So(mongoImport.failed, ShouldEqual, 1)
+			})
+			Convey("the import should stop there with --stopOnError", func() {
+				mongoImport.IngestOptions.StopOnError = true
+				_, err := mongoImport.ImportDocuments()
+				So(err, ShouldNotBeNil)
+			})
+		})
+}
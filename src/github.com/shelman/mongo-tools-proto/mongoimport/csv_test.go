@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -145,6 +146,26 @@ func TestCSVSetHeader(t *testing.T) {
 				// should be a union of both the fields and the header line
 				So(len(csvImporter.Fields), ShouldEqual, 6)
 			})
+		Convey("setting a typed header should parse the declared types of "+
+			"the columns", func() {
+			contents := "zip.string(), count.int32()\n02134, 5\n"
+			fields := []string{}
+
+			csvFile, err = ioutil.TempFile("", "mongoimport_")
+			So(err, ShouldBeNil)
+			_, err = io.WriteString(csvFile, contents)
+			So(err, ShouldBeNil)
+			fileHandle, err = os.Open(csvFile.Name())
+			So(err, ShouldBeNil)
+			csvImporter := NewCSVImportInput(fields, fileHandle)
+			csvImporter.ColumnsHaveTypes = true
+			So(csvImporter.SetHeader(), ShouldBeNil)
+			So(csvImporter.Fields, ShouldResemble, []string{"zip", "count"})
+			bsonDoc, err := csvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(bsonDoc, ShouldResemble, bson.M{"zip": "02134",
+				"count": int32(5)})
+		})
 
 		Convey("plain CSV input file sources should be parsed correctly and "+
 			"subsequent imports should parse correctly",
@@ -169,6 +190,66 @@ func TestCSVSetHeader(t *testing.T) {
 	})
 }
 
+func TestCSVNestedFields(t *testing.T) {
+	Convey("With a CSV import input", t, func() {
+		Convey("dotted field names should build subdocuments and arrays",
+			func() {
+				contents := "1,Springfield,12345,a,b\n"
+				fields := []string{"_id", "address.city", "address.zip",
+					"tags.0", "tags.1"}
+				csvImporter := NewCSVImportInput(fields,
+					strings.NewReader(contents))
+				bsonDoc, err := csvImporter.ImportDocument()
+				So(err, ShouldBeNil)
+				So(bsonDoc, ShouldResemble, bson.M{
+					"_id": 1,
+					"address": bson.M{
+						"city": "Springfield",
+						"zip":  12345,
+					},
+					"tags": []interface{}{"a", "b"},
+				})
+			})
+		Convey("dotted field names should build subdocuments within arrays",
+			func() {
+				contents := "x,y\n"
+				fields := []string{"a.1.b", "a.0.b"}
+				csvImporter := NewCSVImportInput(fields,
+					strings.NewReader(contents))
+				bsonDoc, err := csvImporter.ImportDocument()
+				So(err, ShouldBeNil)
+				So(bsonDoc, ShouldResemble, bson.M{
+					"a": []interface{}{bson.M{"b": "y"}, bson.M{"b": "x"}},
+				})
+			})
+	})
+}
+
+func TestValidateFields(t *testing.T) {
+	Convey("Given a set of field names, on calling validateFields", t,
+		func() {
+			Convey("no error should be returned for valid nested fields",
+				func() {
+					fields := []string{"a.b", "a.c", "d.0", "d.1.e", "f"}
+					So(validateFields(fields), ShouldBeNil)
+				})
+			Convey("an error should be returned if a field is both a value "+
+				"and a subdocument", func() {
+				So(validateFields([]string{"a", "a.b"}), ShouldNotBeNil)
+				So(validateFields([]string{"a.b.c", "a.b"}), ShouldNotBeNil)
+			})
+			Convey("an error should be returned if a field is both an array "+
+				"and a subdocument", func() {
+				So(validateFields([]string{"a.0", "a.b"}), ShouldNotBeNil)
+			})
+			Convey("an error should be returned for empty path segments",
+				func() {
+					So(validateFields([]string{"a..b"}), ShouldNotBeNil)
+					So(validateFields([]string{"a."}), ShouldNotBeNil)
+				})
+		})
+}
+
 func TestGetParsedValue(t *testing.T) {
 	Convey("Given a string token to parse", t, func() {
 		Convey("an int token should return the underlying int value",
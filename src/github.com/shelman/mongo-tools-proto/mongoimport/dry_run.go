@@ -0,0 +1,182 @@
+package mongoimport
+
+import (
+	"fmt"
+	"github.com/shelman/mongo-tools-proto/common/bson_ext"
+	"io"
+	"labix.org/v2/mgo/bson"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dryRunReport collects statistics about the documents a dry run would have
+// imported. It is safe for concurrent use.
+type dryRunReport struct {
+	mutex sync.Mutex
+	// documents is the number of documents that would have been written
+	documents int64
+	// oversized is the number of documents over the BSON size limit
+	oversized int64
+	// fields counts, for each field path, the values of each BSON type
+	fields map[string]map[string]int64
+}
+
+// newDryRunReport returns an empty dryRunReport
+func newDryRunReport() *dryRunReport {
+	return &dryRunReport{
+		fields: make(map[string]map[string]int64),
+	}
+}
+
+// Add tallies the fields of a document that would have been written
+func (report *dryRunReport) Add(document bson.M) {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+	report.documents++
+	report.addFields("", document)
+}
+
+// AddOversized counts a document that is over the BSON size limit
+func (report *dryRunReport) AddOversized() {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+	report.oversized++
+}
+
+// addFields tallies the type of each field in the given document, descending
+// into subdocuments and arrays. Field paths are dotted.
+func (report *dryRunReport) addFields(prefix string, document bson.M) {
+	for key, value := range document {
+		report.addValue(prefix+key, value)
+	}
+}
+
+// addValue tallies the type of the value at the given path, then those of its
+// fields if it is a subdocument, or of its elements if it is an array. The
+// elements of an array are all tallied under the array's path followed by
+// "[]", e.g. "tags[]" or "points[].x".
+func (report *dryRunReport) addValue(path string, value interface{}) {
+	if report.fields[path] == nil {
+		report.fields[path] = make(map[string]int64)
+	}
+	report.fields[path][bsonTypeName(value)]++
+	if subdocument, ok := asDocument(value); ok {
+		report.addFields(path+".", subdocument)
+	} else if array, ok := value.([]interface{}); ok {
+		for _, element := range array {
+			report.addValue(path+"[]", element)
+		}
+	}
+}
+
+// Print writes the report to the given io.Writer. Fields are listed in order,
+// each with its types from the most to the least common.
+func (report *dryRunReport) Print(out io.Writer, failed int64) {
+	report.mutex.Lock()
+	defer report.mutex.Unlock()
+	fmt.Fprintf(out, "dry run: %v documents would be imported, %v failed "+
+		"(%v over the %v byte size limit)\n", report.documents, failed,
+		report.oversized, MaxBSONSize)
+	if len(report.fields) == 0 {
+		return
+	}
+	paths := make([]string, 0, len(report.fields))
+	for path := range report.fields {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	fmt.Fprintf(out, "fields:\n")
+	for _, path := range paths {
+		counts := report.fields[path]
+		typeNames := make([]string, 0, len(counts))
+		for typeName := range counts {
+			typeNames = append(typeNames, typeName)
+		}
+		sort.Sort(byCount{typeNames, counts})
+		typeCounts := make([]string, 0, len(typeNames))
+		for _, typeName := range typeNames {
+			typeCounts = append(typeCounts, fmt.Sprintf("%v %v", typeName,
+				counts[typeName]))
+		}
+		fmt.Fprintf(out, "  %v: %v\n", path, strings.Join(typeCounts, ", "))
+	}
+}
+
+// byCount sorts type names by decreasing count, then by name
+type byCount struct {
+	typeNames []string
+	counts    map[string]int64
+}
+
+func (types byCount) Len() int {
+	return len(types.typeNames)
+}
+
+func (types byCount) Swap(i, j int) {
+	types.typeNames[i], types.typeNames[j] = types.typeNames[j],
+		types.typeNames[i]
+}
+
+func (types byCount) Less(i, j int) bool {
+	countI := types.counts[types.typeNames[i]]
+	countJ := types.counts[types.typeNames[j]]
+	if countI != countJ {
+		return countI > countJ
+	}
+	return types.typeNames[i] < types.typeNames[j]
+}
+
+// bsonTypeName returns the name of the BSON type the given value is encoded as
+func bsonTypeName(value interface{}) string {
+	switch typedValue := value.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int:
+		// ints are encoded as int32 whenever they fit
+		if typedValue >= math.MinInt32 && typedValue <= math.MaxInt32 {
+			return "int32"
+		}
+		return "int64"
+	case int32:
+		return "int32"
+	case int64, bson_ext.NumberLongExt:
+		return "int64"
+	case float32, float64:
+		return "double"
+	case bson.ObjectId:
+		return "objectId"
+	case time.Time:
+		return "date"
+	case []byte, bson.Binary:
+		return "binData"
+	case bson.M, bson.D, map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case bson.RegEx:
+		return "regex"
+	case bson.MongoTimestamp:
+		return "timestamp"
+	case bson.JavaScript:
+		return "javascript"
+	case bson.Symbol:
+		return "symbol"
+	}
+	if value == bson.MinKey {
+		return "minKey"
+	}
+	if value == bson.MaxKey {
+		return "maxKey"
+	}
+	if value == bson.Undefined {
+		return "undefined"
+	}
+	return fmt.Sprintf("%T", value)
+}
@@ -0,0 +1,104 @@
+package mongoimport
+
+import (
+	"bytes"
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"labix.org/v2/mgo/bson"
+	"testing"
+	"time"
+)
+
+func TestBSONTypeName(t *testing.T) {
+	Convey("Given values of different types, on calling bsonTypeName", t,
+		func() {
+			Convey("the BSON type they are encoded as should be returned",
+				func() {
+					So(bsonTypeName(1), ShouldEqual, "int32")
+					So(bsonTypeName(1<<40), ShouldEqual, "int64")
+					So(bsonTypeName(1.5), ShouldEqual, "double")
+					So(bsonTypeName(""), ShouldEqual, "string")
+					So(bsonTypeName(nil), ShouldEqual, "null")
+					So(bsonTypeName(time.Now()), ShouldEqual, "date")
+					So(bsonTypeName(bson.NewObjectId()), ShouldEqual, "objectId")
+					So(bsonTypeName(bson.M{}), ShouldEqual, "object")
+					So(bsonTypeName([]interface{}{}), ShouldEqual, "array")
+					So(bsonTypeName(bson.MaxKey), ShouldEqual, "maxKey")
+				})
+		})
+}
+
+func TestDryRunReport(t *testing.T) {
+	Convey("Given a dry run report", t, func() {
+		report := newDryRunReport()
+		Convey("fields should be tallied by type, including those in "+
+			"subdocuments", func() {
+			report.Add(bson.M{"a": 1, "b": bson.M{"c": "x"}})
+			report.Add(bson.M{"a": "one"})
+			report.Add(bson.M{"a": 2})
+			report.AddOversized()
+			out := &bytes.Buffer{}
+			report.Print(out, 1)
+			So(out.String(), ShouldEqual, "dry run: 3 documents would be "+
+				"imported, 1 failed (1 over the 16777216 byte size limit)\n"+
+				"fields:\n"+
+				"  a: int32 2, string 1\n"+
+				"  b: object 1\n"+
+				"  b.c: string 1\n")
+		})
+
+		Convey("fields should be tallied within arrays and documents of "+
+			"any map type", func() {
+			report.Add(bson.M{
+				"tags": []interface{}{"x", 1, "y"},
+				"points": []interface{}{
+					map[string]interface{}{"x": 1.5},
+					bson.M{"x": 2.5, "y": []interface{}{bson.M{"z": true}}},
+				},
+			})
+			out := &bytes.Buffer{}
+			report.Print(out, 0)
+			So(out.String(), ShouldEqual, "dry run: 1 documents would be "+
+				"imported, 0 failed (0 over the 16777216 byte size limit)\n"+
+				"fields:\n"+
+				"  points: array 1\n"+
+				"  points[]: object 2\n"+
+				"  points[].x: double 2\n"+
+				"  points[].y: array 1\n"+
+				"  points[].y[]: object 1\n"+
+				"  points[].y[].z: bool 1\n"+
+				"  tags: array 1\n"+
+				"  tags[]: string 2, int32 1\n")
+		})
+	})
+}
+
+func TestDryRun(t *testing.T) {
+	Convey("Given a mongoimport instance in dry run mode, on calling "+
+		"ImportDocuments", t, func() {
+		Convey("documents should be parsed and counted without a server",
+			func() {
+				mongoImport := MongoImport{
+					ToolOptions: getBasicToolOptions(),
+					InputOptions: &options.InputOptions{
+						Type:   CSV,
+						File:   "testdata/test_bad.csv",
+						Fields: "a,b,c",
+					},
+					IngestOptions: &options.IngestOptions{
+						DryRun: true,
+					},
+				}
+				// the record with a stray quote is skipped
+				numImported, err := mongoImport.ImportDocuments()
+				So(err, ShouldBeNil)
+				So(numImported, ShouldEqual, 2)
+				So(mongoImport.failed, ShouldEqual, 1)
+
+				mongoImport.InputOptions.File = "testdata/test.csv"
+				numImported, err = mongoImport.ImportDocuments()
+				So(err, ShouldBeNil)
+				So(numImported, ShouldEqual, 3)
+			})
+	})
+}
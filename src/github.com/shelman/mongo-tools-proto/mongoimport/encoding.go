@@ -0,0 +1,266 @@
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// values accepted by --inputEncoding
+const (
+	AutoEncoding        = "auto"
+	UTF8Encoding        = "utf-8"
+	UTF16LEEncoding     = "utf-16le"
+	UTF16BEEncoding     = "utf-16be"
+	Latin1Encoding      = "latin1"
+	Windows1252Encoding = "windows-1252"
+)
+
+// encodingAliases maps each name --inputEncoding accepts to the encoding it
+// denotes
+var encodingAliases = map[string]string{
+	AutoEncoding:        AutoEncoding,
+	UTF8Encoding:        UTF8Encoding,
+	"utf8":              UTF8Encoding,
+	UTF16LEEncoding:     UTF16LEEncoding,
+	"utf16le":           UTF16LEEncoding,
+	UTF16BEEncoding:     UTF16BEEncoding,
+	"utf16be":           UTF16BEEncoding,
+	Latin1Encoding:      Latin1Encoding,
+	"iso-8859-1":        Latin1Encoding,
+	Windows1252Encoding: Windows1252Encoding,
+	"cp1252":            Windows1252Encoding,
+}
+
+// byteOrderMarks lists the byte order mark each encoding may start with
+var byteOrderMarks = []struct {
+	encoding string
+	mark     []byte
+}{
+	{UTF8Encoding, []byte{0xef, 0xbb, 0xbf}},
+	{UTF16LEEncoding, []byte{0xff, 0xfe}},
+	{UTF16BEEncoding, []byte{0xfe, 0xff}},
+}
+
+// windows1252Runes holds the characters Windows-1252 has in place of the C1
+// control characters of Latin-1 (0x80 to 0x9f). Zero entries are undefined in
+// Windows-1252 and read as their Latin-1 counterparts.
+var windows1252Runes = [32]rune{
+	0x20ac, 0, 0x201a, 0x0192, 0x201e, 0x2026, 0x2020, 0x2021,
+	0x02c6, 0x2030, 0x0160, 0x2039, 0x0152, 0, 0x017d, 0,
+	0, 0x2018, 0x2019, 0x201c, 0x201d, 0x2022, 0x2013, 0x2014,
+	0x02dc, 0x2122, 0x0161, 0x203a, 0x0153, 0, 0x017e, 0x0178,
+}
+
+// transcodeChunkSize is the number of bytes read from the input source at a
+// time when transcoding
+const transcodeChunkSize = 4096
+
+// invalidUTF8 is a byte that never appears in UTF-8, written in place of input
+// that is not valid in its encoding in strict mode
+const invalidUTF8 = 0xff
+
+// normalizeEncoding returns the encoding denoted by the given name, and
+// whether the name is one that --inputEncoding accepts
+func normalizeEncoding(name string) (string, bool) {
+	encoding, ok := encodingAliases[strings.ToLower(name)]
+	return encoding, ok
+}
+
+// detectEncoding returns the encoding of an input source given its first few
+// bytes, along with the length of the byte order mark it starts with. With
+// AutoEncoding, the byte order mark determines the encoding, which is UTF-8
+// if there is none; otherwise only a byte order mark of the given encoding
+// is recognized.
+func detectEncoding(header []byte, encoding string) (string, int) {
+	for _, bom := range byteOrderMarks {
+		if bytes.HasPrefix(header, bom.mark) &&
+			(encoding == AutoEncoding || encoding == bom.encoding) {
+			return bom.encoding, len(bom.mark)
+		}
+	}
+	if encoding == AutoEncoding {
+		return UTF8Encoding, 0
+	}
+	return encoding, 0
+}
+
+// transcodingReader reads an input source in a given encoding as UTF-8,
+// skipping any byte order mark. Like decompressedReader, it only detects the
+// encoding on the first read. Input that is not valid in its encoding is read
+// as U+FFFD - or in strict mode as invalid UTF-8, so that the records holding
+// it are rejected like invalid UTF-8 input - except that UTF-8 input is passed
+// through as is.
+type transcodingReader struct {
+	in       io.ReadCloser
+	buffered *bufio.Reader
+	encoding string
+	strict   bool
+	opened   bool
+	// bomLength is the length of the byte order mark that was skipped
+	bomLength int64
+	// chunk is the buffer input is read into
+	chunk []byte
+	// raw holds input not yet transcoded: the trailing part of a UTF-16
+	// character split across chunks
+	raw []byte
+	// transcoded holds the UTF-8 not yet returned by Read
+	transcoded []byte
+	out        []byte
+	// err is the error that ended the input source, if any
+	err error
+}
+
+// transcodeInput returns a reader streaming the given input source, written
+// in the given encoding, as UTF-8. In strict mode, input that is not valid in
+// its encoding is read as invalid UTF-8 rather than U+FFFD.
+func transcodeInput(in io.ReadCloser, encoding string,
+	strict bool) io.ReadCloser {
+	return &transcodingReader{
+		in:       in,
+		buffered: bufio.NewReader(in),
+		encoding: encoding,
+		strict:   strict,
+	}
+}
+
+// open detects the encoding of the input source and skips its byte order
+// mark, if any
+func (reader *transcodingReader) open() error {
+	reader.opened = true
+	// a short or empty input simply has no byte order mark
+	header, _ := reader.buffered.Peek(3)
+	encoding, bomLength := detectEncoding(header, reader.encoding)
+	reader.encoding = encoding
+	reader.bomLength = int64(bomLength)
+	_, err := io.ReadFull(reader.buffered, make([]byte, bomLength))
+	return err
+}
+
+func (reader *transcodingReader) Read(p []byte) (int, error) {
+	if !reader.opened {
+		if err := reader.open(); err != nil {
+			return 0, err
+		}
+	}
+	if reader.encoding == UTF8Encoding {
+		return reader.buffered.Read(p)
+	}
+	for len(reader.transcoded) == 0 {
+		if reader.err != nil {
+			return 0, reader.err
+		}
+		if reader.chunk == nil {
+			reader.chunk = make([]byte, transcodeChunkSize)
+		}
+		n, err := reader.buffered.Read(reader.chunk)
+		reader.raw = append(reader.raw, reader.chunk[:n]...)
+		reader.err = err
+		reader.transcode(err != nil)
+	}
+	n := copy(p, reader.transcoded)
+	reader.transcoded = reader.transcoded[n:]
+	return n, nil
+}
+
+// transcode converts as much of the raw input as possible to UTF-8. At the
+// end of the input, any incomplete character left is converted as well.
+func (reader *transcodingReader) transcode(final bool) {
+	out := reader.out[:0]
+	raw := reader.raw
+	switch reader.encoding {
+	case Latin1Encoding, Windows1252Encoding:
+		for _, b := range raw {
+			char := rune(b)
+			if reader.encoding == Windows1252Encoding && b >= 0x80 &&
+				b <= 0x9f && windows1252Runes[b-0x80] != 0 {
+				char = windows1252Runes[b-0x80]
+			}
+			out = appendRune(out, char)
+		}
+		raw = raw[len(raw):]
+	case UTF16LEEncoding, UTF16BEEncoding:
+		unit := func(index int) rune {
+			if reader.encoding == UTF16LEEncoding {
+				return rune(raw[index]) | rune(raw[index+1])<<8
+			}
+			return rune(raw[index])<<8 | rune(raw[index+1])
+		}
+		for len(raw) >= 2 {
+			char := unit(0)
+			if char >= 0xd800 && char < 0xdc00 {
+				// a high surrogate is combined with the low surrogate
+				// following it
+				if len(raw) < 4 {
+					if !final {
+						break
+					}
+				} else if pair := utf16.DecodeRune(char,
+					unit(2)); pair != utf8.RuneError {
+					out = appendRune(out, pair)
+					raw = raw[4:]
+					continue
+				}
+				out = reader.appendInvalid(out)
+			} else if utf16.IsSurrogate(char) {
+				out = reader.appendInvalid(out)
+			} else {
+				out = appendRune(out, char)
+			}
+			raw = raw[2:]
+		}
+		if final && len(raw) != 0 {
+			out = reader.appendInvalid(out)
+			raw = raw[len(raw):]
+		}
+	}
+	reader.raw = append(reader.raw[:0], raw...)
+	reader.out = out
+	reader.transcoded = out
+}
+
+// appendInvalid appends what input that is not valid in its encoding is read
+// as to the given slice
+func (reader *transcodingReader) appendInvalid(out []byte) []byte {
+	if reader.strict {
+		return append(out, invalidUTF8)
+	}
+	return appendRune(out, utf8.RuneError)
+}
+
+// appendRune appends the UTF-8 encoding of the given rune to the given slice
+func appendRune(out []byte, char rune) []byte {
+	var encoded [utf8.UTFMax]byte
+	n := utf8.EncodeRune(encoded[:], char)
+	return append(out, encoded[:n]...)
+}
+
+// Seek moves to the given offset of UTF-8 input, past any byte order mark, if
+// the underlying input source can be seeked. Input in any other encoding can
+// not be seeked.
+func (reader *transcodingReader) Seek(offset int64, whence int) (int64, error) {
+	if !reader.opened {
+		if err := reader.open(); err != nil {
+			return 0, err
+		}
+	}
+	seeker, ok := reader.in.(io.Seeker)
+	if reader.encoding != UTF8Encoding || !ok || whence != os.SEEK_SET {
+		return 0, fmt.Errorf("input can not be seeked")
+	}
+	position, err := seeker.Seek(offset+reader.bomLength, whence)
+	if err != nil {
+		return 0, err
+	}
+	reader.buffered.Reset(reader.in)
+	return position - reader.bomLength, nil
+}
+
+func (reader *transcodingReader) Close() error {
+	return reader.in.Close()
+}
@@ -0,0 +1,206 @@
+package mongoimport
+
+import (
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf16"
+)
+
+// encodeUTF16 returns the given text in UTF-16, little endian if specified
+// and big endian otherwise
+func encodeUTF16(text string, littleEndian bool) []byte {
+	var encoded []byte
+	for _, unit := range utf16.Encode([]rune(text)) {
+		if littleEndian {
+			encoded = append(encoded, byte(unit), byte(unit>>8))
+		} else {
+			encoded = append(encoded, byte(unit>>8), byte(unit))
+		}
+	}
+	return encoded
+}
+
+// transcodeString reads the given input, in the given encoding, as UTF-8 -
+// one byte at a time so that characters are split across reads
+func transcodeString(input []byte, encoding string) (string, error) {
+	return transcodeStringStrict(input, encoding, false)
+}
+
+// transcodeStringStrict is transcodeString, in strict mode if specified
+func transcodeStringStrict(input []byte, encoding string,
+	strict bool) (string, error) {
+	in := ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(
+		string(input))))
+	data, err := ioutil.ReadAll(transcodeInput(in, encoding, strict))
+	return string(data), err
+}
+
+func TestDetectEncoding(t *testing.T) {
+	Convey("Given the first bytes of an input source", t, func() {
+		Convey("byte order marks should determine the encoding in auto mode",
+			func() {
+				encoding, bomLength := detectEncoding([]byte{0xef, 0xbb, 0xbf},
+					AutoEncoding)
+				So(encoding, ShouldEqual, UTF8Encoding)
+				So(bomLength, ShouldEqual, 3)
+				encoding, bomLength = detectEncoding([]byte{0xff, 0xfe, 'a'},
+					AutoEncoding)
+				So(encoding, ShouldEqual, UTF16LEEncoding)
+				So(bomLength, ShouldEqual, 2)
+				encoding, bomLength = detectEncoding([]byte{0xfe, 0xff, 0},
+					AutoEncoding)
+				So(encoding, ShouldEqual, UTF16BEEncoding)
+				So(bomLength, ShouldEqual, 2)
+			})
+		Convey("input without a byte order mark should be UTF-8 in auto mode",
+			func() {
+				encoding, bomLength := detectEncoding([]byte("abc"),
+					AutoEncoding)
+				So(encoding, ShouldEqual, UTF8Encoding)
+				So(bomLength, ShouldEqual, 0)
+			})
+		Convey("only the byte order mark of a given encoding should be "+
+			"recognized", func() {
+			encoding, bomLength := detectEncoding([]byte{0xff, 0xfe, 'a'},
+				Latin1Encoding)
+			So(encoding, ShouldEqual, Latin1Encoding)
+			So(bomLength, ShouldEqual, 0)
+		})
+		Convey("encoding names should be case insensitive and accept "+
+			"aliases", func() {
+			encoding, ok := normalizeEncoding("ISO-8859-1")
+			So(ok, ShouldBeTrue)
+			So(encoding, ShouldEqual, Latin1Encoding)
+			_, ok = normalizeEncoding("ebcdic")
+			So(ok, ShouldBeFalse)
+		})
+	})
+}
+
+func TestTranscodeInput(t *testing.T) {
+	Convey("Given input in a given encoding", t, func() {
+		Convey("Latin-1 and Windows-1252 should be converted to UTF-8",
+			func() {
+				text, err := transcodeString([]byte("caf\xe9 \x80\x81"),
+					Latin1Encoding)
+				So(err, ShouldBeNil)
+				So(text, ShouldEqual, "café \u0080\u0081")
+				text, err = transcodeString([]byte("caf\xe9 \x80\x81"),
+					Windows1252Encoding)
+				So(err, ShouldBeNil)
+				So(text, ShouldEqual, "café €\u0081")
+			})
+		Convey("UTF-16 with a byte order mark should be converted to UTF-8",
+			func() {
+				original := "a,b\n1,é \U0001f600\n"
+				for _, littleEndian := range []bool{true, false} {
+					input := encodeUTF16("\ufeff"+original, littleEndian)
+					text, err := transcodeString(input, AutoEncoding)
+					So(err, ShouldBeNil)
+					So(text, ShouldEqual, original)
+				}
+			})
+		Convey("unpaired surrogates and odd trailing bytes should be "+
+			"converted to U+FFFD", func() {
+			input := append(encodeUTF16("a", true), 0x00, 0xd8, 'b', 0, 'c')
+			text, err := transcodeString(input, UTF16LEEncoding)
+			So(err, ShouldBeNil)
+			So(text, ShouldEqual, "a\ufffdb\ufffd")
+		})
+		Convey("in strict mode, invalid UTF-16 should be converted to "+
+			"invalid UTF-8 - but U+FFFD itself should not", func() {
+			input := append(encodeUTF16("\ufffda", true), 0x00, 0xd8, 'b', 0,
+				'c')
+			text, err := transcodeStringStrict(input, UTF16LEEncoding, true)
+			So(err, ShouldBeNil)
+			So(text, ShouldEqual, "\ufffda\xffb\xff")
+		})
+		Convey("UTF-8 should be passed through without its byte order mark",
+			func() {
+				text, err := transcodeString([]byte("\xef\xbb\xbfa\xffb"),
+					AutoEncoding)
+				So(err, ShouldBeNil)
+				So(text, ShouldEqual, "a\xffb")
+			})
+		Convey("UTF-8 files should be seeked past their byte order mark",
+			func() {
+				file, err := ioutil.TempFile("", "mongoimport_")
+				So(err, ShouldBeNil)
+				defer os.Remove(file.Name())
+				_, err = file.WriteString("\xef\xbb\xbfabcdef")
+				So(err, ShouldBeNil)
+				_, err = file.Seek(0, os.SEEK_SET)
+				So(err, ShouldBeNil)
+				reader := transcodeInput(decompressInput(file, file.Name(),
+					AutoCompression), AutoEncoding, false)
+				defer reader.Close()
+				So(seekInput(reader, 2), ShouldBeNil)
+				data, err := ioutil.ReadAll(reader)
+				So(err, ShouldBeNil)
+				So(string(data), ShouldEqual, "cdef")
+			})
+	})
+}
+
+func TestEncodedImport(t *testing.T) {
+	Convey("Given a mongoimport instance in dry run mode", t, func() {
+		file, err := ioutil.TempFile("", "mongoimport_")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		mongoImport := MongoImport{
+			ToolOptions: getBasicToolOptions(),
+			InputOptions: &options.InputOptions{
+				Type:       CSV,
+				File:       file.Name(),
+				HeaderLine: true,
+			},
+			IngestOptions: &options.IngestOptions{
+				DryRun: true,
+			},
+		}
+		Convey("UTF-16 input should be detected and imported", func() {
+			_, err = file.Write(encodeUTF16("\ufeffa,b\n1,é\n2,ü\n", true))
+			So(err, ShouldBeNil)
+			numImported, err := mongoImport.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numImported, ShouldEqual, 2)
+		})
+		Convey("records that are not valid UTF-8 should only be rejected in "+
+			"strict mode", func() {
+			_, err = file.WriteString("a,b\n1,x\n2,\xff\n3,y\n")
+			So(err, ShouldBeNil)
+			numImported, err := mongoImport.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numImported, ShouldEqual, 3)
+			mongoImport.InputOptions.StrictEncoding = true
+			numImported, err = mongoImport.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numImported, ShouldEqual, 2)
+			So(mongoImport.failed, ShouldEqual, 1)
+		})
+		Convey("records that are not valid UTF-16 should only be rejected "+
+			"in strict mode", func() {
+			input := encodeUTF16("\ufeffa,b\n1,x\n2,", true)
+			input = append(input, 0x00, 0xdc)
+			input = append(input, encodeUTF16("\n3,\ufffd\n", true)...)
+			_, err = file.Write(input)
+			So(err, ShouldBeNil)
+			numImported, err := mongoImport.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numImported, ShouldEqual, 3)
+			mongoImport.InputOptions.StrictEncoding = true
+			numImported, err = mongoImport.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numImported, ShouldEqual, 2)
+			So(mongoImport.failed, ShouldEqual, 1)
+		})
+		Reset(func() {
+			file.Close()
+		})
+	})
+}
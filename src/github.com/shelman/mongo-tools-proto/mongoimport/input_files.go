@@ -0,0 +1,183 @@
+package mongoimport
+
+import (
+	"fmt"
+	"github.com/shelman/mongo-tools-proto/common/util"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// fileResult holds the outcome of importing a single input file
+type fileResult struct {
+	started   bool
+	docsCount int64
+	failed    int64
+	err       error
+}
+
+// getInputFiles returns the files to import: those matching the --file option,
+// followed by those matching each of Files. Glob patterns expand to the files
+// they match in lexical order; other paths are returned as is. A single empty
+// path stands for stdin.
+func (mongoImport *MongoImport) getInputFiles() ([]string, error) {
+	patterns := mongoImport.Files
+	if mongoImport.InputOptions.File != "" {
+		patterns = append([]string{mongoImport.InputOptions.File}, patterns...)
+	}
+	if len(patterns) == 0 {
+		return []string{""}, nil
+	}
+	var files []string
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		matches := []string{pattern}
+		if strings.ContainsAny(pattern, "*?[") {
+			var err error
+			matches, err = filepath.Glob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("bad file pattern '%v': %v", pattern, err)
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("no files match '%v'", pattern)
+			}
+		}
+		for _, file := range matches {
+			if !seen[file] {
+				seen[file] = true
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
+
+// collectionForFile returns the name of the collection the given file is
+// imported into when no collection is specified: its base name without
+// extensions - e.g. "2014-07" for "data/2014-07.csv.gz"
+func collectionForFile(file string) string {
+	fileBaseName := trimCompressionExtension(filepath.Base(file))
+	lastDotIndex := strings.LastIndex(fileBaseName, ".")
+	if lastDotIndex != -1 {
+		fileBaseName = fileBaseName[0:lastDotIndex]
+	}
+	return fileBaseName
+}
+
+// forFile returns a copy of the MongoImport that imports the given file. The
+// copy imports into the collection named after the file if no collection is
+// specified.
+func (mongoImport *MongoImport) forFile(file string) *MongoImport {
+	fileImport := *mongoImport
+	fileImport.failed = 0
+
+	inputOptions := *mongoImport.InputOptions
+	inputOptions.File = file
+	fileImport.InputOptions = &inputOptions
+
+	ingestOptions := *mongoImport.IngestOptions
+	fileImport.IngestOptions = &ingestOptions
+
+	if mongoImport.ToolOptions.Namespace.Collection == "" {
+		toolOptions := *mongoImport.ToolOptions
+		namespace := *mongoImport.ToolOptions.Namespace
+		namespace.Collection = collectionForFile(file)
+		toolOptions.Namespace = &namespace
+		fileImport.ToolOptions = &toolOptions
+	}
+	return &fileImport
+}
+
+// importFiles imports each of the given files - up to --numParallelFiles of
+// them at once - and prints a summary of the documents imported and failed
+// for each. No further files are started once a file fails to import.
+func (mongoImport *MongoImport) importFiles(files []string) (int64, error) {
+	fileImports := make([]*MongoImport, len(files))
+	for index, file := range files {
+		fileImports[index] = mongoImport.forFile(file)
+	}
+
+	// a collection shared by all files must only be dropped once
+	if mongoImport.IngestOptions.Drop && !mongoImport.IngestOptions.DryRun &&
+		mongoImport.ToolOptions.Namespace.Collection != "" {
+		session := mongoImport.SessionProvider.GetSession()
+		collection := session.DB(mongoImport.ToolOptions.DB).
+			C(mongoImport.ToolOptions.Collection)
+		err := mongoImport.dropCollection(collection)
+		session.Close()
+		if err != nil {
+			return 0, err
+		}
+		for _, fileImport := range fileImports {
+			fileImport.IngestOptions.Drop = false
+		}
+	}
+
+	numParallelFiles := mongoImport.IngestOptions.NumParallelFiles
+	if numParallelFiles < 1 {
+		numParallelFiles = 1
+	}
+	results := make([]fileResult, len(files))
+	indexes := make(chan int)
+	abort := make(chan struct{})
+	var abortOnce sync.Once
+	var wg sync.WaitGroup
+	for i := 0; i < numParallelFiles; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for index := range indexes {
+				docsCount, err := fileImports[index].importFile()
+				results[index] = fileResult{
+					started:   true,
+					docsCount: docsCount,
+					failed:    atomic.LoadInt64(&fileImports[index].failed),
+					err:       err,
+				}
+				if err != nil {
+					abortOnce.Do(func() { close(abort) })
+				}
+			}
+		}()
+	}
+dispatch:
+	for index := range files {
+		select {
+		case <-abort:
+			break dispatch
+		default:
+		}
+		select {
+		case indexes <- index:
+		case <-abort:
+			break dispatch
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	// report on every file in input order; the first error is returned
+	docsCount := int64(0)
+	var err error
+	for index, result := range results {
+		if !result.started {
+			continue
+		}
+		docsCount += result.docsCount
+		atomic.AddInt64(&mongoImport.failed, result.failed)
+		fileImport := fileImports[index]
+		if mongoImport.ToolOptions.Verbosity == nil ||
+			!mongoImport.ToolOptions.Quiet {
+			util.PrintfTimeStamped("%v: imported %v objects into %v.%v, %v "+
+				"failed\n", files[index], result.docsCount,
+				fileImport.ToolOptions.DB, fileImport.ToolOptions.Collection,
+				result.failed)
+		}
+		if result.err != nil && err == nil {
+			err = fmt.Errorf("error importing '%v': %v", files[index],
+				result.err)
+		}
+	}
+	return docsCount, err
+}
@@ -0,0 +1,70 @@
+package mongoimport
+
+import (
+	commonOpts "github.com/shelman/mongo-tools-proto/common/options"
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+)
+
+func TestGetInputFiles(t *testing.T) {
+	Convey("Given a mongoimport instance, on calling getInputFiles", t,
+		func() {
+			mongoImport := MongoImport{
+				InputOptions: &options.InputOptions{},
+			}
+			Convey("stdin should be used if no file is given", func() {
+				files, err := mongoImport.getInputFiles()
+				So(err, ShouldBeNil)
+				So(files, ShouldResemble, []string{""})
+			})
+			Convey("glob patterns should expand to the files they match in "+
+				"order, without duplicates", func() {
+				mongoImport.InputOptions.File = "testdata/test_plain.json"
+				mongoImport.Files = []string{"testdata/test*.csv",
+					"testdata/test_plain.json"}
+				files, err := mongoImport.getInputFiles()
+				So(err, ShouldBeNil)
+				So(files, ShouldResemble, []string{"testdata/test_plain.json",
+					"testdata/test.csv", "testdata/test_bad.csv",
+					"testdata/test_blanks.csv", "testdata/test_duplicate.csv"})
+			})
+			Convey("plain paths should be kept even if they do not exist",
+				func() {
+					mongoImport.InputOptions.File = "testdata/missing.csv"
+					files, err := mongoImport.getInputFiles()
+					So(err, ShouldBeNil)
+					So(files, ShouldResemble, []string{"testdata/missing.csv"})
+				})
+			Convey("an error should be returned if a glob pattern matches no "+
+				"files", func() {
+				mongoImport.InputOptions.File = "testdata/*.xml"
+				_, err := mongoImport.getInputFiles()
+				So(err, ShouldNotBeNil)
+			})
+		})
+}
+
+func TestForFile(t *testing.T) {
+	Convey("Given a mongoimport instance, on calling forFile", t, func() {
+		namespace := &commonOpts.Namespace{DB: testDB}
+		mongoImport := MongoImport{
+			ToolOptions:   &commonOpts.ToolOptions{Namespace: namespace},
+			InputOptions:  &options.InputOptions{File: "data/*.csv"},
+			IngestOptions: &options.IngestOptions{},
+		}
+		Convey("the file's collection should be derived from its name if no "+
+			"collection is specified", func() {
+			fileImport := mongoImport.forFile("data/2014-07.csv.gz")
+			So(fileImport.InputOptions.File, ShouldEqual, "data/2014-07.csv.gz")
+			So(fileImport.ToolOptions.Collection, ShouldEqual, "2014-07")
+			So(mongoImport.InputOptions.File, ShouldEqual, "data/*.csv")
+			So(mongoImport.ToolOptions.Collection, ShouldEqual, "")
+		})
+		Convey("the specified collection should be used otherwise", func() {
+			namespace.Collection = testCollection
+			fileImport := mongoImport.forFile("data/2014-07.csv")
+			So(fileImport.ToolOptions.Collection, ShouldEqual, testCollection)
+		})
+	})
+}
@@ -1,13 +1,13 @@
 package mongoimport
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/shelman/mongo-tools-proto/common/bson_ext"
 	"io"
 	"labix.org/v2/mgo/bson"
-	"strings"
 )
 
 // JSONImportInput is an implementation of ImportInput that reads documents
@@ -16,11 +16,6 @@ type JSONImportInput struct {
 	// IsArray indicates if the JSON import is an array of JSON objects (true)
 	// or not
 	IsArray bool
-	// Decoder is used to read the next valid JSON object from the input source
-	Decoder *json.Decoder
-	// Reader is used to advance the underlying io.Reader implementation to skip
-	// things like array start/end and commas
-	Reader io.Reader
 	// NumImported indicates the number of JSON objects successfully parsed from
 	// the JSON input source
 	NumImported int64
@@ -28,12 +23,11 @@ type JSONImportInput struct {
 	// an opening bracket from the input source. Used to prevent errors when
 	// a JSON input source contains just '[]'
 	readOpeningBracket bool
-	// expectedByte is used to store the next expected valid character for JSON
-	// array imports
-	expectedByte byte
-	// bytesFromReader is used to store the next byte read from the Reader for
-	// JSON array imports
-	bytesFromReader []byte
+	// source reads the JSON objects from the input source, keeping track of
+	// line numbers and offsets
+	source *jsonSource
+	// lastRecord describes the JSON object last read from the input source
+	lastRecord InputRecord
 }
 
 const (
@@ -57,109 +51,263 @@ var (
 // NewJSONImportInput creates a new JSONImportInput in array mode if specified,
 // configured to read data to the given io.Reader
 func NewJSONImportInput(isArray bool, in io.Reader) *JSONImportInput {
-	return &JSONImportInput{
+	jsonImporter := &JSONImportInput{
 		IsArray:            isArray,
-		Decoder:            json.NewDecoder(in),
-		Reader:             in,
 		NumImported:        0,
 		readOpeningBracket: false,
-		bytesFromReader:    make([]byte, 1),
 	}
+	jsonImporter.setInput(newLineReader(in))
+	return jsonImporter
 }
 
-// SetHeader is a no-op for JSON imports
-func (jsonImporter *JSONImportInput) SetHeader() error {
+// jsonSourceBufferSize is the initial size of the buffer the input source is
+// read into. It only grows to hold JSON objects that are larger.
+const jsonSourceBufferSize = 4096
+
+// jsonSource splits the input source into JSON values - the objects to import
+// and, for JSON arrays, the separators between them - reading it into a single
+// buffer that is reused throughout, so that input of any length is read in
+// constant memory.
+type jsonSource struct {
+	lineReader *lineReader
+	// buffer holds the input read so far that is not yet consumed
+	buffer []byte
+	// leftover is the part of the buffer that is not yet consumed
+	leftover []byte
+}
+
+// fill reads more of the input source into the buffer, after the data not yet
+// consumed - which is first moved to the start of the buffer, or to a larger
+// buffer if it fills the whole buffer
+func (source *jsonSource) fill() error {
+	if source.buffer == nil {
+		source.buffer = make([]byte, jsonSourceBufferSize)
+	}
+	if len(source.leftover) == len(source.buffer) {
+		source.buffer = make([]byte, 2*len(source.buffer))
+	}
+	n := copy(source.buffer, source.leftover)
+	read, err := source.lineReader.Read(source.buffer[n:])
+	source.leftover = source.buffer[:n+read]
+	if read == 0 {
+		return err
+	}
 	return nil
 }
 
-// readJSONArraySeparator is a helper method used to process JSON arrays. It is
-// used to read any of the valid separators for a JSON array and flag invalid
-// characters.
-//
-// It will read a byte at a time until it finds an expected character after
-// which it returns control to the caller.
-//
-// TODO: single byte sized scans are inefficient!
-//
-// It will also return immediately if it finds any error (including EOF). If it
-// reads a JSON_ARRAY_END byte, as a validity check it will continue to scan the
-// input source until it hits an error (including EOF) to ensure the entire
-// input source content is a valid JSON array
-func (jsonImporter *JSONImportInput) readJSONArraySeparator() error {
-	jsonImporter.expectedByte = JSON_ARRAY_SEP
-	if jsonImporter.NumImported == 0 {
-		jsonImporter.expectedByte = JSON_ARRAY_START
+// consume returns the first n bytes not yet consumed, which stay valid until
+// the source is next read from, and marks them as consumed
+func (source *jsonSource) consume(n int) []byte {
+	consumed := source.leftover[:n]
+	source.leftover = source.leftover[n:]
+	return consumed
+}
+
+// isJSONDelimiter returns true for the characters that end a JSON number or
+// literal
+func isJSONDelimiter(b byte) bool {
+	switch b {
+	case ',', ':', '[', ']', '{', '}', '"':
+		return true
 	}
+	return isJSONSpace(b)
+}
 
-	var readByte byte
-	for readByte != jsonImporter.expectedByte {
-		n, err := jsonImporter.Reader.Read(jsonImporter.bytesFromReader)
-		if n == 0 || err != nil {
-			if err == io.EOF {
-				return ErrNoClosingBracket
+// readValue skips any whitespace and returns the text of the next JSON value,
+// which stays valid until the source is next read from. The value is only
+// delimited, not validated: an invalid value is returned as far as it can be
+// told apart from what follows it.
+func (source *jsonSource) readValue() ([]byte, error) {
+	if _, err := source.peekNonSpace(); err != nil {
+		return nil, err
+	}
+	depth := 0
+	inString, escaped := false, false
+	for index := 0; ; index++ {
+		for index == len(source.leftover) {
+			err := source.fill()
+			if err == io.EOF && depth == 0 && !inString {
+				// a number or literal ends with the input source
+				return source.consume(index), nil
+			} else if err == io.EOF {
+				return nil, io.ErrUnexpectedEOF
+			} else if err != nil {
+				return nil, err
 			}
-			return err
 		}
-		readByte = jsonImporter.bytesFromReader[0]
-
-		if readByte == JSON_ARRAY_END {
-			// if we read the end of the JSON array, ensure we have no other
-			// non-whitespace characters at the end of the array
-			for {
-				_, err = jsonImporter.Reader.Read(jsonImporter.bytesFromReader)
-				if err != nil {
-					// takes care of the '[]' case
-					if !jsonImporter.readOpeningBracket {
-						return ErrNoOpeningBracket
-					}
-					return err
-				}
-				readString := string(jsonImporter.bytesFromReader[0])
-				if strings.TrimSpace(readString) != "" {
-					return fmt.Errorf("bad JSON array format - found '%v' "+
-						"after '%v' in input source", readString,
-						string(JSON_ARRAY_END))
+		b := source.leftover[index]
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+				if depth == 0 {
+					return source.consume(index + 1), nil
 				}
 			}
+			continue
 		}
+		if depth == 0 && index > 0 && isJSONDelimiter(b) {
+			return source.consume(index), nil
+		}
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+		case '}', ']':
+			depth--
+			if depth <= 0 {
+				return source.consume(index + 1), nil
+			}
+		}
+	}
+}
+
+// isJSONSpace returns true for the whitespace characters allowed between JSON
+// values
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
 
-		// this will catch any invalid inter JSON object byte that occurs in the
-		// input source
-		if !(readByte == JSON_ARRAY_SEP ||
-			readByte == ' ' ||
-			readByte == JSON_ARRAY_START ||
-			readByte == JSON_ARRAY_END) {
-			if jsonImporter.expectedByte == JSON_ARRAY_START {
-				return ErrNoOpeningBracket
+// peekNonSpace skips any whitespace and returns the next byte of the input,
+// without consuming it
+func (source *jsonSource) peekNonSpace() (byte, error) {
+	for {
+		for index, b := range source.leftover {
+			if !isJSONSpace(b) {
+				source.leftover = source.leftover[index:]
+				return b, nil
 			}
-			return fmt.Errorf("bad JSON array format - found '%v' outside "+
-				"JSON object/array in input source", string(readByte))
 		}
+		source.leftover = source.leftover[len(source.leftover):]
+		if err := source.fill(); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// setInput makes the JSONImportInput read from the given lineReader
+func (jsonImporter *JSONImportInput) setInput(lineReader *lineReader) {
+	jsonImporter.source = &jsonSource{lineReader: lineReader}
+}
+
+// ResumeFrom continues reading JSON from the given io.Reader, which must be
+// positioned just after the given record
+func (jsonImporter *JSONImportInput) ResumeFrom(in io.Reader,
+	record InputRecord) {
+	jsonImporter.setInput(newLineReaderAt(in, record.Offset, record.LinesRead))
+	jsonImporter.lastRecord = record
+	// a JSON array continues with a separator - unless no object was read
+	if record.Number != 0 {
+		jsonImporter.NumImported = record.Number
+		jsonImporter.readOpeningBracket = true
 	}
-	jsonImporter.readOpeningBracket = true
+}
+
+// LastRecord returns the JSON object last read from the input source
+func (jsonImporter *JSONImportInput) LastRecord() InputRecord {
+	return jsonImporter.lastRecord
+}
+
+// SetHeader is a no-op for JSON imports
+func (jsonImporter *JSONImportInput) SetHeader() error {
 	return nil
 }
 
+// readJSONArraySeparator is a helper method used to process JSON arrays. It
+// reads the separator that precedes the next JSON object of the array - the
+// opening bracket for the first object and a comma for the others - skipping
+// any whitespace around it, and flags invalid characters.
+//
+// If it finds the closing bracket instead, as a validity check it scans the
+// rest of the input source to ensure the entire input source content is a valid
+// JSON array, and returns io.EOF.
+func (jsonImporter *JSONImportInput) readJSONArraySeparator() error {
+	source := jsonImporter.source
+	expectedByte := byte(JSON_ARRAY_START)
+	if jsonImporter.readOpeningBracket {
+		expectedByte = JSON_ARRAY_SEP
+	}
+
+	readByte, err := source.peekNonSpace()
+	if err != nil {
+		if err == io.EOF {
+			return ErrNoClosingBracket
+		}
+		return err
+	}
+	source.leftover = source.leftover[1:]
+
+	switch {
+	case readByte == expectedByte:
+		jsonImporter.readOpeningBracket = true
+		return nil
+	case readByte == JSON_ARRAY_END:
+		// takes care of the ']' case
+		if !jsonImporter.readOpeningBracket {
+			return ErrNoOpeningBracket
+		}
+		// ensure we have no other non-whitespace characters at the end of
+		// the array
+		readByte, err = source.peekNonSpace()
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("bad JSON array format - found '%v' after '%v' in "+
+			"input source", string(readByte), string(JSON_ARRAY_END))
+	case expectedByte == JSON_ARRAY_START:
+		return ErrNoOpeningBracket
+	}
+	return fmt.Errorf("bad JSON array format - found '%v' outside JSON "+
+		"object/array in input source", string(readByte))
+}
+
 // ImportDocument converts the given JSON object to a BSON object
 func (jsonImporter *JSONImportInput) ImportDocument() (bson.M, error) {
 	var document bson.M
 	if jsonImporter.IsArray {
+		openingBracket := !jsonImporter.readOpeningBracket
 		if err := jsonImporter.readJSONArraySeparator(); err != nil {
 			return nil, err
 		}
+		// an empty array has its closing bracket right after the opening one,
+		// which the next separator read takes care of
+		if openingBracket {
+			readByte, err := jsonImporter.source.peekNonSpace()
+			if err == io.EOF {
+				return nil, ErrNoClosingBracket
+			} else if err != nil {
+				return nil, err
+			}
+			if readByte == JSON_ARRAY_END {
+				return nil, jsonImporter.readJSONArraySeparator()
+			}
+		}
 	}
 
-	if err := jsonImporter.Decoder.Decode(&document); err != nil {
+	source := jsonImporter.source
+	value, err := source.readValue()
+	if err != nil {
 		return nil, err
 	}
+	// the record outlives the source's buffer
+	rawDocument := append([]byte(nil), value...)
 
-	// reinitialize the reader with data left in the decoder's buffer and the
-	// handle to the underlying reader
-	//
-	// TODO: this builds a massive tree - depending on the size of the input
-	// source; probably some room for optimization here?
-	jsonImporter.Reader = io.MultiReader(jsonImporter.Decoder.Buffered(),
-		jsonImporter.Reader)
+	jsonImporter.lastRecord.LinesRead = source.lineReader.lines -
+		int64(bytes.Count(source.leftover, []byte{'\n'}))
+	jsonImporter.lastRecord.Line = jsonImporter.lastRecord.LinesRead -
+		int64(bytes.Count(rawDocument, []byte{'\n'})) + 1
+	jsonImporter.lastRecord.Raw = rawDocument
+	jsonImporter.lastRecord.Offset = source.lineReader.offset -
+		int64(len(source.leftover))
+
+	if err := json.Unmarshal(rawDocument, &document); err != nil {
+		return nil, err
+	}
 
 	// convert any data produced by mongoexport to the appropriate underlying
 	// extended BSON type. NOTE: this assumes specially formated JSON values
@@ -173,12 +321,8 @@ func (jsonImporter *JSONImportInput) ImportDocument() (bson.M, error) {
 	//
 	// This applies for all the other extended JSON types MongoDB supports
 	if err := bson_ext.ConvertSubdocsFromJSON(document); err != nil {
-		return nil, err
+		return document, err
 	}
 	jsonImporter.NumImported++
-
-	// reinitialize the decoder with its existing buffer and the underlying
-	// reader
-	jsonImporter.Decoder = json.NewDecoder(jsonImporter.Reader)
 	return document, nil
 }
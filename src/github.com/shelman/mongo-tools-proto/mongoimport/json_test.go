@@ -1,11 +1,14 @@
 package mongoimport
 
 import (
+	"bytes"
+	"fmt"
 	. "github.com/smartystreets/goconvey/convey"
 	"io"
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -56,6 +59,22 @@ func TestJSONArrayImportDocument(t *testing.T) {
 				So(err, ShouldEqual, ErrNoClosingBracket)
 			})
 
+		Convey("empty JSON arrays should have no documents", func() {
+			for _, contents := range []string{"[]", "[ ]", "[\n]", " [\r\n\t] \n"} {
+				jsonImporter := NewJSONImportInput(true,
+					strings.NewReader(contents))
+				_, err := jsonImporter.ImportDocument()
+				So(err, ShouldEqual, io.EOF)
+			}
+			for _, contents := range []string{"[", "[ ", "[ ] x"} {
+				jsonImporter := NewJSONImportInput(true,
+					strings.NewReader(contents))
+				_, err := jsonImporter.ImportDocument()
+				So(err, ShouldNotBeNil)
+				So(err, ShouldNotEqual, io.EOF)
+			}
+		})
+
 		// TODO: we'll accept inputs like [[{},{}]] and just do nothing instead
 		// of alerting the user of an error
 		Convey("an error should be thrown if a plain JSON file is supplied",
@@ -300,9 +319,100 @@ func TestReadJSONArraySeparator(t *testing.T) {
 				_, err = jsonImporter.ImportDocument()
 				So(err, ShouldNotBeNil)
 			})
+		Convey("any JSON whitespace should be allowed between array elements",
+			func() {
+				contents := "\t[\r\n{\"a\": 1}\t,\n\n{\"a\": 2}\r\n]\n"
+				jsonImporter := NewJSONImportInput(true,
+					strings.NewReader(contents))
+				for i := 1; i <= 2; i++ {
+					document, err := jsonImporter.ImportDocument()
+					So(err, ShouldBeNil)
+					So(document["a"], ShouldEqual, i)
+				}
+				_, err = jsonImporter.ImportDocument()
+				So(err, ShouldEqual, io.EOF)
+			})
+		Convey("a nested array between objects should error out", func() {
+			contents := `[{"a":3}[{"b":4}]]`
+			jsonImporter := NewJSONImportInput(true, strings.NewReader(contents))
+			_, err = jsonImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			_, err = jsonImporter.ImportDocument()
+			So(err, ShouldNotBeNil)
+		})
+		Convey("large arrays on a single line should be streamed without "+
+			"holding on to the input", func() {
+			numDocs := 10000
+			contents := bytes.NewBufferString("[")
+			for i := 0; i < numDocs; i++ {
+				if i != 0 {
+					contents.WriteString(", ")
+				}
+				fmt.Fprintf(contents, `{"a": %v, "b": "some text"}`, i)
+			}
+			contents.WriteString("]")
+			jsonImporter := NewJSONImportInput(true, contents)
+			for i := 0; i < numDocs; i++ {
+				document, err := jsonImporter.ImportDocument()
+				So(err, ShouldBeNil)
+				So(document["a"], ShouldEqual, i)
+			}
+			_, err = jsonImporter.ImportDocument()
+			So(err, ShouldEqual, io.EOF)
+			So(len(jsonImporter.source.lineReader.captured), ShouldEqual, 0)
+			So(len(jsonImporter.source.buffer), ShouldEqual,
+				jsonSourceBufferSize)
+		})
+		Convey("objects should be split apart whatever their strings hold, "+
+			"and whatever their size", func() {
+			long := strings.Repeat("x", 3*jsonSourceBufferSize)
+			contents := `[{"a": "]}{\"[,"}, {"b": "\\"},` + "\n" +
+				`{"c": "` + long + `"}]`
+			jsonImporter := NewJSONImportInput(true, strings.NewReader(contents))
+			for _, expected := range []bson.M{
+				{"a": `]}{"[,`}, {"b": `\`}, {"c": long},
+			} {
+				document, err := jsonImporter.ImportDocument()
+				So(err, ShouldBeNil)
+				So(document, ShouldResemble, expected)
+			}
+			So(jsonImporter.LastRecord().Line, ShouldEqual, 2)
+			_, err = jsonImporter.ImportDocument()
+			So(err, ShouldEqual, io.EOF)
+		})
 		Reset(func() {
 			jsonFile.Close()
 			fileHandle.Close()
 		})
 	})
 }
+
+// BenchmarkJSONArrayImport reads a large JSON array on a single line, as
+// mongoexport --jsonArray writes it
+func BenchmarkJSONArrayImport(b *testing.B) {
+	contents := bytes.NewBufferString("[")
+	for i := 0; i < 100000; i++ {
+		if i != 0 {
+			contents.WriteString(",")
+		}
+		fmt.Fprintf(contents, `{"_id": %v, "name": "document %v", `+
+			`"tags": ["a", "b", "c"], "nested": {"x": 1.5, "y": true}}`, i, i)
+	}
+	contents.WriteString("]")
+	input := contents.Bytes()
+	b.SetBytes(int64(len(input)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		jsonImporter := NewJSONImportInput(true, bytes.NewReader(input))
+		for {
+			_, err := jsonImporter.ImportDocument()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package mongoimport
+
+import (
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// values accepted by --illegalKeys
+const (
+	// RejectKeys rejects documents with illegal field names
+	RejectKeys = "reject"
+	// ReplaceKeys replaces the illegal characters of field names
+	ReplaceKeys = "replace"
+	// EscapeKeys percent-encodes the illegal characters of field names
+	EscapeKeys = "escape"
+)
+
+// keyEscaper percent-encodes the characters field names can not contain, along
+// with '%' itself so that the escaping can be undone
+var keyEscaper = strings.NewReplacer("%", "%25", ".", "%2E", "\x00", "%00")
+
+// keyError describes a field name the server would refuse
+type keyError struct {
+	// Path is the dotted path to the field, with array elements given by
+	// their index
+	Path    string
+	Message string
+}
+
+func (err *keyError) Error() string {
+	return fmt.Sprintf("field name %v %v", strconv.Quote(err.Path), err.Message)
+}
+
+// keyChecker checks the field names of documents before they are written: no
+// field name may contain '.' or a null character, or start with '$'. It either
+// rejects documents with such field names or fixes the field names up.
+type keyChecker struct {
+	mode string
+	// replacement replaces illegal characters with ReplaceKeys
+	replacement string
+}
+
+// illegalKeyProblem returns what is wrong with the given field name, if
+// anything
+func illegalKeyProblem(key string) string {
+	switch {
+	case strings.HasPrefix(key, "$"):
+		return "starts with '$'"
+	case strings.Contains(key, "."):
+		return "contains '.'"
+	case strings.Contains(key, "\x00"):
+		return "contains a null character"
+	}
+	return ""
+}
+
+// rewrites returns true if the checker changes field names rather than
+// rejecting documents with illegal ones
+func (checker keyChecker) rewrites() bool {
+	return checker.mode == ReplaceKeys || checker.mode == EscapeKeys
+}
+
+// fixKey returns the given illegal field name with its illegal characters
+// replaced or escaped
+func (checker keyChecker) fixKey(key string) string {
+	if checker.mode == EscapeKeys {
+		key = keyEscaper.Replace(key)
+		if strings.HasPrefix(key, "$") {
+			key = "%24" + key[1:]
+		}
+		return key
+	}
+	key = strings.NewReplacer(".", checker.replacement,
+		"\x00", checker.replacement).Replace(key)
+	if strings.HasPrefix(key, "$") {
+		key = checker.replacement + key[1:]
+	}
+	return key
+}
+
+// check checks the field names of the given document - and of all of its
+// subdocuments - fixing them up in place unless illegal field names are
+// rejected, in which case a *keyError is returned for the first one found
+func (checker keyChecker) check(document bson.M) error {
+	return checker.checkDocument(document, "")
+}
+
+func (checker keyChecker) checkDocument(document bson.M, path string) error {
+	// go through the keys in order so that the same problem is reported
+	// whatever the order of fields in the input
+	keys := make([]string, 0, len(document))
+	for key := range document {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := document[key]
+		if checker.mode == EscapeKeys {
+			// '%' is escaped in every field name
+			if fixed := checker.fixKey(key); fixed != key {
+				if err := checker.rename(document, key, fixed,
+					path); err != nil {
+					return err
+				}
+				key = fixed
+			}
+		} else if problem := illegalKeyProblem(key); problem != "" {
+			if checker.mode != ReplaceKeys {
+				return &keyError{joinSchemaPath(path, key), problem}
+			}
+			fixed := checker.fixKey(key)
+			if err := checker.rename(document, key, fixed, path); err != nil {
+				return err
+			}
+			key = fixed
+		}
+		if err := checker.checkValue(value,
+			joinSchemaPath(path, key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rename moves a value to its fixed field name, which must not already be
+// taken
+func (checker keyChecker) rename(document bson.M, key, fixed,
+	path string) error {
+	if _, ok := document[fixed]; ok {
+		return &keyError{joinSchemaPath(path, key), fmt.Sprintf("can not "+
+			"become %v, which is already taken", strconv.Quote(fixed))}
+	}
+	document[fixed] = document[key]
+	delete(document, key)
+	return nil
+}
+
+// checkValue checks the field names of the subdocuments within the given
+// value
+func (checker keyChecker) checkValue(value interface{}, path string) error {
+	if document, ok := asDocument(value); ok {
+		return checker.checkDocument(document, path)
+	}
+	if array, ok := value.([]interface{}); ok {
+		for index, element := range array {
+			if err := checker.checkValue(element,
+				joinSchemaPath(path, strconv.Itoa(index))); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkDocumentSize returns an error if the given encoded document is over
+// the size limit of the server
+func checkDocumentSize(encoded bson.Raw) error {
+	if len(encoded.Data) > MaxBSONSize {
+		return fmt.Errorf("document is %v bytes, over the %v byte size limit",
+			len(encoded.Data), MaxBSONSize)
+	}
+	return nil
+}
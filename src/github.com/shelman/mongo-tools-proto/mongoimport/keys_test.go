@@ -0,0 +1,132 @@
+package mongoimport
+
+import (
+	commonOpts "github.com/shelman/mongo-tools-proto/common/options"
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"testing"
+)
+
+func TestKeyChecker(t *testing.T) {
+	Convey("Given a document with field names, on calling check", t, func() {
+		Convey("legal field names should be left alone", func() {
+			document := bson.M{"a": bson.M{"b$": 1, "c%d": []interface{}{
+				map[string]interface{}{"e": 2}}}}
+			So(keyChecker{mode: RejectKeys}.check(document), ShouldBeNil)
+			So(keyChecker{mode: ReplaceKeys}.check(document), ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"a": bson.M{"b$": 1,
+				"c%d": []interface{}{map[string]interface{}{"e": 2}}}})
+		})
+		Convey("illegal field names should be rejected with their path",
+			func() {
+				checker := keyChecker{mode: RejectKeys}
+				err := checker.check(bson.M{"a": []interface{}{1,
+					bson.M{"$b": 1}}})
+				So(err, ShouldHaveSameTypeAs, &keyError{})
+				So(err.(*keyError).Path, ShouldEqual, "a.1.$b")
+				So(err.Error(), ShouldContainSubstring, "starts with '$'")
+				err = checker.check(bson.M{"a": bson.M{"b.c": 1}})
+				So(err.(*keyError).Path, ShouldEqual, "a.b.c")
+				So(err.Error(), ShouldContainSubstring, "contains '.'")
+				err = checker.check(bson.M{"a\x00": 1})
+				So(err.Error(), ShouldContainSubstring, "null character")
+			})
+		Convey("illegal characters should be replaced", func() {
+			document := bson.M{"$a.b": bson.M{"c.d": 1}}
+			So(keyChecker{mode: ReplaceKeys, replacement: "_"}.check(
+				document), ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"_a_b": bson.M{"c_d": 1}})
+		})
+		Convey("illegal characters and '%' should be escaped", func() {
+			document := bson.M{"$a.b": 1, "c%": []interface{}{
+				bson.M{"d.e": 2}}}
+			So(keyChecker{mode: EscapeKeys}.check(document), ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"%24a%2Eb": 1,
+				"c%25": []interface{}{bson.M{"d%2Ee": 2}}})
+		})
+		Convey("fixed field names that are already taken should be "+
+			"rejected", func() {
+			err := keyChecker{mode: ReplaceKeys, replacement: "_"}.check(
+				bson.M{"a.b": 1, "a_b": 2})
+			So(err, ShouldHaveSameTypeAs, &keyError{})
+			So(err.Error(), ShouldContainSubstring, "already taken")
+		})
+	})
+}
+
+func TestCheckDocumentSize(t *testing.T) {
+	Convey("Documents over the BSON size limit should be refused", t,
+		func() {
+			So(checkDocumentSize(bson.Raw{Data: make([]byte, MaxBSONSize)}),
+				ShouldBeNil)
+			So(checkDocumentSize(bson.Raw{Data: make([]byte,
+				MaxBSONSize+1)}), ShouldNotBeNil)
+		})
+}
+
+func TestIllegalKeysImport(t *testing.T) {
+	Convey("Given a JSON file with an illegal field name", t, func() {
+		file, err := ioutil.TempFile("", "mongoimport_")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		_, err = file.WriteString("{\"a\": 1}\n{\"$b\": 2}\n{\"c.d\": 3}\n")
+		So(err, ShouldBeNil)
+		So(file.Close(), ShouldBeNil)
+		mongoImport := MongoImport{
+			ToolOptions: getBasicToolOptions(),
+			InputOptions: &options.InputOptions{
+				Type: JSON,
+				File: file.Name(),
+			},
+			IngestOptions: &options.IngestOptions{DryRun: true},
+		}
+		Convey("documents with illegal field names should be skipped",
+			func() {
+				numImported, err := mongoImport.ImportDocuments()
+				So(err, ShouldBeNil)
+				So(numImported, ShouldEqual, 1)
+				So(mongoImport.failed, ShouldEqual, 2)
+			})
+		Convey("with --stopOnError, the error should name the input line",
+			func() {
+				mongoImport.IngestOptions.StopOnError = true
+				_, err := mongoImport.ImportDocuments()
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "line 2")
+				So(err.Error(), ShouldContainSubstring, `"$b"`)
+			})
+		Convey("illegal field names may be replaced instead", func() {
+			mongoImport.IngestOptions.IllegalKeys = ReplaceKeys
+			mongoImport.IngestOptions.KeyReplacement = "_"
+			numImported, err := mongoImport.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numImported, ShouldEqual, 3)
+		})
+	})
+}
+
+func TestIllegalKeysSettings(t *testing.T) {
+	Convey("Bad --illegalKeys and --keyReplacement values should be "+
+		"rejected", t, func() {
+		for _, ingestOptions := range []*options.IngestOptions{
+			{IllegalKeys: "drop"},
+			{IllegalKeys: ReplaceKeys, KeyReplacement: "."},
+			{IllegalKeys: ReplaceKeys, KeyReplacement: "$"},
+		} {
+			mongoImport := MongoImport{
+				ToolOptions: &commonOpts.ToolOptions{
+					Namespace: &commonOpts.Namespace{
+						DB:         testDB,
+						Collection: testCollection,
+					},
+				},
+				InputOptions:  &options.InputOptions{},
+				IngestOptions: ingestOptions,
+			}
+			So(mongoImport.ValidateSettings(), ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,107 @@
+package mongoimport
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// lineReader wraps an input source so that every call to Read returns data
+// from at most a single line. Readers layered on top of it - like those from
+// bufio and encoding/json - consequently never read ahead of the line
+// on which the record they are decoding ends. This lets lineReader keep track
+// of line numbers and capture the raw text of each record.
+type lineReader struct {
+	in *bufio.Reader
+	// pending holds the part of the current line not yet returned by Read
+	pending []byte
+	// lines is the number of complete lines returned by Read so far
+	lines int64
+	// offset is the number of bytes returned by Read so far
+	offset int64
+	// atLineStart indicates that the last byte returned by Read ended a line
+	atLineStart bool
+	// captured holds everything returned by Read since the last call to
+	// startCapture, if it was ever called
+	captured  []byte
+	capturing bool
+}
+
+// newLineReader returns a lineReader reading from the given io.Reader
+func newLineReader(in io.Reader) *lineReader {
+	return &lineReader{
+		in:          bufio.NewReader(in),
+		atLineStart: true,
+	}
+}
+
+// newLineReaderAt returns a lineReader reading from an io.Reader that has
+// already been advanced past the given number of bytes and complete lines of
+// the input source
+func newLineReaderAt(in io.Reader, offset, lines int64) *lineReader {
+	reader := newLineReader(in)
+	reader.offset = offset
+	reader.lines = lines
+	return reader
+}
+
+// Read implements io.Reader, never reading past the end of the current line
+func (reader *lineReader) Read(p []byte) (int, error) {
+	if len(reader.pending) == 0 {
+		line, err := reader.in.ReadSlice('\n')
+		if len(line) == 0 {
+			return 0, err
+		}
+		// the slice stays valid as we only read again once it is consumed
+		reader.pending = line
+	}
+	n := copy(p, reader.pending)
+	if reader.capturing {
+		reader.captured = append(reader.captured, reader.pending[:n]...)
+	}
+	reader.lines += int64(bytes.Count(reader.pending[:n], []byte{'\n'}))
+	reader.offset += int64(n)
+	reader.atLineStart = reader.pending[n-1] == '\n'
+	reader.pending = reader.pending[n:]
+	return n, nil
+}
+
+// currentLine returns the number of the line that the last byte returned by
+// Read belongs to
+func (reader *lineReader) currentLine() int64 {
+	if reader.atLineStart {
+		return reader.lines
+	}
+	return reader.lines + 1
+}
+
+// startCapture discards any captured data, so that the next record read from
+// the lineReader can be retrieved with endCapture
+func (reader *lineReader) startCapture() {
+	reader.capturing = true
+	reader.captured = reader.captured[:0]
+}
+
+// discardCaptured discards any captured data without starting a capture
+func (reader *lineReader) discardCaptured() {
+	reader.captured = reader.captured[:0]
+}
+
+// endCapture returns the raw text read since the last call to startCapture,
+// along with the number of the line on which it starts. Leading blank lines
+// are not considered part of the text. The returned slice is only valid until
+// the next call to startCapture.
+func (reader *lineReader) endCapture() (int64, []byte) {
+	raw := reader.captured
+	line := reader.currentLine() - int64(bytes.Count(raw, []byte{'\n'}))
+	if reader.atLineStart {
+		line++
+	}
+	for len(raw) != 0 && (raw[0] == '\n' || raw[0] == '\r') {
+		if raw[0] == '\n' {
+			line++
+		}
+		raw = raw[1:]
+	}
+	return line, raw
+}
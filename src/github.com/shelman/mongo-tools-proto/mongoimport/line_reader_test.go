@@ -0,0 +1,93 @@
+package mongoimport
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestLineReader(t *testing.T) {
+	Convey("Given a line reader", t, func() {
+		Convey("no read should return data from more than one line", func() {
+			reader := newLineReader(strings.NewReader("ab\ncd\n"))
+			buf := make([]byte, 16)
+			n, err := reader.Read(buf)
+			So(err, ShouldBeNil)
+			So(string(buf[:n]), ShouldEqual, "ab\n")
+			So(reader.currentLine(), ShouldEqual, 1)
+			n, err = reader.Read(buf)
+			So(err, ShouldBeNil)
+			So(string(buf[:n]), ShouldEqual, "cd\n")
+			So(reader.currentLine(), ShouldEqual, 2)
+		})
+		Convey("all of the input should be returned", func() {
+			contents := "first\nsecond line\nno newline"
+			data, err := ioutil.ReadAll(newLineReader(strings.NewReader(contents)))
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, contents)
+		})
+		Convey("captured text should exclude leading blank lines", func() {
+			reader := newLineReader(strings.NewReader("\n\r\nab\n"))
+			reader.startCapture()
+			_, err := ioutil.ReadAll(reader)
+			So(err, ShouldBeNil)
+			line, raw := reader.endCapture()
+			So(line, ShouldEqual, 3)
+			So(string(raw), ShouldEqual, "ab\n")
+		})
+	})
+}
+
+func TestLastRecord(t *testing.T) {
+	Convey("With an import input", t, func() {
+		Convey("CSV records should be captured verbatim along with their "+
+			"line number", func() {
+			contents := "a,b\n\n1,\"multi\nline\"\n2,x"
+			csvImporter := NewCSVImportInput([]string{"a", "b"},
+				strings.NewReader(contents))
+			So(csvImporter.SetHeader(), ShouldBeNil)
+			So(csvImporter.LastRecord().Line, ShouldEqual, 1)
+			So(string(csvImporter.LastRecord().Raw), ShouldEqual, "a,b\n")
+			_, err := csvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(csvImporter.LastRecord().Line, ShouldEqual, 3)
+			So(string(csvImporter.LastRecord().Raw), ShouldEqual,
+				"1,\"multi\nline\"\n")
+			_, err = csvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(csvImporter.LastRecord().Line, ShouldEqual, 5)
+			So(string(csvImporter.LastRecord().Raw), ShouldEqual, "2,x")
+		})
+		Convey("TSV records should be captured verbatim along with their "+
+			"line number", func() {
+			tsvImporter := NewTSVImportInput([]string{"a"},
+				strings.NewReader("1\t2\n3\n"))
+			_, err := tsvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			_, err = tsvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(tsvImporter.LastRecord().Line, ShouldEqual, 2)
+			So(string(tsvImporter.LastRecord().Raw), ShouldEqual, "3\n")
+		})
+		Convey("JSON objects should be captured verbatim along with the line "+
+			"they start on", func() {
+			contents := "{\"a\": 1}\n{\n  \"b\": 2\n} {\"c\": 3}\n"
+			jsonImporter := NewJSONImportInput(false,
+				strings.NewReader(contents))
+			_, err := jsonImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(jsonImporter.LastRecord().Line, ShouldEqual, 1)
+			_, err = jsonImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(jsonImporter.LastRecord().Line, ShouldEqual, 2)
+			So(string(jsonImporter.LastRecord().Raw), ShouldEqual,
+				"{\n  \"b\": 2\n}")
+			_, err = jsonImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(jsonImporter.LastRecord().Line, ShouldEqual, 4)
+			So(string(jsonImporter.LastRecord().Raw), ShouldEqual,
+				"{\"c\": 3}")
+		})
+	})
+}
@@ -14,15 +14,19 @@ func main() {
 	// initialize command-line opts
 	usageStr := " --host myhost --db my_cms --collection docs < mydocfile." +
 		"json \n\nImport CSV, TSV or JSON data into MongoDB.\n\nWhen importing " +
-		"JSON documents, each document must be a separate line of the input file."
+		"JSON documents, each document must be a separate line of the input file." +
+		"\n\nFiles (or glob patterns) given after the options are imported " +
+		"along with --file."
 	opts := commonopts.New("mongoimport", "0.0.1", usageStr)
 
 	inputOpts := &options.InputOptions{}
 	opts.AddOptions(inputOpts)
 	ingestOpts := &options.IngestOptions{}
 	opts.AddOptions(ingestOpts)
+	progressOpts := &commonopts.Progress{}
+	opts.AddOptions(progressOpts)
 
-	_, err := opts.Parse()
+	args, err := opts.Parse()
 	if err != nil {
 		util.Panicf("error parsing command line options: %v", err)
 	}
@@ -37,10 +41,14 @@ func main() {
 		return
 	}
 
-	// create a session provider to connect to the db
-	sessionProvider, err := db.InitSessionProvider(opts)
-	if err != nil {
-		util.Panicf("error initializing database session: %v", err)
+	// create a session provider to connect to the db - unless this is a dry
+	// run, which never connects
+	var sessionProvider *db.SessionProvider
+	if !ingestOpts.DryRun {
+		sessionProvider, err = db.InitSessionProvider(opts)
+		if err != nil {
+			util.Panicf("error initializing database session: %v", err)
+		}
 	}
 
 	importer := mongoimport.MongoImport{
@@ -48,6 +56,8 @@ func main() {
 		InputOptions:    inputOpts,
 		IngestOptions:   ingestOpts,
 		SessionProvider: sessionProvider,
+		Files:           args,
+		ProgressOptions: progressOpts,
 	}
 
 	if err = importer.ValidateSettings(); err != nil {
@@ -61,6 +71,21 @@ func main() {
 		if numDocs != 1 {
 			message = fmt.Sprintf("imported %v objects\n", numDocs)
 		}
+		if ingestOpts.Mode == mongoimport.DeleteMode {
+			// deletes import nothing, and may match any number of documents
+			message = fmt.Sprintf("deleted 1 document\n")
+			if numDocs != 1 {
+				message = fmt.Sprintf("deleted %v documents\n", numDocs)
+			}
+		}
+		if ingestOpts.DryRun {
+			// a dry run writes nothing, so only says how much input it read
+			message = fmt.Sprintf("1 document validated, nothing imported\n")
+			if numDocs != 1 {
+				message = fmt.Sprintf("%v documents validated, nothing "+
+					"imported\n", numDocs)
+			}
+		}
 		util.PrintfTimeStamped(message)
 	}
 	if err != nil {
@@ -8,17 +8,31 @@ import (
 	"github.com/shelman/mongo-tools-proto/common/util"
 	"github.com/shelman/mongo-tools-proto/mongoimport/options"
 	"io"
+	"labix.org/v2/mgo"
 	"labix.org/v2/mgo/bson"
 	"os"
 	"path/filepath"
-	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
 )
 
 const (
 	CSV  = "csv"
 	TSV  = "tsv"
 	JSON = "json"
+	BSON = "bson"
+)
+
+// values accepted by --mode
+const (
+	InsertMode = "insert"
+	UpsertMode = "upsert"
+	MergeMode  = "merge"
+	DeleteMode = "delete"
 )
 
 // compile-time interface sanity check
@@ -26,12 +40,23 @@ var (
 	_ ImportInput = (*CSVImportInput)(nil)
 	_ ImportInput = (*TSVImportInput)(nil)
 	_ ImportInput = (*JSONImportInput)(nil)
+	_ ImportInput = (*BSONImportInput)(nil)
 )
 
 var (
 	errNsNotFound = errors.New("ns not found")
 )
 
+// workerBufferSize is the number of decoded documents buffered for each
+// insertion worker
+const workerBufferSize = 1000
+
+// workerResult holds the outcome of a single insertion worker
+type workerResult struct {
+	docsCount int64
+	err       error
+}
+
 // Wrapper for MongoImport functionality
 type MongoImport struct {
 	// generic mongo tool options
@@ -45,6 +70,46 @@ type MongoImport struct {
 
 	// SessionProvider is used for connecting to the database
 	SessionProvider *db.SessionProvider
+
+	// Files lists further files - or glob patterns - to import, in addition
+	// to the --file option
+	Files []string
+
+	// ProgressOptions defines how the progress of the import is reported, if
+	// at all
+	ProgressOptions *commonOpts.Progress
+
+	// rejects records the input that failed to be imported, if --rejectFile
+	// is specified
+	rejects *rejectWriter
+
+	// checkpoints tracks the progress of the import, if --checkpointFile is
+	// specified
+	checkpoints *checkpointer
+
+	// transform holds the rules applied to every document, if
+	// --transformFile is specified
+	transform transform
+
+	// schema is the schema every document must match, if --schema is
+	// specified
+	schema *schema
+
+	// dryRun collects statistics about the documents found, if --dryRun is
+	// specified
+	dryRun *dryRunReport
+
+	// progress reports the documents imported and the bytes of input read,
+	// unless progress reports are disabled
+	progress *util.ProgressReporter
+
+	// limiter paces the writes of all insertion workers, if any rate limit
+	// is specified
+	limiter *rateLimiter
+
+	// failed counts the input records that failed to be imported; it is
+	// updated atomically by the insertion workers
+	failed int64
 }
 
 // ImportInput is an interface that specifies how an input source should be
@@ -52,11 +117,48 @@ type MongoImport struct {
 type ImportInput interface {
 	// ImportDocument reads the given record from the given io.Reader according
 	// to the format supported by the underlying ImportInput implementation.
+	// If the record was read but could not be converted, a non-nil document
+	// is returned along with the error so that the import can skip the record
+	// and carry on; a nil document means the input can not be read any further.
 	ImportDocument() (bson.M, error)
 
 	// SetHeader sets the header for the CSV/TSV import when --headerline is
 	// specified
 	SetHeader() error
+
+	// LastRecord describes the input record last read by ImportDocument or
+	// SetHeader. Its raw text is only valid until the next read.
+	LastRecord() InputRecord
+
+	// ResumeFrom makes the ImportInput continue reading from the given
+	// io.Reader, which must be positioned just after the given record - as
+	// identified by its Number, Offset and LinesRead
+	ResumeFrom(in io.Reader, record InputRecord)
+}
+
+// InputRecord identifies a single record of the input source
+type InputRecord struct {
+	// Number is the position of the record among all documents in the input,
+	// starting at 1
+	Number int64
+	// Line is the line of the input source on which the record starts
+	Line int64
+	// Raw is the record's text, exactly as it appears in the input source
+	Raw []byte
+	// Offset is the number of bytes of the input source up to the end of the
+	// record, and LinesRead the number of complete lines within those bytes;
+	// together they mark where reading can resume after the record
+	Offset    int64
+	LinesRead int64
+}
+
+// String returns the way a record is referred to in error messages
+func (record InputRecord) String() string {
+	// records of BSON input are not on any line
+	if record.Line == 0 {
+		return fmt.Sprintf("#%v", record.Number)
+	}
+	return fmt.Sprintf("#%v (line %v)", record.Number, record.Line)
 }
 
 // ValidateSettings ensures that the tool specific options supplied for
@@ -73,7 +175,8 @@ func (mongoImport *MongoImport) ValidateSettings() error {
 	} else {
 		if !(mongoImport.InputOptions.Type == TSV ||
 			mongoImport.InputOptions.Type == JSON ||
-			mongoImport.InputOptions.Type == CSV) {
+			mongoImport.InputOptions.Type == CSV ||
+			mongoImport.InputOptions.Type == BSON) {
 			return fmt.Errorf("don't know what type [\"%v\"] is",
 				mongoImport.InputOptions.Type)
 		}
@@ -91,39 +194,322 @@ func (mongoImport *MongoImport) ValidateSettings() error {
 		}
 	}
 
+	if mongoImport.InputOptions.InputCompression == "" {
+		mongoImport.InputOptions.InputCompression = AutoCompression
+	} else if !isValidCompression(mongoImport.InputOptions.InputCompression) {
+		return fmt.Errorf("don't know what compression [\"%v\"] is",
+			mongoImport.InputOptions.InputCompression)
+	}
+
+	// --upsert is shorthand for --mode upsert
+	switch mongoImport.IngestOptions.Mode {
+	case "":
+		mongoImport.IngestOptions.Mode = mongoImport.writeMode()
+	case InsertMode, UpsertMode, MergeMode, DeleteMode:
+		if mongoImport.IngestOptions.Upsert &&
+			mongoImport.IngestOptions.Mode != UpsertMode {
+			return fmt.Errorf("--upsert can not be used with --mode %v",
+				mongoImport.IngestOptions.Mode)
+		}
+	default:
+		return fmt.Errorf("don't know what mode [\"%v\"] is",
+			mongoImport.IngestOptions.Mode)
+	}
+
+	switch mongoImport.IngestOptions.IllegalKeys {
+	case "":
+		mongoImport.IngestOptions.IllegalKeys = RejectKeys
+	case RejectKeys, ReplaceKeys, EscapeKeys:
+	default:
+		return fmt.Errorf("don't know what to do with illegal keys [\"%v\"]",
+			mongoImport.IngestOptions.IllegalKeys)
+	}
+	if strings.ContainsAny(mongoImport.IngestOptions.KeyReplacement,
+		".$\x00") {
+		return fmt.Errorf("key replacement can not contain '.', '$' or null " +
+			"characters")
+	}
+
+	if mongoImport.InputOptions.InputEncoding == "" {
+		mongoImport.InputOptions.InputEncoding = AutoEncoding
+	} else {
+		encoding, ok := normalizeEncoding(
+			mongoImport.InputOptions.InputEncoding)
+		if !ok {
+			return fmt.Errorf("don't know what encoding [\"%v\"] is",
+				mongoImport.InputOptions.InputEncoding)
+		}
+		mongoImport.InputOptions.InputEncoding = encoding
+	}
+
+	// BSON is neither text nor JSON
+	if mongoImport.InputOptions.Type == BSON {
+		if mongoImport.InputOptions.InputEncoding != AutoEncoding ||
+			mongoImport.InputOptions.StrictEncoding {
+			return fmt.Errorf("--inputEncoding and --strictEncoding can not " +
+				"be used with BSON imports")
+		}
+		if mongoImport.InputOptions.JSONArray {
+			return fmt.Errorf("--jsonArray can only be used with JSON imports")
+		}
+	}
+
+	// typed columns only apply to CSV/TSV
+	if mongoImport.InputOptions.ColumnsHaveTypes &&
+		!mongoImport.isDelimited() {
+		return fmt.Errorf("--columnsHaveTypes can only be used with CSV " +
+			"or TSV imports")
+	}
+
+	// the dialect options only apply to CSV
+	if mongoImport.InputOptions.Type == CSV {
+		if _, err := mongoImport.csvDialect(); err != nil {
+			return err
+		}
+	} else if mongoImport.InputOptions.Delimiter != "" ||
+		mongoImport.InputOptions.Quote != "" ||
+		mongoImport.InputOptions.Escape != "" ||
+		mongoImport.InputOptions.Comment != "" ||
+		mongoImport.InputOptions.TrimLeadingSpace ||
+		mongoImport.InputOptions.LazyQuotes {
+		return fmt.Errorf("--delimiter, --quote, --escape, --comment, " +
+			"--trimLeadingSpace and --lazyQuotes can only be used with CSV " +
+			"imports")
+	}
+	if mongoImport.InputOptions.SkipLines < 0 {
+		return fmt.Errorf("number of lines to skip can not be negative")
+	}
+	if mongoImport.InputOptions.SkipLines != 0 &&
+		!mongoImport.isDelimited() {
+		return fmt.Errorf("--skipLines can only be used with CSV or TSV " +
+			"imports")
+	}
+
+	// ensure the batch limits are within what the server accepts
+	if mongoImport.IngestOptions.BatchSize < 0 ||
+		mongoImport.IngestOptions.BatchSize > MaxWriteBatchSize {
+		return fmt.Errorf("batch size must be between 0 and %v",
+			MaxWriteBatchSize)
+	}
+	if mongoImport.IngestOptions.BatchBytes < 0 ||
+		mongoImport.IngestOptions.BatchBytes > MaxBSONSize {
+		return fmt.Errorf("batch byte size must be between 0 and %v",
+			MaxBSONSize)
+	}
+
+	if mongoImport.IngestOptions.NumInsertionWorkers < 0 {
+		return fmt.Errorf("number of insertion workers can not be negative")
+	}
+
+	if mongoImport.IngestOptions.MaxDocsPerSecond < 0 ||
+		mongoImport.IngestOptions.MaxBytesPerSecond < 0 ||
+		mongoImport.IngestOptions.MaxWriteLatency < 0 {
+		return fmt.Errorf("rate limits can not be negative")
+	}
+
+	if mongoImport.ProgressOptions != nil {
+		if _, err := mongoImport.ProgressOptions.NewReporter("import",
+			true); err != nil {
+			return err
+		}
+	}
+
+	if mongoImport.IngestOptions.WriteConcern != "" {
+		if _, err := parseWriteConcern(
+			mongoImport.IngestOptions.WriteConcern); err != nil {
+			return err
+		}
+	}
+	if mongoImport.IngestOptions.Ordered != "" {
+		if _, err := strconv.ParseBool(
+			mongoImport.IngestOptions.Ordered); err != nil {
+			return fmt.Errorf("--ordered must be true or false, not %v",
+				mongoImport.IngestOptions.Ordered)
+		}
+	}
+	// unordered writes carry on past failures and may be applied in any order
+	if !mongoImport.ordered() {
+		if mongoImport.IngestOptions.StopOnError {
+			return fmt.Errorf("--stopOnError requires ordered writes")
+		}
+		if mongoImport.IngestOptions.MaintainInsertionOrder {
+			return fmt.Errorf("--maintainInsertionOrder requires ordered " +
+				"writes")
+		}
+	}
+
+	if mongoImport.IngestOptions.NumParallelFiles < 0 {
+		return fmt.Errorf("number of parallel files can not be negative")
+	}
+
+	files, err := mongoImport.getInputFiles()
+	if err != nil {
+		return err
+	}
+
+	// checkpoints refer to offsets in a file - there's no going back on stdin
+	if mongoImport.IngestOptions.CheckpointFile != "" &&
+		(len(files) != 1 || files[0] == "") {
+		return fmt.Errorf("--checkpointFile requires a single input file")
+	}
+	if mongoImport.IngestOptions.Resume {
+		if mongoImport.IngestOptions.CheckpointFile == "" {
+			return fmt.Errorf("--resume requires --checkpointFile")
+		}
+		if mongoImport.IngestOptions.Drop {
+			return fmt.Errorf("--resume can not be used with --drop")
+		}
+	}
+	if mongoImport.IngestOptions.DryRun &&
+		mongoImport.IngestOptions.CheckpointFile != "" {
+		return fmt.Errorf("--dryRun can not be used with --checkpointFile")
+	}
+	if mongoImport.IngestOptions.CheckpointInterval < 0 {
+		return fmt.Errorf("checkpoint interval can not be negative")
+	}
+
 	// ensure we have a valid string to use for the collection
 	if mongoImport.ToolOptions.Namespace.Collection == "" {
-		if mongoImport.InputOptions.File == "" {
+		if files[0] == "" {
 			return fmt.Errorf("must specify a collection or filename")
 		}
-		fileBaseName := filepath.Base(mongoImport.InputOptions.File)
-		lastDotIndex := strings.LastIndex(fileBaseName, ".")
-		if lastDotIndex != -1 {
-			fileBaseName = fileBaseName[0:lastDotIndex]
+		util.PrintlnTimeStamped("no collection specified!")
+		if len(files) > 1 {
+			// each file is imported into the collection named after it
+			util.PrintlnTimeStamped("using each filename as its collection")
+			return nil
 		}
+		fileBaseName := collectionForFile(files[0])
 		mongoImport.ToolOptions.Namespace.Collection = fileBaseName
-		util.PrintlnTimeStamped("no collection specified!")
 		util.PrintfTimeStamped("using filename '%v' as collection\n", fileBaseName)
 	}
 	return nil
 }
 
-// getInputReader returns an io.Reader corresponding to the input location
+// getInputReader returns an io.Reader corresponding to the input location,
+// decompressing the input and converting it to UTF-8 if necessary
 func (mongoImport *MongoImport) getInputReader() (io.ReadCloser, error) {
+	encoding := AutoEncoding
+	if mongoImport.InputOptions.InputEncoding != "" {
+		var ok bool
+		encoding, ok = normalizeEncoding(mongoImport.InputOptions.InputEncoding)
+		if !ok {
+			return nil, fmt.Errorf("don't know what encoding [\"%v\"] is",
+				mongoImport.InputOptions.InputEncoding)
+		}
+	}
+	file := os.Stdin
 	if mongoImport.InputOptions.File != "" {
-		file, err := os.Open(mongoImport.InputOptions.File)
+		var err error
+		file, err = os.Open(mongoImport.InputOptions.File)
 		if err != nil {
 			return nil, err
 		}
-		return file, err
 	}
-	return os.Stdin, nil
+	var in io.ReadCloser = file
+	if mongoImport.progress != nil {
+		in = countedFile{file, mongoImport.progress}
+	}
+	compression := mongoImport.InputOptions.InputCompression
+	if compression == "" {
+		compression = AutoCompression
+	}
+	in = decompressInput(in, mongoImport.InputOptions.File, compression)
+	// BSON is binary, so it has no character encoding
+	if mongoImport.InputOptions.Type == BSON {
+		return in, nil
+	}
+	return transcodeInput(in, encoding,
+		mongoImport.InputOptions.StrictEncoding), nil
 }
 
 // ImportDocuments is used to write input data to the database. It returns the
-// number of documents successfully imported to the appropriate namespace and
-// any error encountered in doing this
-func (mongoImport *MongoImport) ImportDocuments() (int64, error) {
+// number of documents successfully imported to the appropriate namespace - or,
+// in delete mode, the number of documents deleted from it - and any error
+// encountered in doing this
+func (mongoImport *MongoImport) ImportDocuments() (docsCount int64, err error) {
+	files, err := mongoImport.getInputFiles()
+	if err != nil {
+		return 0, err
+	}
+
+	if mongoImport.IngestOptions.RejectFile != "" {
+		mongoImport.rejects, err = newRejectWriter(
+			mongoImport.IngestOptions.RejectFile)
+		if err != nil {
+			return 0, err
+		}
+		mongoImport.rejects.binary = mongoImport.InputOptions.Type == BSON
+		defer func() {
+			if closeErr := mongoImport.rejects.Close(); err == nil {
+				err = closeErr
+			}
+			mongoImport.rejects = nil
+		}()
+	}
+
+	if mongoImport.IngestOptions.TransformFile != "" {
+		mongoImport.transform, err = readTransform(
+			mongoImport.IngestOptions.TransformFile)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if mongoImport.IngestOptions.SchemaFile != "" {
+		mongoImport.schema, err = readSchema(
+			mongoImport.IngestOptions.SchemaFile)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if mongoImport.ProgressOptions != nil {
+		mongoImport.progress, err = mongoImport.ProgressOptions.NewReporter(
+			"import", mongoImport.ToolOptions.Verbosity != nil &&
+				mongoImport.ToolOptions.Quiet)
+		if err != nil {
+			return 0, err
+		}
+		mongoImport.progress.SetTotalBytes(inputSize(files))
+		mongoImport.progress.Start()
+		defer func() {
+			mongoImport.progress.Stop()
+			mongoImport.progress = nil
+		}()
+	}
+
+	// the rate limits apply to the import as a whole
+	if !mongoImport.IngestOptions.DryRun {
+		mongoImport.limiter = newRateLimiter(
+			mongoImport.IngestOptions.MaxDocsPerSecond,
+			mongoImport.IngestOptions.MaxBytesPerSecond,
+			time.Duration(mongoImport.IngestOptions.MaxWriteLatency)*
+				time.Millisecond)
+		defer func() { mongoImport.limiter = nil }()
+	}
+
+	if mongoImport.IngestOptions.DryRun {
+		mongoImport.dryRun = newDryRunReport()
+		defer func() {
+			mongoImport.dryRun.Print(os.Stdout,
+				atomic.LoadInt64(&mongoImport.failed))
+			mongoImport.dryRun = nil
+		}()
+	}
+
+	if len(files) == 1 {
+		fileImport := mongoImport.forFile(files[0])
+		docsCount, err = fileImport.importFile()
+		atomic.AddInt64(&mongoImport.failed, fileImport.failed)
+		return docsCount, err
+	}
+	return mongoImport.importFiles(files)
+}
+
+// importFile imports the documents in the input file. It is a helper to
+// ImportDocuments, called on the MongoImport returned by forFile.
+func (mongoImport *MongoImport) importFile() (int64, error) {
 	in, err := mongoImport.getInputReader()
 	if err != nil {
 		return 0, err
@@ -141,91 +527,478 @@ func (mongoImport *MongoImport) ImportDocuments() (int64, error) {
 		if err != nil {
 			return 0, err
 		}
+		// keep the header so that the rejected records can be re-imported
+		// with the same options
+		if mongoImport.rejects != nil {
+			err = mongoImport.rejects.WriteHeader(importInput.LastRecord())
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	if mongoImport.IngestOptions.CheckpointFile != "" {
+		if err = mongoImport.startCheckpoints(in, importInput); err != nil {
+			return 0, err
+		}
 	}
 	return mongoImport.importDocuments(importInput)
 }
 
+// startCheckpoints sets up checkpointing for the import. With --resume, it
+// also moves the input source and the ImportInput reading it past the records
+// dealt with by the import being resumed.
+func (mongoImport *MongoImport) startCheckpoints(in io.Reader,
+	importInput ImportInput) error {
+	// the header is never part of the records to import
+	start := InputRecord{}
+	if mongoImport.InputOptions.HeaderLine {
+		start = importInput.LastRecord()
+		start.Number = 0
+	}
+	if mongoImport.IngestOptions.Resume {
+		checkpoint, err := readCheckpoint(
+			mongoImport.IngestOptions.CheckpointFile)
+		if err != nil {
+			return err
+		}
+		if checkpoint == nil {
+			util.PrintfTimeStamped("no checkpoint found in '%v', starting "+
+				"from the beginning\n", mongoImport.IngestOptions.CheckpointFile)
+		} else {
+			absFile, err := filepath.Abs(mongoImport.InputOptions.File)
+			if err != nil {
+				return err
+			}
+			if checkpoint.File != absFile {
+				return fmt.Errorf("checkpoint file '%v' is for '%v', not '%v'",
+					mongoImport.IngestOptions.CheckpointFile, checkpoint.File,
+					absFile)
+			}
+			if checkpoint.Offset < start.Offset {
+				return fmt.Errorf("checkpoint file '%v' points into the "+
+					"header line", mongoImport.IngestOptions.CheckpointFile)
+			}
+			start = checkpoint.inputRecord()
+			if err = seekInput(in, start.Offset); err != nil {
+				return fmt.Errorf("error resuming from checkpoint: %v", err)
+			}
+			importInput.ResumeFrom(in, start)
+			util.PrintfTimeStamped("resuming after document #%v (byte %v)\n",
+				start.Number, start.Offset)
+		}
+	}
+	var err error
+	mongoImport.checkpoints, err = newCheckpointer(
+		mongoImport.IngestOptions.CheckpointFile,
+		mongoImport.InputOptions.File,
+		time.Duration(mongoImport.IngestOptions.CheckpointInterval)*time.Second,
+		start)
+	return err
+}
+
 // importDocuments is a helper to ImportDocuments and does all the ingestion
 // work by taking data from the 'importInput' source and writing it to the
 // appropriate namespace
 func (mongoImport *MongoImport) importDocuments(importInput ImportInput) (
 	int64, error) {
-	session := mongoImport.SessionProvider.GetSession()
-	defer session.Close()
-	connUrl := mongoImport.ToolOptions.Host
-	if mongoImport.ToolOptions.Port != "" {
-		connUrl = connUrl + ":" + mongoImport.ToolOptions.Port
-	}
-	fmt.Fprintf(os.Stdout, "connected to: %v\n", connUrl)
-	collection := session.DB(mongoImport.ToolOptions.DB).
-		C(mongoImport.ToolOptions.Collection)
+	// a dry run never connects to the server
+	if !mongoImport.IngestOptions.DryRun {
+		session := mongoImport.SessionProvider.GetSession()
+		defer session.Close()
+		connUrl := mongoImport.ToolOptions.Host
+		if mongoImport.ToolOptions.Port != "" {
+			connUrl = connUrl + ":" + mongoImport.ToolOptions.Port
+		}
+		fmt.Fprintf(os.Stdout, "connected to: %v\n", connUrl)
+		collection := session.DB(mongoImport.ToolOptions.DB).
+			C(mongoImport.ToolOptions.Collection)
 
-	// drop the database if necessary
-	if mongoImport.IngestOptions.Drop {
-		util.PrintfTimeStamped("dropping: %v.%v\n", mongoImport.ToolOptions.DB,
-			mongoImport.ToolOptions.Collection)
-		if err := collection.DropCollection(); err != nil {
-			// this is hacky but necessary :(
-			if err.Error() != errNsNotFound.Error() {
+		// drop the database if necessary
+		if mongoImport.IngestOptions.Drop {
+			if err := mongoImport.dropCollection(collection); err != nil {
 				return 0, err
 			}
 		}
+		if err := mongoImport.limiter.start(session); err != nil {
+			return 0, err
+		}
 	}
 
-	// trim upsert fields if supplied
+	// documents are matched on _id unless upsert fields are supplied
+	mode := mongoImport.writeMode()
 	var upsertFields []string
-	if mongoImport.IngestOptions.Upsert {
+	if mode != InsertMode {
+		upsertFields = []string{"_id"}
 		if len(mongoImport.IngestOptions.UpsertFields) != 0 {
 			upsertFields = strings.Split(mongoImport.IngestOptions.UpsertFields,
 				",")
 		}
 	}
+	keys := keyChecker{
+		mode:        mongoImport.IngestOptions.IllegalKeys,
+		replacement: mongoImport.IngestOptions.KeyReplacement,
+	}
 
-	docsCount := int64(0)
+	// fan the decoded documents out to the insertion workers
+	numWorkers := mongoImport.numInsertionWorkers()
+	documents := make(chan pendingDocument, numWorkers*workerBufferSize)
+	results := make(chan workerResult, numWorkers)
+	abort := make(chan struct{})
+	var abortOnce sync.Once
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			var docsCount int64
+			var err error
+			if mongoImport.IngestOptions.DryRun {
+				docsCount, err = mongoImport.dryRunDocuments(documents)
+			} else {
+				docsCount, err = mongoImport.ingestDocuments(documents)
+			}
+			if err != nil {
+				// stop decoding - the import can not succeed anymore
+				abortOnce.Do(func() { close(abort) })
+			}
+			results <- workerResult{docsCount, err}
+		}()
+	}
+
+	// read documents until the input is exhausted, an error occurs or one of
+	// the workers fails
+	var err error
+	record := int64(0)
+	if mongoImport.checkpoints != nil {
+		record = mongoImport.checkpoints.checkpoint.Record
+	}
+decode:
 	for {
-		document, err := importInput.ImportDocument()
+		var document bson.M
+		document, err = importInput.ImportDocument()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		record++
+		inputRecord := importInput.LastRecord()
+		inputRecord.Number = record
+		var pending pendingDocument
+		if err == nil && mongoImport.InputOptions.StrictEncoding &&
+			!utf8.Valid(inputRecord.Raw) {
+			err = fmt.Errorf("record is not valid in the input encoding")
+		}
+		if err == nil {
+			// ignore blank fields if specified
+			if mongoImport.IngestOptions.IgnoreBlanks &&
+				mongoImport.isDelimited() {
+				document = removeBlankFields(document)
+			}
+			err = mongoImport.transform.apply(document)
+			if err == nil {
+				err = keys.check(document)
+			}
+			if err == nil {
+				err = mongoImport.schema.validate(document)
+			}
+			if err == nil {
+				pending, err = newPendingDocument(mode, upsertFields,
+					document)
+			}
+		}
 		if err != nil {
-			if err == io.EOF {
-				return docsCount, nil
+			class, action := rejectParse, "parsing"
+			switch err.(type) {
+			case *schemaError, *keyError:
+				class, action = rejectValidate, "validating"
+			}
+			// only records that were read in full can be rejected
+			if document != nil {
+				if rejectErr := mongoImport.reject(inputRecord, class,
+					err); rejectErr != nil {
+					err = rejectErr
+					break
+				}
+			}
+			if mongoImport.IngestOptions.StopOnError || document == nil {
+				err = fmt.Errorf("error %v document %v: %v", action,
+					inputRecord, err)
+				break
+			}
+			fmt.Fprintf(os.Stderr, "error %v document %v: %v\n", action,
+				inputRecord, err)
+			if err = mongoImport.confirm(inputRecord); err != nil {
+				break
 			}
-			if mongoImport.IngestOptions.StopOnError {
+			continue
+		}
+
+		// documents read from BSON are written exactly as they were read -
+		// keeping the order and types of their fields - unless they have
+		// been changed since
+		if mongoImport.InputOptions.Type == BSON &&
+			len(mongoImport.transform) == 0 && !keys.rewrites() {
+			pending.raw = bson.Raw{Kind: 0x03,
+				Data: append([]byte(nil), inputRecord.Raw...)}
+		}
+
+		// the raw text is only needed - and only remains valid - until the
+		// record is rejected by an insertion worker
+		if mongoImport.rejects == nil {
+			inputRecord.Raw = nil
+		} else {
+			inputRecord.Raw = append([]byte(nil), inputRecord.Raw...)
+		}
+		pending.record = inputRecord
+
+		select {
+		case documents <- pending:
+		case <-abort:
+			break decode
+		}
+	}
+
+	// wait for the workers to write out what is left and tally their counts;
+	// a write error takes precedence over any error reading the input
+	close(documents)
+	docsCount := int64(0)
+	for i := 0; i < numWorkers; i++ {
+		result := <-results
+		docsCount += result.docsCount
+		if result.err != nil {
+			err = result.err
+		}
+	}
+
+	// record whatever progress was made - even if the import failed
+	if mongoImport.checkpoints != nil {
+		if flushErr := mongoImport.checkpoints.Flush(); err == nil {
+			err = flushErr
+		}
+	}
+	return docsCount, err
+}
+
+// isDelimited returns true for the input types made of delimited fields: CSV
+// and TSV
+func (mongoImport *MongoImport) isDelimited() bool {
+	return mongoImport.InputOptions.Type == CSV ||
+		mongoImport.InputOptions.Type == TSV
+}
+
+// writeMode returns how documents are written to the server: the --mode
+// option if set, otherwise upsert mode with --upsert and insert mode without
+func (mongoImport *MongoImport) writeMode() string {
+	if mongoImport.IngestOptions.Mode != "" {
+		return mongoImport.IngestOptions.Mode
+	}
+	if mongoImport.IngestOptions.Upsert {
+		return UpsertMode
+	}
+	return InsertMode
+}
+
+// newPendingDocument returns how the given document is to be written in the
+// given mode, using the given upsert fields to match existing documents.
+// Documents with no value for any of the upsert fields are inserted - except
+// in delete mode, where there is nothing to match them against.
+func newPendingDocument(mode string, upsertFields []string,
+	document bson.M) (pendingDocument, error) {
+	pending := pendingDocument{op: insertOp, document: document}
+	if mode == InsertMode {
+		return pending, nil
+	}
+	pending.selector = constructUpsertDocument(upsertFields, document)
+	if pending.selector == nil {
+		if mode == DeleteMode {
+			return pending, fmt.Errorf("document has no value for any of the "+
+				"upsert fields (%v)", strings.Join(upsertFields, ","))
+		}
+		return pending, nil
+	}
+	switch mode {
+	case UpsertMode:
+		pending.op = upsertOp
+	case MergeMode:
+		pending.op = mergeOp
+	case DeleteMode:
+		pending.op = deleteOp
+	}
+	return pending, nil
+}
+
+// confirm marks the given records as dealt with for checkpointing
+func (mongoImport *MongoImport) confirm(records ...InputRecord) error {
+	if mongoImport.checkpoints == nil {
+		return nil
+	}
+	return mongoImport.checkpoints.Confirm(records...)
+}
+
+// dropCollection drops the collection documents are imported to
+func (mongoImport *MongoImport) dropCollection(
+	collection *mgo.Collection) error {
+	util.PrintfTimeStamped("dropping: %v.%v\n", collection.Database.Name,
+		collection.Name)
+	if err := collection.DropCollection(); err != nil {
+		// this is hacky but necessary :(
+		if err.Error() != errNsNotFound.Error() {
+			return err
+		}
+	}
+	return nil
+}
+
+// ordered returns true if batches are written with ordered writes, which is
+// the default
+func (mongoImport *MongoImport) ordered() bool {
+	ordered, err := strconv.ParseBool(mongoImport.IngestOptions.Ordered)
+	return err != nil || ordered
+}
+
+// numInsertionWorkers returns the number of workers to write documents with.
+// Input order can only be maintained with a single worker.
+func (mongoImport *MongoImport) numInsertionWorkers() int {
+	if mongoImport.IngestOptions.MaintainInsertionOrder ||
+		mongoImport.IngestOptions.DryRun ||
+		mongoImport.IngestOptions.NumInsertionWorkers < 1 {
+		return 1
+	}
+	return mongoImport.IngestOptions.NumInsertionWorkers
+}
+
+// ingestDocuments is run by each insertion worker. It batches the documents
+// received on the given channel and writes them to the server over its own
+// session until the channel is closed or - with --stopOnError - a document
+// fails to be written. Batches are paced by the import's rate limiter, if any.
+// It returns the number of documents written.
+func (mongoImport *MongoImport) ingestDocuments(
+	documents <-chan pendingDocument) (int64, error) {
+	session := mongoImport.SessionProvider.GetSession()
+	defer session.Close()
+	if mongoImport.IngestOptions.WriteConcern != "" {
+		safe, err := parseWriteConcern(mongoImport.IngestOptions.WriteConcern)
+		if err != nil {
+			return 0, err
+		}
+		session.SetSafe(safe)
+	}
+	collection := session.DB(mongoImport.ToolOptions.DB).
+		C(mongoImport.ToolOptions.Collection)
+
+	batch := newDocumentBatch(mongoImport.limiter.batchLimits(
+		mongoImport.IngestOptions.BatchSize,
+		mongoImport.IngestOptions.BatchBytes))
+	batch.ordered = mongoImport.ordered()
+	docsCount := int64(0)
+	flush := func() error {
+		mongoImport.limiter.wait(batch.Len(), batch.size)
+		started := time.Now()
+		written, err := batch.Write(collection, mongoImport.handleWriteError)
+		elapsed := time.Since(started)
+		docsCount += written
+		mongoImport.progress.AddDocuments(written)
+		if err == nil {
+			err = mongoImport.confirm(batch.records...)
+		}
+		if err == nil {
+			err = mongoImport.limiter.wrote(session, elapsed)
+		}
+		batch.Reset()
+		return err
+	}
+
+	for pending := range documents {
+		encoded, err := pending.encode()
+		if err == nil {
+			// the server would only refuse the document with an opaque error
+			err = checkDocumentSize(encoded)
+		}
+		if err != nil {
+			if err = mongoImport.handleWriteError(pending.record,
+				err); err != nil {
+				if flushErr := flush(); flushErr != nil {
+					return docsCount, flushErr
+				}
 				return docsCount, err
 			}
-			if document == nil {
+			if err = mongoImport.confirm(pending.record); err != nil {
 				return docsCount, err
 			}
 			continue
 		}
-
-		// ignore blank fields if specified
-		if mongoImport.IngestOptions.IgnoreBlanks &&
-			mongoImport.InputOptions.Type != JSON {
-			document = removeBlankFields(document)
+		if !batch.Fits(pending.op, statementSize(pending.op, encoded,
+			pending.selector)) {
+			if err = flush(); err != nil {
+				return docsCount, err
+			}
 		}
+		batch.Add(pending.op, encoded, pending.selector, pending.record)
+	}
+	return docsCount, flush()
+}
 
-		// if upsert is specified without any fields, default to inserts
-		if mongoImport.IngestOptions.Upsert {
-			selector := constructUpsertDocument(upsertFields, document)
-			if selector == nil {
-				err = collection.Insert(document)
-			} else {
-				_, err = collection.Upsert(selector, document)
+// dryRunDocuments is run instead of ingestDocuments by the single insertion
+// worker of a dry run. It checks that each document received on the given
+// channel can be encoded within the BSON size limit, and adds it to the dry run
+// report. It returns the number of documents that would have been written.
+func (mongoImport *MongoImport) dryRunDocuments(
+	documents <-chan pendingDocument) (int64, error) {
+	docsCount := int64(0)
+	for pending := range documents {
+		encoded, err := pending.encode()
+		if err == nil {
+			if err = checkDocumentSize(encoded); err != nil {
+				mongoImport.dryRun.AddOversized()
 			}
-		} else {
-			err = collection.Insert(document)
 		}
 		if err != nil {
-			if mongoImport.IngestOptions.StopOnError {
+			if err = mongoImport.handleWriteError(pending.record,
+				err); err != nil {
 				return docsCount, err
 			}
-			fmt.Fprintf(os.Stderr, "error inserting document: %v\n", err)
 			continue
 		}
+		mongoImport.dryRun.Add(pending.document)
+		mongoImport.progress.AddDocuments(1)
 		docsCount++
 	}
 	return docsCount, nil
 }
 
+// handleWriteError reports a document that could not be written to the
+// server - either because it could not be encoded or because the server
+// rejected it. It returns a non-nil error only if the import should be aborted.
+func (mongoImport *MongoImport) handleWriteError(record InputRecord,
+	err error) error {
+	class := rejectEncode
+	if _, ok := err.(*writeError); ok {
+		class = rejectWrite
+	}
+	if rejectErr := mongoImport.reject(record, class, err); rejectErr != nil {
+		return rejectErr
+	}
+	err = fmt.Errorf("error inserting document %v: %v", record, err)
+	if mongoImport.IngestOptions.StopOnError {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "%v\n", err)
+	return nil
+}
+
+// reject counts the given record as failed, and writes it to the reject file
+// if one was specified
+func (mongoImport *MongoImport) reject(record InputRecord, class string,
+	err error) error {
+	atomic.AddInt64(&mongoImport.failed, 1)
+	if mongoImport.rejects == nil {
+		return nil
+	}
+	if rejectErr := mongoImport.rejects.Reject(mongoImport.InputOptions.File,
+		record, class, err); rejectErr != nil {
+		return fmt.Errorf("error writing to reject file: %v", rejectErr)
+	}
+	return nil
+}
+
 // constructUpsertDocument constructs a BSON document to use for upserts
 func constructUpsertDocument(upsertFields []string, document bson.M) bson.M {
 	upsertDocument := bson.M{}
@@ -242,6 +1015,40 @@ func constructUpsertDocument(upsertFields []string, document bson.M) bson.M {
 	return upsertDocument
 }
 
+// constructMergeDocument constructs the update that sets each field of the
+// given document on the document it is applied to, leaving all other fields
+// as they are. Subdocuments are merged field by field rather than replaced.
+// As an _id can not be modified, it is only set when a document is inserted.
+func constructMergeDocument(document bson.M) bson.M {
+	fields := bson.M{}
+	for key, value := range document {
+		if key != "_id" {
+			setMergeFields(key, value, fields)
+		}
+	}
+	mergeDocument := bson.M{}
+	if len(fields) != 0 {
+		mergeDocument["$set"] = fields
+	}
+	if id, ok := document["_id"]; ok {
+		mergeDocument["$setOnInsert"] = bson.M{"_id": id}
+	}
+	return mergeDocument
+}
+
+// setMergeFields adds the given value to fields under the given dotted path,
+// descending into non-empty subdocuments
+func setMergeFields(path string, value interface{}, fields bson.M) {
+	subDoc, ok := value.(bson.M)
+	if !ok || len(subDoc) == 0 {
+		fields[path] = value
+		return
+	}
+	for key, subValue := range subDoc {
+		setMergeFields(path+"."+key, subValue, fields)
+	}
+}
+
 // getUpsertValue takes a given BSON document and a given field, and returns the
 // field's associated value in the document. The field is specified using dot
 // notation for nested fields. e.g. "person.age" would return 34 would return
@@ -263,25 +1070,80 @@ func getUpsertValue(field string, document bson.M) interface{} {
 	return getUpsertValue(field[index+1:], subDoc)
 }
 
-// removeBlankFields removes empty/blank fields in csv and tsv
+// removeBlankFields removes empty/blank fields in csv and tsv, including those
+// in subdocuments. Subdocuments left empty are removed as well.
 func removeBlankFields(document bson.M) bson.M {
 	for key, value := range document {
-		if reflect.TypeOf(value).Kind() == reflect.String &&
-			value.(string) == "" {
-			delete(document, key)
+		switch typedValue := value.(type) {
+		case string:
+			if typedValue == "" {
+				delete(document, key)
+			}
+		case bson.M:
+			if len(removeBlankFields(typedValue)) == 0 {
+				delete(document, key)
+			}
 		}
 	}
 	return document
 }
 
+// csvDialect returns the CSV dialect described by the input options. Each of
+// the characters it is made up of must be distinct, except that a quote may
+// be escaped by doubling it.
+func (mongoImport *MongoImport) csvDialect() (CSVDialect, error) {
+	dialect := DefaultCSVDialect
+	dialect.TrimLeadingSpace = mongoImport.InputOptions.TrimLeadingSpace
+	dialect.LazyQuotes = mongoImport.InputOptions.LazyQuotes
+	options := []struct {
+		name  string
+		value string
+		char  *rune
+	}{
+		{"delimiter", mongoImport.InputOptions.Delimiter, &dialect.Delimiter},
+		{"quote", mongoImport.InputOptions.Quote, &dialect.Quote},
+		{"escape", mongoImport.InputOptions.Escape, &dialect.Escape},
+		{"comment", mongoImport.InputOptions.Comment, &dialect.Comment},
+	}
+	for _, option := range options {
+		value := option.value
+		if value == "" {
+			continue
+		}
+		if value == `\t` {
+			value = "\t"
+		}
+		char, size := utf8.DecodeRuneInString(value)
+		if size != len(value) || char == utf8.RuneError || char == '\r' ||
+			char == '\n' {
+			return dialect, fmt.Errorf("--%v must be a single character "+
+				"other than a line break", option.name)
+		}
+		*option.char = char
+	}
+	// quotes are escaped by doubling them unless specified otherwise
+	if mongoImport.InputOptions.Escape == "" {
+		dialect.Escape = dialect.Quote
+	}
+	if dialect.Delimiter == dialect.Quote ||
+		dialect.Escape == dialect.Delimiter ||
+		(dialect.Comment != 0 && (dialect.Comment == dialect.Delimiter ||
+			dialect.Comment == dialect.Quote ||
+			dialect.Comment == dialect.Escape)) {
+		return dialect, fmt.Errorf("the delimiter, quote, escape and comment " +
+			"characters must be different")
+	}
+	return dialect, nil
+}
+
 // getImportInput returns an implementation of ImportInput which can handle
-// transforming tsv, csv, or JSON into appropriate BSON documents
+// transforming tsv, csv, JSON or BSON into appropriate BSON documents
 func (mongoImport *MongoImport) getImportInput(in io.Reader) (ImportInput,
 	error) {
 	var fields []string
 	var err error
-	// there should be some sanity checks done for field names - e.g. that they
-	// don't contain dots
+	// dotted field names denote nested fields; the field names of each
+	// document are checked by a keyChecker once it is decoded
 	if len(mongoImport.InputOptions.Fields) != 0 {
 		fields = strings.Split(strings.Trim(mongoImport.InputOptions.Fields,
 			" "), ",")
@@ -291,10 +1153,34 @@ func (mongoImport *MongoImport) getImportInput(in io.Reader) (ImportInput,
 			return nil, err
 		}
 	}
+	var parsers []FieldParser
+	if mongoImport.InputOptions.ColumnsHaveTypes {
+		fields, parsers, err = ParseTypedFields(fields)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err = validateFields(fields); err != nil {
+		return nil, err
+	}
 	if mongoImport.InputOptions.Type == CSV {
-		return NewCSVImportInput(fields, in), nil
+		dialect, err := mongoImport.csvDialect()
+		if err != nil {
+			return nil, err
+		}
+		csvImportInput := NewCSVImportInputWithDialect(fields, dialect, in)
+		csvImportInput.ColumnsHaveTypes = mongoImport.InputOptions.ColumnsHaveTypes
+		csvImportInput.parsers = parsers
+		err = csvImportInput.SkipLines(mongoImport.InputOptions.SkipLines)
+		return csvImportInput, err
 	} else if mongoImport.InputOptions.Type == TSV {
-		return NewTSVImportInput(fields, in), nil
+		tsvImportInput := NewTSVImportInput(fields, in)
+		tsvImportInput.ColumnsHaveTypes = mongoImport.InputOptions.ColumnsHaveTypes
+		tsvImportInput.parsers = parsers
+		err = tsvImportInput.SkipLines(mongoImport.InputOptions.SkipLines)
+		return tsvImportInput, err
+	} else if mongoImport.InputOptions.Type == BSON {
+		return NewBSONImportInput(in), nil
 	}
 	return NewJSONImportInput(mongoImport.InputOptions.JSONArray, in), nil
 }
@@ -236,6 +236,222 @@ func TestMongoImportValidateSettings(t *testing.T) {
 			So(mongoImport.ToolOptions.Namespace.Collection, ShouldEqual,
 				"input")
 		})
+
+		Convey("the compression extension should be ignored when deriving "+
+			"the collection name from a compressed file", func() {
+			namespace := &commonOpts.Namespace{
+				DB: testDB,
+			}
+			toolOptions := &commonOpts.ToolOptions{
+				Namespace: namespace,
+			}
+			inputOptions := &options.InputOptions{
+				File: "/path/to/input/file/dot/input.json.gz",
+			}
+			ingestOptions := &options.IngestOptions{}
+			mongoImport := MongoImport{
+				ToolOptions:   toolOptions,
+				InputOptions:  inputOptions,
+				IngestOptions: ingestOptions,
+			}
+			So(mongoImport.ValidateSettings(), ShouldBeNil)
+			So(mongoImport.ToolOptions.Namespace.Collection, ShouldEqual,
+				"input")
+		})
+
+		Convey("an error should be thrown for unknown input compressions",
+			func() {
+				namespace := &commonOpts.Namespace{
+					DB:         testDB,
+					Collection: testCollection,
+				}
+				toolOptions := &commonOpts.ToolOptions{
+					Namespace: namespace,
+				}
+				inputOptions := &options.InputOptions{
+					InputCompression: "lzma",
+				}
+				ingestOptions := &options.IngestOptions{}
+				mongoImport := MongoImport{
+					ToolOptions:   toolOptions,
+					InputOptions:  inputOptions,
+					IngestOptions: ingestOptions,
+				}
+				So(mongoImport.ValidateSettings(), ShouldNotBeNil)
+			})
+
+		Convey("an error should be thrown for invalid CSV dialects, or "+
+			"dialect options for other input types", func() {
+			for _, inputOptions := range []*options.InputOptions{
+				{Type: CSV, Fields: "a", Delimiter: ";;"},
+				{Type: CSV, Fields: "a", Quote: "\n"},
+				{Type: CSV, Fields: "a", Delimiter: "'", Quote: "'"},
+				{Type: CSV, Fields: "a", Comment: "\""},
+				{Type: TSV, Fields: "a", Delimiter: ";"},
+				{Type: JSON, SkipLines: 1},
+				{Type: CSV, Fields: "a", SkipLines: -1},
+			} {
+				namespace := &commonOpts.Namespace{
+					DB:         testDB,
+					Collection: testCollection,
+				}
+				mongoImport := MongoImport{
+					ToolOptions: &commonOpts.ToolOptions{
+						Namespace: namespace,
+					},
+					InputOptions:  inputOptions,
+					IngestOptions: &options.IngestOptions{},
+				}
+				So(mongoImport.ValidateSettings(), ShouldNotBeNil)
+			}
+		})
+
+		Convey("the CSV dialect should be built from the input options",
+			func() {
+				mongoImport := MongoImport{
+					InputOptions: &options.InputOptions{
+						Type:       CSV,
+						Delimiter:  `\t`,
+						Quote:      "'",
+						LazyQuotes: true,
+					},
+				}
+				dialect, err := mongoImport.csvDialect()
+				So(err, ShouldBeNil)
+				So(dialect, ShouldResemble, CSVDialect{
+					Delimiter:  '\t',
+					Quote:      '\'',
+					Escape:     '\'',
+					LazyQuotes: true,
+				})
+			})
+
+		Convey("the mode should default to upsert with --upsert and to "+
+			"insert otherwise", func() {
+			for _, upsert := range []bool{true, false} {
+				namespace := &commonOpts.Namespace{
+					DB:         testDB,
+					Collection: testCollection,
+				}
+				mongoImport := MongoImport{
+					ToolOptions: &commonOpts.ToolOptions{
+						Namespace: namespace,
+					},
+					InputOptions:  &options.InputOptions{},
+					IngestOptions: &options.IngestOptions{Upsert: upsert},
+				}
+				So(mongoImport.ValidateSettings(), ShouldBeNil)
+				if upsert {
+					So(mongoImport.IngestOptions.Mode, ShouldEqual, UpsertMode)
+				} else {
+					So(mongoImport.IngestOptions.Mode, ShouldEqual, InsertMode)
+				}
+			}
+		})
+
+		Convey("an error should be thrown for unknown modes or for --upsert "+
+			"with another mode", func() {
+			for _, ingestOptions := range []*options.IngestOptions{
+				{Mode: "replace"},
+				{Mode: MergeMode, Upsert: true},
+			} {
+				namespace := &commonOpts.Namespace{
+					DB:         testDB,
+					Collection: testCollection,
+				}
+				mongoImport := MongoImport{
+					ToolOptions: &commonOpts.ToolOptions{
+						Namespace: namespace,
+					},
+					InputOptions:  &options.InputOptions{},
+					IngestOptions: ingestOptions,
+				}
+				So(mongoImport.ValidateSettings(), ShouldNotBeNil)
+			}
+		})
+
+		Convey("an error should be thrown for bad write concerns or ordered "+
+			"values, or for unordered writes with --stopOnError or "+
+			"--maintainInsertionOrder", func() {
+			for _, ingestOptions := range []*options.IngestOptions{
+				{WriteConcern: "-1"},
+				{WriteConcern: "{w: 1}"},
+				{Ordered: "sometimes"},
+				{Ordered: "false", StopOnError: true},
+				{Ordered: "false", MaintainInsertionOrder: true},
+			} {
+				namespace := &commonOpts.Namespace{
+					DB:         testDB,
+					Collection: testCollection,
+				}
+				mongoImport := MongoImport{
+					ToolOptions: &commonOpts.ToolOptions{
+						Namespace: namespace,
+					},
+					InputOptions:  &options.InputOptions{},
+					IngestOptions: ingestOptions,
+				}
+				So(mongoImport.ValidateSettings(), ShouldNotBeNil)
+			}
+		})
+
+		Convey("BSON imports should not take text or JSON options", func() {
+			for _, inputOptions := range []*options.InputOptions{
+				{Type: BSON, InputEncoding: "latin1"},
+				{Type: BSON, StrictEncoding: true},
+				{Type: BSON, JSONArray: true},
+				{Type: BSON, ColumnsHaveTypes: true},
+				{Type: BSON, SkipLines: 1},
+			} {
+				namespace := &commonOpts.Namespace{
+					DB:         testDB,
+					Collection: testCollection,
+				}
+				mongoImport := MongoImport{
+					ToolOptions: &commonOpts.ToolOptions{
+						Namespace: namespace,
+					},
+					InputOptions:  inputOptions,
+					IngestOptions: &options.IngestOptions{},
+				}
+				So(mongoImport.ValidateSettings(), ShouldNotBeNil)
+			}
+			namespace := &commonOpts.Namespace{
+				DB:         testDB,
+				Collection: testCollection,
+			}
+			mongoImport := MongoImport{
+				ToolOptions: &commonOpts.ToolOptions{
+					Namespace: namespace,
+				},
+				InputOptions:  &options.InputOptions{Type: BSON},
+				IngestOptions: &options.IngestOptions{},
+			}
+			So(mongoImport.ValidateSettings(), ShouldBeNil)
+		})
+
+		Convey("writes should be ordered unless --ordered is false", func() {
+			for ordered, expected := range map[string]bool{
+				"": true, "true": true, "false": false, "0": false,
+			} {
+				namespace := &commonOpts.Namespace{
+					DB:         testDB,
+					Collection: testCollection,
+				}
+				mongoImport := MongoImport{
+					ToolOptions: &commonOpts.ToolOptions{
+						Namespace: namespace,
+					},
+					InputOptions: &options.InputOptions{},
+					IngestOptions: &options.IngestOptions{
+						WriteConcern: "majority",
+						Ordered:      ordered,
+					},
+				}
+				So(mongoImport.ValidateSettings(), ShouldBeNil)
+				So(mongoImport.ordered(), ShouldEqual, expected)
+			}
+		})
 	})
 }
 
@@ -293,6 +509,14 @@ func TestRemoveBlankFields(t *testing.T) {
 			expectedDocument := bson.M{"a": 3}
 			So(newDocument, ShouldResemble, expectedDocument)
 		})
+		Convey("blanks in subdocuments should be removed along with any "+
+			"subdocuments left empty", func() {
+			bsonDocument := bson.M{"a": bson.M{"b": "", "c": 1},
+				"d": bson.M{"e": ""}}
+			newDocument := removeBlankFields(bsonDocument)
+			expectedDocument := bson.M{"a": bson.M{"c": 1}}
+			So(newDocument, ShouldResemble, expectedDocument)
+		})
 
 	})
 }
@@ -449,6 +673,118 @@ func TestConstructUpsertDocument(t *testing.T) {
 	})
 }
 
+func TestConstructMergeDocument(t *testing.T) {
+	Convey("Given a BSON document, on calling constructMergeDocument", t,
+		func() {
+			Convey("each field should be set, with subdocuments merged field "+
+				"by field", func() {
+				document := bson.M{
+					"a": 1,
+					"b": bson.M{"c": "x", "d": bson.M{"e": 2}},
+					"f": bson.M{},
+					"g": []interface{}{bson.M{"h": 3}},
+				}
+				So(constructMergeDocument(document), ShouldResemble, bson.M{
+					"$set": bson.M{
+						"a":     1,
+						"b.c":   "x",
+						"b.d.e": 2,
+						"f":     bson.M{},
+						"g":     []interface{}{bson.M{"h": 3}},
+					},
+				})
+			})
+			Convey("the _id should only be set on insert", func() {
+				document := bson.M{"_id": bson.M{"x": 1}, "a": 1}
+				So(constructMergeDocument(document), ShouldResemble, bson.M{
+					"$set":         bson.M{"a": 1},
+					"$setOnInsert": bson.M{"_id": bson.M{"x": 1}},
+				})
+			})
+		})
+}
+
+func TestNewPendingDocument(t *testing.T) {
+	Convey("Given a BSON document, on calling newPendingDocument", t, func() {
+		document := bson.M{"_id": 1, "a": 2}
+		Convey("insert mode should insert the document", func() {
+			pending, err := newPendingDocument(InsertMode, nil, document)
+			So(err, ShouldBeNil)
+			So(pending.op, ShouldEqual, insertOp)
+			So(pending.body(), ShouldResemble, document)
+		})
+		Convey("upsert mode should replace the document matching the upsert "+
+			"fields", func() {
+			pending, err := newPendingDocument(UpsertMode, []string{"a"},
+				document)
+			So(err, ShouldBeNil)
+			So(pending.op, ShouldEqual, upsertOp)
+			So(pending.selector, ShouldResemble, bson.M{"a": 2})
+			So(pending.body(), ShouldResemble, document)
+		})
+		Convey("merge mode should set the fields of the document matching "+
+			"the upsert fields", func() {
+			pending, err := newPendingDocument(MergeMode, []string{"_id"},
+				document)
+			So(err, ShouldBeNil)
+			So(pending.op, ShouldEqual, mergeOp)
+			So(pending.selector, ShouldResemble, bson.M{"_id": 1})
+			So(pending.body(), ShouldResemble, bson.M{
+				"$set":         bson.M{"a": 2},
+				"$setOnInsert": bson.M{"_id": 1},
+			})
+		})
+		Convey("delete mode should send the upsert fields as the query",
+			func() {
+				pending, err := newPendingDocument(DeleteMode, []string{"a"},
+					document)
+				So(err, ShouldBeNil)
+				So(pending.op, ShouldEqual, deleteOp)
+				So(pending.body(), ShouldResemble, bson.M{"a": 2})
+			})
+		Convey("documents without upsert fields should be inserted, except "+
+			"in delete mode", func() {
+			pending, err := newPendingDocument(MergeMode, []string{"b"},
+				document)
+			So(err, ShouldBeNil)
+			So(pending.op, ShouldEqual, insertOp)
+			_, err = newPendingDocument(DeleteMode, []string{"b"}, document)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestNumInsertionWorkers(t *testing.T) {
+	Convey("Given a mongoimport instance, on calling numInsertionWorkers", t,
+		func() {
+			Convey("a single worker should be used if none is specified",
+				func() {
+					mongoImport := MongoImport{
+						IngestOptions: &options.IngestOptions{},
+					}
+					So(mongoImport.numInsertionWorkers(), ShouldEqual, 1)
+				})
+			Convey("the specified number of workers should be used", func() {
+				mongoImport := MongoImport{
+					IngestOptions: &options.IngestOptions{
+						NumInsertionWorkers: 4,
+					},
+				}
+				So(mongoImport.numInsertionWorkers(), ShouldEqual, 4)
+			})
+			Convey("a single worker should be used if --maintainInsertionOrder "+
+				"is specified", func() {
+				mongoImport := MongoImport{
+					IngestOptions: &options.IngestOptions{
+						NumInsertionWorkers:    4,
+						MaintainInsertionOrder: true,
+					},
+				}
+				So(mongoImport.numInsertionWorkers(), ShouldEqual, 1)
+			})
+		})
+}
+
 func TestImportDocuments(t *testing.T) {
 	Convey("Given a mongoimport instance with which to import documents, on "+
 		"calling importDocuments", t, func() {
@@ -647,6 +983,31 @@ func TestImportDocuments(t *testing.T) {
 			}
 			So(checkOnlyHasDocuments(expectedDocuments), ShouldBeNil)
 		})
+		Convey("no error should be thrown for CSV import on test data with "+
+			"several insertion workers and all documents should be imported",
+			func() {
+				toolOptions := getBasicToolOptions()
+				inputOptions := &options.InputOptions{
+					Type:   CSV,
+					File:   "testdata/test_duplicate.csv",
+					Fields: "_id,b,c",
+				}
+				ingestOptions := &options.IngestOptions{
+					NumInsertionWorkers: 3,
+					BatchSize:           1,
+				}
+				sessionProvider, err := db.InitSessionProvider(toolOptions)
+				So(err, ShouldBeNil)
+				mongoImport := MongoImport{
+					ToolOptions:     toolOptions,
+					InputOptions:    inputOptions,
+					IngestOptions:   ingestOptions,
+					SessionProvider: sessionProvider,
+				}
+				numImported, err := mongoImport.ImportDocuments()
+				So(numImported, ShouldEqual, 4)
+				So(err, ShouldBeNil)
+			})
 		Convey("no error should be thrown for CSV import on test data with "+
 			"--headerLine", func() {
 			toolOptions := getBasicToolOptions()
@@ -783,31 +1144,31 @@ func TestImportDocuments(t *testing.T) {
 			}
 			So(checkOnlyHasDocuments(expectedDocuments), ShouldBeNil)
 		})
-		Convey("an error should be thrown for invalid CSV import on test data",
-			func() {
-				inputOptions := &options.InputOptions{
-					Type:   CSV,
-					File:   "testdata/test_bad.csv",
-					Fields: "_id,b,c",
-				}
-				toolOptions := getBasicToolOptions()
-				ingestOptions := &options.IngestOptions{}
-				sessionProvider, err := db.InitSessionProvider(toolOptions)
-				So(err, ShouldBeNil)
-				mongoImport := MongoImport{
-					ToolOptions:     toolOptions,
-					InputOptions:    inputOptions,
-					IngestOptions:   ingestOptions,
-					SessionProvider: sessionProvider,
-				}
-				numImported, err := mongoImport.ImportDocuments()
-				So(numImported, ShouldEqual, 1)
-				So(err, ShouldNotBeNil)
-				expectedDocuments := []bson.M{
-					bson.M{"_id": 1, "b": 2, "c": 3},
-				}
-				So(checkOnlyHasDocuments(expectedDocuments), ShouldBeNil)
-			})
+		Convey("an error should be thrown for invalid CSV import on test data "+
+			"with --stopOnError", func() {
+			inputOptions := &options.InputOptions{
+				Type:   CSV,
+				File:   "testdata/test_bad.csv",
+				Fields: "_id,b,c",
+			}
+			toolOptions := getBasicToolOptions()
+			ingestOptions := &options.IngestOptions{StopOnError: true}
+			sessionProvider, err := db.InitSessionProvider(toolOptions)
+			So(err, ShouldBeNil)
+			mongoImport := MongoImport{
+				ToolOptions:     toolOptions,
+				InputOptions:    inputOptions,
+				IngestOptions:   ingestOptions,
+				SessionProvider: sessionProvider,
+			}
+			numImported, err := mongoImport.ImportDocuments()
+			So(numImported, ShouldEqual, 1)
+			So(err, ShouldNotBeNil)
+			expectedDocuments := []bson.M{
+				bson.M{"_id": 1, "b": 2, "c": 3},
+			}
+			So(checkOnlyHasDocuments(expectedDocuments), ShouldBeNil)
+		})
 		Reset(func() {
 			getCollection().DropCollection()
 		})
@@ -10,8 +10,8 @@ type InputOptions struct {
 	// JSONArray if set will import the documents an array of JSON doccuments
 	JSONArray bool `long:"jsonArray" description:"output to a JSON array rather than one object per line"`
 
-	// Specifies the file type to import. The default format is JSON, but it’s possible to import CSV and TSV files.
-	Type string `long:"type" default:"json" description:"type of file to import (JSON, CSV, TSV only)"`
+	// Specifies the file type to import. The default format is JSON, but it’s possible to import CSV, TSV and BSON files.
+	Type string `long:"type" default:"json" description:"type of file to import (JSON, CSV, TSV or BSON)"`
 
 	// Specifies the location and name of a file containing the data to import.
 	// If you do not specify a file, mongoimport reads data from standard input (e.g. “stdin”).
@@ -20,6 +20,53 @@ type InputOptions struct {
 	// If using --type csv or --type tsv, uses the first line as field names.
 	// Otherwise, mongoimport will import the first line as a distinct document.
 	HeaderLine bool `long:"headerline" description:"first line in input file is a header (CSV and TSV only)"`
+
+	// If using --type csv or --type tsv, each field name (from --fields,
+	// --fieldFile or --headerline) declares the type of its column, e.g.
+	// "name.string()" or "created.date(2006-01-02)".
+	ColumnsHaveTypes bool `long:"columnsHaveTypes" description:"field names declare their types, e.g. -f name.string(),age.int32() (CSV and TSV only)"`
+
+	// If using --type csv, sets the character separating the fields of a
+	// record, e.g. ";" for many European exports. "\t" denotes a tab.
+	Delimiter string `long:"delimiter" description:"character separating fields, e.g. ';' or '|'; defaults to ',' (CSV only)"`
+
+	// If using --type csv, sets the character enclosing fields that contain
+	// delimiters, quotes or line breaks.
+	Quote string `long:"quote" description:"character enclosing fields; defaults to '\"' (CSV only)"`
+
+	// If using --type csv, sets the character that makes the character
+	// following it literal. By default this is the quote character, so that
+	// a quote is escaped by doubling it within a quoted field.
+	Escape string `long:"escape" description:"character escaping the character after it, e.g. '\\'; defaults to the quote character, escaped by doubling it (CSV only)"`
+
+	// If using --type csv, lines starting with this character are ignored.
+	Comment string `long:"comment" description:"character starting lines to ignore, e.g. '#' (CSV only)"`
+
+	// If using --type csv, ignores white space at the start of each field.
+	TrimLeadingSpace bool `long:"trimLeadingSpace" description:"ignore white space at the start of fields (CSV only)"`
+
+	// If using --type csv, tolerates quotes within unquoted fields and quotes
+	// that are not escaped within quoted fields.
+	LazyQuotes bool `long:"lazyQuotes" description:"allow quotes in unquoted fields and unescaped quotes in quoted fields (CSV only)"`
+
+	// If using --type csv or --type tsv, skips this many lines at the start
+	// of the input - before the header line, if any.
+	SkipLines int `long:"skipLines" description:"number of lines to skip at the start of the input, before any header line (CSV and TSV only)"`
+
+	// Specifies how the input is compressed. By default, gzip, bzip2 and zip
+	// compressed input is detected from its first bytes or the file extension
+	// and decompressed on the fly.
+	InputCompression string `long:"inputCompression" default:"auto" description:"compression of the input (auto, none, gzip, bzip2 or zip)"`
+
+	// Specifies the character encoding of the input, which is converted to
+	// UTF-8 before it is parsed. By default, UTF-8 and UTF-16 input starting
+	// with a byte order mark is detected, and all other input is taken to be
+	// UTF-8.
+	InputEncoding string `long:"inputEncoding" default:"auto" description:"character encoding of the input (auto, utf-8, utf-16le, utf-16be, latin1 or windows-1252)"`
+
+	// Rejects the records that are not valid UTF-8 - once converted from
+	// --inputEncoding - instead of importing them as they are.
+	StrictEncoding bool `long:"strictEncoding" description:"reject records that are not valid in the input encoding instead of importing them"`
 }
 
 func (self *InputOptions) Name() string {
@@ -41,6 +88,25 @@ type IngestOptions struct {
 	// mongoimport will upsert on the basis of the _id field.
 	Upsert bool `long:"upsert" description:"insert or update objects that already exist"`
 
+	// Specifies how imported documents are written: "insert" inserts them,
+	// "upsert" replaces the existing documents they match, "merge" sets their
+	// fields on the existing documents they match - keeping all other fields -
+	// and "delete" removes the existing documents they match. Documents are
+	// matched on --upsertFields. Defaults to "insert", or "upsert" with
+	// --upsert.
+	Mode string `long:"mode" description:"how documents are written: insert, upsert (replace matching documents), merge (set the imported fields on matching documents) or delete (remove matching documents)"`
+
+	// Specifies what to do with documents that have field names the server
+	// refuses - those containing '.' or null characters, or starting with
+	// '$': "reject" rejects the documents, "replace" replaces the illegal
+	// characters with --keyReplacement and "escape" percent-encodes them (as
+	// well as '%' in all field names, so that the escaping can be undone).
+	IllegalKeys string `long:"illegalKeys" description:"what to do with field names containing '.' or null characters or starting with '$': reject (the document), replace or escape"`
+
+	// Sets the string that replaces illegal characters of field names with
+	// --illegalKeys replace.
+	KeyReplacement string `long:"keyReplacement" default:"_" description:"string that replaces illegal characters of field names with --illegalKeys replace"`
+
 	// Specifies a list of fields for the query portion of the upsert.
 	// Use this option if the _id fields in the existing documents don’t match
 	// the field in the document, but another field or field combination can
@@ -50,6 +116,84 @@ type IngestOptions struct {
 	// Forces mongoimport to halt the import operation at the first error
 	// rather than continuing the operation despite errors.
 	StopOnError bool `long:"stopOnError" description:"insert or update objects that already exist"`
+
+	// Sets the maximum number of documents sent to the server in a single
+	// write operation. The server accepts at most 1000 per operation.
+	BatchSize int `long:"batchSize" default:"1000" description:"maximum number of documents to send to the server in a single write (at most 1000)"`
+
+	// Sets the maximum combined BSON size of the documents sent to the server
+	// in a single write operation. This can not exceed the 16MB message limit.
+	BatchBytes int `long:"batchBytes" default:"16777216" description:"maximum size in bytes of the documents sent to the server in a single write (at most 16MB)"`
+
+	// Sets the write concern of the import: the number of servers that must
+	// acknowledge each write, a write mode such as "majority" or a JSON
+	// document with "w", "j" and "wtimeout" fields. Defaults to the write
+	// concern of the server.
+	WriteConcern string `long:"writeConcern" description:"write concern for the import, e.g. 1, majority or '{\"w\": \"majority\", \"j\": true, \"wtimeout\": 5000}'"`
+
+	// Specifies whether the server stops writing a batch at the first document
+	// that fails (true, the default) or carries on with the rest of the batch
+	// in any order (false), which is faster.
+	Ordered string `long:"ordered" default:"true" optional:"yes" optional-value:"true" description:"stop writing a batch at the first document that fails (true or false)"`
+
+	// Limits the number of documents written to the server per second, across
+	// all insertion workers and files.
+	MaxDocsPerSecond int `long:"maxDocsPerSecond" description:"maximum number of documents to write per second (0 for no limit)"`
+
+	// Limits the number of bytes of BSON written to the server per second,
+	// across all insertion workers and files.
+	MaxBytesPerSecond int `long:"maxBytesPerSecond" description:"maximum number of bytes of documents to write per second (0 for no limit)"`
+
+	// Backs off whenever the average latency of writes reported by the
+	// server's serverStatus goes over the given number of milliseconds,
+	// slowing the import down until writes are fast enough again.
+	MaxWriteLatency int `long:"maxWriteLatency" description:"milliseconds the average write latency reported by the server may reach before the import backs off (0 to never back off; needs MongoDB 3.2 or later)"`
+
+	// Sets the number of goroutines that concurrently write documents to the
+	// server, each over its own connection.
+	NumInsertionWorkers int `long:"numInsertionWorkers" default:"1" description:"number of insert operations to run concurrently"`
+
+	// Forces documents to be written in the order they appear in the input.
+	// This limits the import to a single insertion worker.
+	MaintainInsertionOrder bool `long:"maintainInsertionOrder" description:"insert documents in the order of their appearance in the input source"`
+
+	// Sets the number of input files imported concurrently when several files
+	// are given.
+	NumParallelFiles int `long:"numParallelFiles" default:"1" description:"number of input files to import concurrently"`
+
+	// Specifies a file to which every input record that fails to be parsed
+	// or written is copied verbatim. The line number, error class and server
+	// error code of each such record are written to the same file name with
+	// an ".errors" suffix, one JSON document per line.
+	RejectFile string `long:"rejectFile" description:"file to write records that fail to import to; errors are written to <rejectFile>.errors"`
+
+	// Specifies a file in which the position of the last input record that
+	// has been written (or rejected) - along with all records before it - is
+	// periodically recorded, so that an interrupted import can be resumed.
+	CheckpointFile string `long:"checkpointFile" description:"file to periodically record the progress of the import in (requires --file)"`
+
+	// Sets the minimum number of seconds between two checkpoint writes.
+	CheckpointInterval int `long:"checkpointInterval" default:"5" description:"minimum number of seconds between checkpoints"`
+
+	// Resumes an interrupted import from the position recorded in the
+	// checkpoint file, skipping all input before it.
+	Resume bool `long:"resume" description:"resume the import from the position recorded in --checkpointFile"`
+
+	// Specifies a JSON file of rules - renaming, dropping, setting, casting,
+	// splitting and nesting fields - applied in order to every document
+	// before it is written.
+	TransformFile string `long:"transformFile" description:"JSON file of rules to rename, drop, set, cast, split or nest fields of every document"`
+
+	// Specifies a JSON Schema file every document is checked against before
+	// it is written. Documents that do not match it are treated like those
+	// that fail to parse.
+	SchemaFile string `long:"schema" description:"JSON Schema file every document must match before it is written"`
+
+	// Runs the input through the whole import pipeline without connecting to
+	// the server, reporting the documents that would be imported, the records
+	// that fail to parse, the documents over the BSON size limit and the types
+	// of values found for each field.
+	DryRun bool `long:"dryRun" description:"parse and validate the input without connecting to the server, and report on the documents found"`
 }
 
 func (self *IngestOptions) Name() string {
@@ -0,0 +1,56 @@
+package mongoimport
+
+import (
+	"github.com/shelman/mongo-tools-proto/common/util"
+	"os"
+)
+
+// countedFile is an input file - or stdin - whose bytes are counted by a
+// progress reporter as they are read
+type countedFile struct {
+	*os.File
+	progress *util.ProgressReporter
+}
+
+func (file countedFile) Read(p []byte) (int, error) {
+	n, err := file.File.Read(p)
+	file.progress.AddBytes(int64(n))
+	return n, err
+}
+
+// ReadAt is used to read zip archives
+func (file countedFile) ReadAt(p []byte, offset int64) (int, error) {
+	n, err := file.File.ReadAt(p, offset)
+	file.progress.AddBytes(int64(n))
+	return n, err
+}
+
+// Seek counts the bytes skipped - when resuming an import - as read
+func (file countedFile) Seek(offset int64, whence int) (int64, error) {
+	from, err := file.File.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		return 0, err
+	}
+	to, err := file.File.Seek(offset, whence)
+	if err == nil {
+		file.progress.AddBytes(to - from)
+	}
+	return to, err
+}
+
+// inputSize returns the total size of the given input files, or 0 if it is not
+// known because the input is read from stdin or a file is not a regular file
+func inputSize(files []string) int64 {
+	total := int64(0)
+	for _, file := range files {
+		if file == "" {
+			return 0
+		}
+		info, err := os.Stat(file)
+		if err != nil || !info.Mode().IsRegular() {
+			return 0
+		}
+		total += info.Size()
+	}
+	return total
+}
@@ -0,0 +1,76 @@
+package mongoimport
+
+import (
+	"bytes"
+	"encoding/json"
+	commonOpts "github.com/shelman/mongo-tools-proto/common/options"
+	"github.com/shelman/mongo-tools-proto/common/util"
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestInputSize(t *testing.T) {
+	Convey("Given a list of input files, on calling inputSize", t, func() {
+		Convey("the sizes of regular files should be added up", func() {
+			info, err := os.Stat("testdata/test.csv")
+			So(err, ShouldBeNil)
+			So(inputSize([]string{"testdata/test.csv", "testdata/test.csv"}),
+				ShouldEqual, 2*info.Size())
+		})
+		Convey("the size of stdin or a directory should be unknown", func() {
+			So(inputSize([]string{"testdata/test.csv", ""}), ShouldEqual, 0)
+			So(inputSize([]string{"testdata"}), ShouldEqual, 0)
+		})
+	})
+}
+
+func TestProgressImport(t *testing.T) {
+	Convey("Given a mongoimport instance reporting progress", t, func() {
+		mongoImport := MongoImport{
+			ToolOptions: getBasicToolOptions(),
+			InputOptions: &options.InputOptions{
+				Type:   CSV,
+				File:   "testdata/test.csv",
+				Fields: "a,b,c",
+			},
+			IngestOptions: &options.IngestOptions{DryRun: true},
+			ProgressOptions: &commonOpts.Progress{
+				ProgressInterval: 1,
+				ProgressFormat:   util.ProgressJSON,
+			},
+		}
+		Convey("every byte of input and every document should be counted",
+			func() {
+				out := &bytes.Buffer{}
+				progress, err := util.NewProgressReporter("import", out,
+					time.Hour, util.ProgressJSON)
+				So(err, ShouldBeNil)
+				mongoImport.progress = progress
+				mongoImport.dryRun = newDryRunReport()
+				in, err := mongoImport.getInputReader()
+				So(err, ShouldBeNil)
+				defer in.Close()
+				importInput, err := mongoImport.getImportInput(in)
+				So(err, ShouldBeNil)
+				numImported, err := mongoImport.importDocuments(importInput)
+				So(err, ShouldBeNil)
+				So(numImported, ShouldEqual, 3)
+
+				progress.Report(true)
+				report := util.ProgressReport{}
+				So(json.Unmarshal(out.Bytes(), &report), ShouldBeNil)
+				info, err := os.Stat("testdata/test.csv")
+				So(err, ShouldBeNil)
+				So(report.Documents, ShouldEqual, 3)
+				So(report.Bytes, ShouldEqual, info.Size())
+			})
+		Convey("bad progress options should be rejected", func() {
+			mongoImport.ProgressOptions.ProgressFormat = "xml"
+			_, err := mongoImport.ImportDocuments()
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
@@ -0,0 +1,208 @@
+package mongoimport
+
+import (
+	"fmt"
+	"labix.org/v2/mgo"
+	"sync"
+	"time"
+)
+
+const (
+	// minRateScale is the smallest fraction of the rate limits that adaptive
+	// back off goes down to
+	minRateScale = 1.0 / 64
+	// rateScaleStep is the fraction of the rate limits recovered after each
+	// write that is fast enough
+	rateScaleStep = 0.05
+)
+
+// rateLimiter paces the batches written by all insertion workers so that the
+// import stays within --maxDocsPerSecond and --maxBytesPerSecond. With
+// --maxWriteLatency, it also backs off whenever the server reports that its
+// writes took longer than that on average since the last batch: it halves the
+// rate the import is limited to - or, without rate limits, idles between
+// writes for longer - and then slowly recovers as long as writes are fast
+// enough. A nil rateLimiter never waits.
+//
+// Write latency is read from the opLatencies section of serverStatus (MongoDB
+// 3.2 or later), which counts the time the server spent on all writes - those
+// of other clients too - leaving out the network.
+type rateLimiter struct {
+	maxDocs    float64
+	maxBytes   float64
+	maxLatency time.Duration
+
+	mutex sync.Mutex
+	// scale is the fraction of the limits currently in effect
+	scale float64
+	// next is the earliest time at which the next batch may be written
+	next time.Time
+	// latency and ops are the totals of the write latencies (in
+	// microseconds) and writes last reported by the server
+	latency int64
+	ops     int64
+
+	// now and sleep are replaced by tests
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// newRateLimiter returns a rateLimiter for the given limits, or nil if none of
+// them is set
+func newRateLimiter(maxDocs, maxBytes int,
+	maxLatency time.Duration) *rateLimiter {
+	if maxDocs <= 0 && maxBytes <= 0 && maxLatency <= 0 {
+		return nil
+	}
+	return &rateLimiter{
+		maxDocs:    float64(maxDocs),
+		maxBytes:   float64(maxBytes),
+		maxLatency: maxLatency,
+		scale:      1,
+		now:        time.Now,
+		sleep:      time.Sleep,
+	}
+}
+
+// batchLimits caps the given batch limits at a second's worth of the rate
+// limits, so that writes are spread out rather than sent in bursts
+func (limiter *rateLimiter) batchLimits(maxDocs, maxBytes int) (int, int) {
+	if limiter == nil {
+		return maxDocs, maxBytes
+	}
+	capLimit := func(limit int, rate float64) int {
+		if rate > 0 && (limit <= 0 || float64(limit) > rate) {
+			return int(rate)
+		}
+		return limit
+	}
+	return capLimit(maxDocs, limiter.maxDocs),
+		capLimit(maxBytes, limiter.maxBytes)
+}
+
+// wait blocks until a batch of the given number of documents and bytes can be
+// written without exceeding the rate limits
+func (limiter *rateLimiter) wait(docs, bytes int) {
+	if limiter == nil || docs == 0 {
+		return
+	}
+	limiter.mutex.Lock()
+	now := limiter.now()
+	start := limiter.next
+	if start.Before(now) {
+		start = now
+	}
+	// the time the batch takes up at the current rate
+	seconds := 0.0
+	if limiter.maxDocs > 0 {
+		seconds = float64(docs) / limiter.maxDocs
+	}
+	if limiter.maxBytes > 0 && float64(bytes)/limiter.maxBytes > seconds {
+		seconds = float64(bytes) / limiter.maxBytes
+	}
+	limiter.next = start.Add(time.Duration(seconds / limiter.scale *
+		float64(time.Second)))
+	limiter.mutex.Unlock()
+	limiter.sleep(start.Sub(now))
+}
+
+// serverStatusLatencies is the part of the reply to serverStatus that holds
+// the write latencies
+type serverStatusLatencies struct {
+	OpLatencies *struct {
+		Writes struct {
+			Latency int64 `bson:"latency"`
+			Ops     int64 `bson:"ops"`
+		} `bson:"writes"`
+	} `bson:"opLatencies"`
+}
+
+// readWriteLatencies returns the totals of the write latencies, in
+// microseconds, and of the writes reported by the server's serverStatus
+func readWriteLatencies(session *mgo.Session) (int64, int64, error) {
+	var status serverStatusLatencies
+	err := session.DB("admin").Run("serverStatus", &status)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error reading write latencies from "+
+			"serverStatus: %v", err)
+	}
+	if status.OpLatencies == nil {
+		return 0, 0, fmt.Errorf("--maxWriteLatency needs the write " +
+			"latencies reported by serverStatus in MongoDB 3.2 or later")
+	}
+	return status.OpLatencies.Writes.Latency, status.OpLatencies.Writes.Ops,
+		nil
+}
+
+// start reads the write latencies the server reports before any batch is
+// written, which also checks that it reports them at all
+func (limiter *rateLimiter) start(session *mgo.Session) error {
+	if limiter == nil || limiter.maxLatency <= 0 {
+		return nil
+	}
+	latency, ops, err := readWriteLatencies(session)
+	if err != nil {
+		return err
+	}
+	limiter.mutex.Lock()
+	limiter.latency, limiter.ops = latency, ops
+	limiter.mutex.Unlock()
+	return nil
+}
+
+// wrote adjusts the rate to the average latency of the writes the server
+// reports since the last batch was written, given how long the last batch
+// took to write
+func (limiter *rateLimiter) wrote(session *mgo.Session,
+	elapsed time.Duration) error {
+	if limiter == nil || limiter.maxLatency <= 0 {
+		return nil
+	}
+	latency, ops, err := readWriteLatencies(session)
+	if err != nil {
+		return err
+	}
+	limiter.update(latency, ops, elapsed)
+	return nil
+}
+
+// update adjusts the rate to the given totals of the write latencies, in
+// microseconds, and of the writes reported by the server
+func (limiter *rateLimiter) update(latency, ops int64, elapsed time.Duration) {
+	limiter.mutex.Lock()
+	defer limiter.mutex.Unlock()
+	if ops > limiter.ops {
+		limiter.adjust(time.Duration((latency-limiter.latency)/
+			(ops-limiter.ops))*time.Microsecond, elapsed)
+	}
+	// without new writes there is nothing to go by - and fewer writes than
+	// before mean the server restarted
+	limiter.latency, limiter.ops = latency, ops
+}
+
+// adjust halves the rate if the given average write latency is over the
+// threshold, and otherwise recovers some of it; elapsed is how long the last
+// batch took to write. The caller must hold the mutex.
+func (limiter *rateLimiter) adjust(latency, elapsed time.Duration) {
+	if latency > limiter.maxLatency {
+		limiter.scale /= 2
+		if limiter.scale < minRateScale {
+			limiter.scale = minRateScale
+		}
+	} else {
+		limiter.scale += rateScaleStep
+		if limiter.scale > 1 {
+			limiter.scale = 1
+		}
+	}
+	if limiter.maxDocs > 0 || limiter.maxBytes > 0 || limiter.scale == 1 {
+		return
+	}
+	// without rate limits, writes take up only the scale's share of the time
+	idle := time.Duration(float64(elapsed) * (1/limiter.scale - 1))
+	now := limiter.now()
+	if limiter.next.Before(now) {
+		limiter.next = now
+	}
+	limiter.next = limiter.next.Add(idle)
+}
@@ -0,0 +1,128 @@
+package mongoimport
+
+import (
+	commonOpts "github.com/shelman/mongo-tools-proto/common/options"
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"testing"
+	"time"
+)
+
+// newTestRateLimiter returns a rateLimiter on a fake clock that only moves on
+// when the limiter sleeps, along with a function returning the time slept
+func newTestRateLimiter(maxDocs, maxBytes int,
+	maxLatency time.Duration) (*rateLimiter, func() time.Duration) {
+	limiter := newRateLimiter(maxDocs, maxBytes, maxLatency)
+	start := time.Date(2014, 7, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	limiter.now = func() time.Time { return now }
+	limiter.sleep = func(duration time.Duration) {
+		if duration > 0 {
+			now = now.Add(duration)
+		}
+	}
+	return limiter, func() time.Duration { return now.Sub(start) }
+}
+
+func TestRateLimiter(t *testing.T) {
+	Convey("With a rate limiter", t, func() {
+		Convey("no limits should mean no limiter", func() {
+			var limiter *rateLimiter
+			So(newRateLimiter(0, 0, 0), ShouldEqual, limiter)
+			limiter.wait(1000, 1000)
+			So(limiter.wrote(nil, time.Hour), ShouldBeNil)
+			maxDocs, maxBytes := limiter.batchLimits(100, 200)
+			So(maxDocs, ShouldEqual, 100)
+			So(maxBytes, ShouldEqual, 200)
+		})
+		Convey("batches should be capped at a second's worth of writes",
+			func() {
+				limiter := newRateLimiter(50, 0, 0)
+				maxDocs, maxBytes := limiter.batchLimits(1000, 0)
+				So(maxDocs, ShouldEqual, 50)
+				So(maxBytes, ShouldEqual, 0)
+				limiter = newRateLimiter(0, 4096, 0)
+				maxDocs, maxBytes = limiter.batchLimits(0, 1<<20)
+				So(maxDocs, ShouldEqual, 0)
+				So(maxBytes, ShouldEqual, 4096)
+			})
+		Convey("writes should be paced by the documents limit", func() {
+			limiter, elapsed := newTestRateLimiter(100, 0, 0)
+			for i := 0; i < 5; i++ {
+				limiter.wait(50, 1<<20)
+			}
+			// the first batch is written right away
+			So(elapsed(), ShouldEqual, 2*time.Second)
+		})
+		Convey("writes should be paced by the stricter of both limits",
+			func() {
+				limiter, elapsed := newTestRateLimiter(1000, 1000, 0)
+				limiter.wait(10, 2000)
+				limiter.wait(10, 2000)
+				So(elapsed(), ShouldEqual, 2*time.Second)
+			})
+		Convey("slow writes should halve the rate, and fast writes should "+
+			"slowly recover it", func() {
+			limiter, elapsed := newTestRateLimiter(100, 0, time.Second)
+			limiter.adjust(2*time.Second, 0)
+			limiter.adjust(2*time.Second, 0)
+			So(limiter.scale, ShouldEqual, 0.25)
+			limiter.wait(100, 0)
+			limiter.wait(100, 0)
+			So(elapsed(), ShouldEqual, 4*time.Second)
+			for i := 0; i < 100; i++ {
+				limiter.adjust(time.Millisecond, 0)
+			}
+			So(limiter.scale, ShouldEqual, 1)
+			for i := 0; i < 100; i++ {
+				limiter.adjust(time.Hour, 0)
+			}
+			So(limiter.scale, ShouldEqual, minRateScale)
+		})
+		Convey("the rate should follow the average latency of the writes "+
+			"since the last batch", func() {
+			limiter, _ := newTestRateLimiter(100, 0, time.Millisecond)
+			limiter.latency, limiter.ops = 1000000, 10
+			// 2ms on average
+			limiter.update(1020000, 20, 0)
+			So(limiter.scale, ShouldEqual, 0.5)
+			// no writes
+			limiter.update(1020000, 20, 0)
+			So(limiter.scale, ShouldEqual, 0.5)
+			// 0.5ms on average
+			limiter.update(1025000, 30, 0)
+			So(limiter.scale, ShouldEqual, 0.55)
+		})
+		Convey("without rate limits, slow writes should add idle time "+
+			"between writes", func() {
+			limiter, elapsed := newTestRateLimiter(0, 0, time.Second)
+			limiter.wait(10, 10)
+			So(elapsed(), ShouldEqual, 0)
+			limiter.adjust(2*time.Second, 3*time.Second)
+			limiter.wait(10, 10)
+			So(elapsed(), ShouldEqual, 3*time.Second)
+		})
+	})
+}
+
+func TestRateLimitSettings(t *testing.T) {
+	Convey("Negative rate limits should be rejected", t, func() {
+		for _, ingestOptions := range []*options.IngestOptions{
+			{MaxDocsPerSecond: -1},
+			{MaxBytesPerSecond: -1},
+			{MaxWriteLatency: -1},
+		} {
+			mongoImport := MongoImport{
+				ToolOptions: &commonOpts.ToolOptions{
+					Namespace: &commonOpts.Namespace{
+						DB:         testDB,
+						Collection: testCollection,
+					},
+				},
+				InputOptions:  &options.InputOptions{},
+				IngestOptions: ingestOptions,
+			}
+			So(mongoImport.ValidateSettings(), ShouldNotBeNil)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package mongoimport
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+)
+
+// classes of errors that cause an input record to be rejected
+const (
+	// rejectParse means the record could not be converted to a document
+	rejectParse = "parse"
+	// rejectValidate means the document does not match the --schema
+	rejectValidate = "validate"
+	// rejectEncode means the document could not be encoded as BSON within
+	// the server's size limit
+	rejectEncode = "encode"
+	// rejectWrite means the server refused to write the document
+	rejectWrite = "write"
+)
+
+// rejectErrorsSuffix is appended to the reject file's name to get the name of
+// the file describing why each record was rejected
+const rejectErrorsSuffix = ".errors"
+
+// rejectEntry describes a single rejected record in the errors file
+type rejectEntry struct {
+	// File is the input file the record was read from ("" for stdin)
+	File string `json:"file,omitempty"`
+	// Record is the position of the record among all documents in the input
+	Record int64 `json:"record"`
+	// Line is the line of the input source on which the record starts
+	Line int64 `json:"line"`
+	// Class is one of the reject* error classes
+	Class string `json:"class"`
+	// Code is the server's error code for write errors
+	Code int `json:"code,omitempty"`
+	// Error is the error message
+	Error string `json:"error"`
+}
+
+// rejectWriter collects the input records that failed to be imported. Each
+// record is written verbatim to the reject file - so that it can be fixed up
+// and re-imported on its own - while a JSON line describing the failure is
+// written to a sidecar file. A rejectWriter is safe for concurrent use by the
+// insertion workers.
+type rejectWriter struct {
+	mutex   sync.Mutex
+	records *os.File
+	errors  *os.File
+	encoder *json.Encoder
+	// wroteHeader is set once a header line has been written
+	wroteHeader bool
+	// binary is set for BSON input, whose records are written back to back
+	// without newlines
+	binary bool
+}
+
+// newRejectWriter creates the reject file at the given path along with its
+// errors file, truncating any existing files
+func newRejectWriter(path string) (*rejectWriter, error) {
+	records, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	errors, err := os.Create(path + rejectErrorsSuffix)
+	if err != nil {
+		records.Close()
+		return nil, err
+	}
+	return &rejectWriter{
+		records: records,
+		errors:  errors,
+		encoder: json.NewEncoder(errors),
+	}, nil
+}
+
+// WriteHeader writes the header line of a CSV or TSV input to the reject file.
+// When several files are imported, only the first header is written.
+func (rejects *rejectWriter) WriteHeader(header InputRecord) error {
+	rejects.mutex.Lock()
+	defer rejects.mutex.Unlock()
+	if rejects.wroteHeader {
+		return nil
+	}
+	rejects.wroteHeader = true
+	return rejects.writeRaw(header.Raw)
+}
+
+// Reject writes the given record, read from the given input file, to the
+// reject file, and the reason it failed to be imported to the errors file
+func (rejects *rejectWriter) Reject(file string, record InputRecord,
+	class string, err error) error {
+	entry := rejectEntry{
+		File:   file,
+		Record: record.Number,
+		Line:   record.Line,
+		Class:  class,
+		Error:  err.Error(),
+	}
+	if writeErr, ok := err.(*writeError); ok {
+		entry.Code = writeErr.Code
+	}
+	rejects.mutex.Lock()
+	defer rejects.mutex.Unlock()
+	if err = rejects.writeRaw(record.Raw); err != nil {
+		return err
+	}
+	return rejects.encoder.Encode(entry)
+}
+
+// writeRaw writes raw input text to the reject file, terminating it with a
+// newline if needed - unless the input is binary
+func (rejects *rejectWriter) writeRaw(raw []byte) error {
+	if _, err := rejects.records.Write(raw); err != nil {
+		return err
+	}
+	if !rejects.binary && (len(raw) == 0 || raw[len(raw)-1] != '\n') {
+		if _, err := rejects.records.Write([]byte{'\n'}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes both the reject file and the errors file
+func (rejects *rejectWriter) Close() error {
+	recordsErr := rejects.records.Close()
+	if err := rejects.errors.Close(); err != nil {
+		return err
+	}
+	return recordsErr
+}
@@ -0,0 +1,53 @@
+package mongoimport
+
+import (
+	"encoding/json"
+	"fmt"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRejectWriter(t *testing.T) {
+	Convey("Given a reject writer", t, func() {
+		dir, err := ioutil.TempDir("", "mongoimport_")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "rejects.csv")
+		rejects, err := newRejectWriter(path)
+		So(err, ShouldBeNil)
+
+		Convey("records should be written verbatim, and their errors to the "+
+			"errors file", func() {
+			So(rejects.WriteHeader(InputRecord{Line: 1,
+				Raw: []byte("a,b\n")}), ShouldBeNil)
+			So(rejects.Reject("", InputRecord{Number: 1, Line: 2,
+				Raw: []byte("1,x\n")}, rejectParse,
+				fmt.Errorf("bad value")), ShouldBeNil)
+			So(rejects.Reject("", InputRecord{Number: 3, Line: 4,
+				Raw: []byte("3,y")}, rejectWrite,
+				&writeError{Index: 0, Code: 11000, ErrMsg: "duplicate key"}),
+				ShouldBeNil)
+			So(rejects.Close(), ShouldBeNil)
+
+			records, err := ioutil.ReadFile(path)
+			So(err, ShouldBeNil)
+			So(string(records), ShouldEqual, "a,b\n1,x\n3,y\n")
+
+			errors, err := ioutil.ReadFile(path + rejectErrorsSuffix)
+			So(err, ShouldBeNil)
+			lines := strings.Split(strings.TrimSpace(string(errors)), "\n")
+			So(len(lines), ShouldEqual, 2)
+			entry := rejectEntry{}
+			So(json.Unmarshal([]byte(lines[0]), &entry), ShouldBeNil)
+			So(entry, ShouldResemble, rejectEntry{Record: 1, Line: 2,
+				Class: rejectParse, Error: "bad value"})
+			So(json.Unmarshal([]byte(lines[1]), &entry), ShouldBeNil)
+			So(entry, ShouldResemble, rejectEntry{Record: 3, Line: 4,
+				Class: rejectWrite, Code: 11000, Error: "duplicate key"})
+		})
+	})
+}
@@ -0,0 +1,513 @@
+package mongoimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/shelman/mongo-tools-proto/common/bson_ext"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// schema is a JSON Schema that every imported document is checked against
+// before it is written. It supports the following subset of JSON Schema:
+//
+//	type                        a type name or an array of type names
+//	enum                        the values allowed
+//	pattern                     a regular expression strings must match
+//	minimum, maximum            bounds on numbers, with exclusiveMinimum
+//	                            and exclusiveMaximum their strict versions -
+//	                            or, as in draft 4, true to make minimum and
+//	                            maximum strict
+//	minLength, maxLength        bounds on the number of characters of strings
+//	minItems, maxItems          bounds on the number of elements of arrays
+//	required                    the fields objects must have
+//	properties                  the schemas of fields of objects
+//	additionalProperties        false if objects may only have the fields
+//	                            listed in properties
+//	items                       the schema of all elements of arrays
+//
+// Type names are those of JSON Schema - "object", "array", "string",
+// "number", "integer", "boolean" and "null" - or BSON type names as shown by
+// --dryRun, such as "objectId", "date", "int32" or "double". As in MongoDB's
+// $jsonSchema, "bsonType" may be used in place of "type". Keywords outside of
+// this subset are ignored.
+type schema struct {
+	Types                []string
+	Enum                 []interface{}
+	Pattern              *regexp.Regexp
+	Minimum              *float64
+	Maximum              *float64
+	ExclusiveMinimum     *float64
+	ExclusiveMaximum     *float64
+	MinLength            *int
+	MaxLength            *int
+	MinItems             *int
+	MaxItems             *int
+	Required             []string
+	Properties           map[string]*schema
+	AdditionalProperties *bool
+	Items                *schema
+}
+
+// schemaSpec is the JSON form of a schema
+type schemaSpec struct {
+	Type                 schemaTypeSpec         `json:"type"`
+	BSONType             schemaTypeSpec         `json:"bsonType"`
+	Enum                 []interface{}          `json:"enum"`
+	Pattern              *string                `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	ExclusiveMinimum     json.RawMessage        `json:"exclusiveMinimum"`
+	ExclusiveMaximum     json.RawMessage        `json:"exclusiveMaximum"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	Required             []string               `json:"required"`
+	Properties           map[string]*schemaSpec `json:"properties"`
+	AdditionalProperties *bool                  `json:"additionalProperties"`
+	Items                *schemaSpec            `json:"items"`
+}
+
+// schemaTypeSpec holds the type names of a schema, which may be given as a
+// single string or as an array of strings
+type schemaTypeSpec []string
+
+func (types *schemaTypeSpec) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err == nil {
+		*types = schemaTypeSpec{name}
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return fmt.Errorf("type must be a string or an array of strings")
+	}
+	*types = names
+	return nil
+}
+
+// schemaTypeMatchers maps each type name a schema accepts to a function that
+// tells whether a value - given along with its BSON type name - is of that
+// type
+var schemaTypeMatchers = map[string]func(interface{}, string) bool{
+	"object":  bsonTypeIs("object"),
+	"array":   bsonTypeIs("array"),
+	"string":  bsonTypeIs("string"),
+	"boolean": bsonTypeIs("bool"),
+	"null":    bsonTypeIs("null"),
+	"number":  bsonTypeIs("int32", "int64", "double"),
+	"integer": func(value interface{}, typeName string) bool {
+		number, ok := schemaNumber(value)
+		return ok && number == math.Trunc(number)
+	},
+	"bool":       bsonTypeIs("bool"),
+	"int32":      bsonTypeIs("int32"),
+	"int":        bsonTypeIs("int32"),
+	"int64":      bsonTypeIs("int64"),
+	"long":       bsonTypeIs("int64"),
+	"double":     bsonTypeIs("double"),
+	"objectId":   bsonTypeIs("objectId"),
+	"date":       bsonTypeIs("date"),
+	"binData":    bsonTypeIs("binData"),
+	"regex":      bsonTypeIs("regex"),
+	"timestamp":  bsonTypeIs("timestamp"),
+	"javascript": bsonTypeIs("javascript"),
+	"symbol":     bsonTypeIs("symbol"),
+	"minKey":     bsonTypeIs("minKey"),
+	"maxKey":     bsonTypeIs("maxKey"),
+	"undefined":  bsonTypeIs("undefined"),
+}
+
+// bsonTypeIs returns a schema type matcher for values of the given BSON types
+func bsonTypeIs(typeNames ...string) func(interface{}, string) bool {
+	return func(value interface{}, typeName string) bool {
+		for _, name := range typeNames {
+			if typeName == name {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// schemaError describes why a document does not match a schema
+type schemaError struct {
+	// Path is the dotted path to the field that does not match, with array
+	// elements given by their index; it is empty for the document itself
+	Path    string
+	Message string
+}
+
+func (err *schemaError) Error() string {
+	if err.Path == "" {
+		return "document " + err.Message
+	}
+	return fmt.Sprintf("field '%v' %v", err.Path, err.Message)
+}
+
+// readSchema reads the schema in the file at the given path
+func readSchema(path string) (*schema, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	documentSchema, err := parseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading schema '%v': %v", path, err)
+	}
+	return documentSchema, nil
+}
+
+// exclusiveBound returns the strict and inclusive bounds given by an
+// exclusiveMinimum or exclusiveMaximum keyword along with its inclusive
+// counterpart. The keyword is either the strict bound itself or, as in draft 4
+// of JSON Schema, a boolean telling whether the inclusive bound is strict.
+func exclusiveBound(keyword string, value json.RawMessage,
+	inclusiveKeyword string, inclusive *float64, location string) (*float64,
+	*float64, error) {
+	if value == nil {
+		return nil, inclusive, nil
+	}
+	var strict bool
+	if err := json.Unmarshal(value, &strict); err == nil {
+		if !strict {
+			return nil, inclusive, nil
+		}
+		if inclusive == nil {
+			return nil, nil, fmt.Errorf("%v%v requires %v", keyword, location,
+				inclusiveKeyword)
+		}
+		return inclusive, nil, nil
+	}
+	var bound float64
+	if err := json.Unmarshal(value, &bound); err != nil {
+		return nil, nil, fmt.Errorf("%v%v must be a number or a boolean",
+			keyword, location)
+	}
+	return &bound, inclusive, nil
+}
+
+// parseSchema parses the given schema
+func parseSchema(data []byte) (*schema, error) {
+	spec := &schemaSpec{}
+	if err := json.Unmarshal(data, spec); err != nil {
+		return nil, err
+	}
+	return compileSchema(spec, "")
+}
+
+// compileSchema checks the given schema spec for the schema at the given path
+// and converts it to a schema
+func compileSchema(spec *schemaSpec, path string) (*schema, error) {
+	compiled := &schema{
+		Types:                append(spec.Type, spec.BSONType...),
+		Enum:                 spec.Enum,
+		MinLength:            spec.MinLength,
+		MaxLength:            spec.MaxLength,
+		MinItems:             spec.MinItems,
+		MaxItems:             spec.MaxItems,
+		Required:             spec.Required,
+		AdditionalProperties: spec.AdditionalProperties,
+	}
+	location := ""
+	if path != "" {
+		location = fmt.Sprintf(" for '%v'", path)
+	}
+	for _, typeName := range compiled.Types {
+		if schemaTypeMatchers[typeName] == nil {
+			return nil, fmt.Errorf("unknown type '%v'%v", typeName, location)
+		}
+	}
+	var err error
+	compiled.ExclusiveMinimum, compiled.Minimum, err = exclusiveBound(
+		"exclusiveMinimum", spec.ExclusiveMinimum, "minimum", spec.Minimum,
+		location)
+	if err != nil {
+		return nil, err
+	}
+	compiled.ExclusiveMaximum, compiled.Maximum, err = exclusiveBound(
+		"exclusiveMaximum", spec.ExclusiveMaximum, "maximum", spec.Maximum,
+		location)
+	if err != nil {
+		return nil, err
+	}
+	if spec.Pattern != nil {
+		pattern, err := regexp.Compile(*spec.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("bad pattern%v: %v", location, err)
+		}
+		compiled.Pattern = pattern
+	}
+	for _, bound := range []*int{spec.MinLength, spec.MaxLength,
+		spec.MinItems, spec.MaxItems} {
+		if bound != nil && *bound < 0 {
+			return nil, fmt.Errorf("length bounds%v can not be negative",
+				location)
+		}
+	}
+	if len(spec.Properties) != 0 {
+		compiled.Properties = map[string]*schema{}
+	}
+	for name, propertySpec := range spec.Properties {
+		if propertySpec == nil {
+			return nil, fmt.Errorf("schema for '%v' must be an object",
+				joinSchemaPath(path, name))
+		}
+		property, err := compileSchema(propertySpec,
+			joinSchemaPath(path, name))
+		if err != nil {
+			return nil, err
+		}
+		compiled.Properties[name] = property
+	}
+	if spec.Items != nil {
+		items, err := compileSchema(spec.Items, joinSchemaPath(path, "items"))
+		if err != nil {
+			return nil, err
+		}
+		compiled.Items = items
+	}
+	return compiled, nil
+}
+
+// joinSchemaPath appends the given field name or array index to a path
+func joinSchemaPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// validate checks that the given document matches the schema, returning a
+// *schemaError for the first mismatch found. A nil schema matches any
+// document.
+func (documentSchema *schema) validate(document bson.M) error {
+	if documentSchema == nil {
+		return nil
+	}
+	return documentSchema.validateValue(document, "")
+}
+
+// validateValue checks that the value at the given path matches the schema
+func (valueSchema *schema) validateValue(value interface{}, path string) error {
+	typeName := bsonTypeName(value)
+	if len(valueSchema.Types) != 0 {
+		matched := false
+		for _, name := range valueSchema.Types {
+			if schemaTypeMatchers[name](value, typeName) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &schemaError{path, fmt.Sprintf("must be of type %v, not %v",
+				strings.Join(valueSchema.Types, " or "), typeName)}
+		}
+	}
+
+	if valueSchema.Enum != nil {
+		matched := false
+		for _, allowed := range valueSchema.Enum {
+			if schemaValuesEqual(value, allowed) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return &schemaError{path, fmt.Sprintf("must be one of %v",
+				formatSchemaValues(valueSchema.Enum))}
+		}
+	}
+
+	if number, ok := schemaNumber(value); ok {
+		return valueSchema.validateNumber(number, path)
+	}
+	if text, ok := value.(string); ok {
+		return valueSchema.validateString(text, path)
+	}
+	if array, ok := value.([]interface{}); ok {
+		return valueSchema.validateArray(array, path)
+	}
+	if document, ok := asDocument(value); ok {
+		return valueSchema.validateDocument(document, path)
+	}
+	return nil
+}
+
+func (valueSchema *schema) validateNumber(number float64, path string) error {
+	bounds := []struct {
+		bound   *float64
+		fails   func(number, bound float64) bool
+		message string
+	}{
+		{valueSchema.Minimum, func(n, b float64) bool { return n < b },
+			"must be at least"},
+		{valueSchema.Maximum, func(n, b float64) bool { return n > b },
+			"must be at most"},
+		{valueSchema.ExclusiveMinimum, func(n, b float64) bool { return n <= b },
+			"must be greater than"},
+		{valueSchema.ExclusiveMaximum, func(n, b float64) bool { return n >= b },
+			"must be less than"},
+	}
+	for _, bound := range bounds {
+		if bound.bound != nil && bound.fails(number, *bound.bound) {
+			return &schemaError{path, fmt.Sprintf("%v %v, not %v",
+				bound.message, formatSchemaNumber(*bound.bound),
+				formatSchemaNumber(number))}
+		}
+	}
+	return nil
+}
+
+func (valueSchema *schema) validateString(text, path string) error {
+	length := utf8.RuneCountInString(text)
+	if valueSchema.MinLength != nil && length < *valueSchema.MinLength {
+		return &schemaError{path, fmt.Sprintf("must be at least %v "+
+			"characters long, not %v", *valueSchema.MinLength, length)}
+	}
+	if valueSchema.MaxLength != nil && length > *valueSchema.MaxLength {
+		return &schemaError{path, fmt.Sprintf("must be at most %v "+
+			"characters long, not %v", *valueSchema.MaxLength, length)}
+	}
+	if valueSchema.Pattern != nil && !valueSchema.Pattern.MatchString(text) {
+		return &schemaError{path, fmt.Sprintf("must match the pattern '%v'",
+			valueSchema.Pattern)}
+	}
+	return nil
+}
+
+func (valueSchema *schema) validateArray(array []interface{},
+	path string) error {
+	if valueSchema.MinItems != nil && len(array) < *valueSchema.MinItems {
+		return &schemaError{path, fmt.Sprintf("must have at least %v "+
+			"elements, not %v", *valueSchema.MinItems, len(array))}
+	}
+	if valueSchema.MaxItems != nil && len(array) > *valueSchema.MaxItems {
+		return &schemaError{path, fmt.Sprintf("must have at most %v "+
+			"elements, not %v", *valueSchema.MaxItems, len(array))}
+	}
+	if valueSchema.Items == nil {
+		return nil
+	}
+	for index, element := range array {
+		if err := valueSchema.Items.validateValue(element,
+			joinSchemaPath(path, strconv.Itoa(index))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateDocument checks the fields of a subdocument - or of the document
+// itself - in order of their names, so the mismatch reported is the same
+// whatever the order of fields in the input
+func (valueSchema *schema) validateDocument(document bson.M,
+	path string) error {
+	for _, name := range valueSchema.Required {
+		if _, ok := document[name]; !ok {
+			return &schemaError{joinSchemaPath(path, name), "is required"}
+		}
+	}
+	names := make([]string, 0, len(document))
+	for name := range document {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		property, ok := valueSchema.Properties[name]
+		if !ok {
+			if valueSchema.AdditionalProperties != nil &&
+				!*valueSchema.AdditionalProperties {
+				return &schemaError{joinSchemaPath(path, name),
+					"is not allowed"}
+			}
+			continue
+		}
+		if err := property.validateValue(document[name],
+			joinSchemaPath(path, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaNumber returns the given value as a float64 if it is a number
+func schemaNumber(value interface{}) (float64, bool) {
+	switch number := value.(type) {
+	case int:
+		return float64(number), true
+	case int32:
+		return float64(number), true
+	case int64:
+		return float64(number), true
+	case bson_ext.NumberLongExt:
+		return float64(number), true
+	case float32:
+		return float64(number), true
+	case float64:
+		return number, true
+	}
+	return 0, false
+}
+
+// schemaValuesEqual compares a document's value with a value from a schema,
+// which - being read from JSON - has float64 numbers and plain maps for
+// objects
+func schemaValuesEqual(value, schemaValue interface{}) bool {
+	if number, ok := schemaNumber(value); ok {
+		schemaNumber, ok := schemaValue.(float64)
+		return ok && number == schemaNumber
+	}
+	if document, ok := asDocument(value); ok {
+		schemaDocument, ok := schemaValue.(map[string]interface{})
+		if !ok || len(document) != len(schemaDocument) {
+			return false
+		}
+		for key, subValue := range document {
+			schemaSubValue, ok := schemaDocument[key]
+			if !ok || !schemaValuesEqual(subValue, schemaSubValue) {
+				return false
+			}
+		}
+		return true
+	}
+	if array, ok := value.([]interface{}); ok {
+		schemaArray, ok := schemaValue.([]interface{})
+		if !ok || len(array) != len(schemaArray) {
+			return false
+		}
+		for index, element := range array {
+			if !schemaValuesEqual(element, schemaArray[index]) {
+				return false
+			}
+		}
+		return true
+	}
+	switch value.(type) {
+	case nil, string, bool:
+		return value == schemaValue
+	}
+	return false
+}
+
+// formatSchemaValues formats the given schema values as JSON for error
+// messages
+func formatSchemaValues(values []interface{}) string {
+	formatted, err := json.Marshal(values)
+	if err != nil {
+		return fmt.Sprint(values)
+	}
+	return string(formatted)
+}
+
+// formatSchemaNumber formats a number for error messages without an exponent
+func formatSchemaNumber(number float64) string {
+	return strconv.FormatFloat(number, 'f', -1, 64)
+}
@@ -0,0 +1,273 @@
+package mongoimport
+
+import (
+	"github.com/shelman/mongo-tools-proto/common/bson_ext"
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseSchema(t *testing.T) {
+	Convey("Given a schema, on calling parseSchema", t, func() {
+		Convey("type may be a string or an array of strings", func() {
+			compiled, err := parseSchema([]byte(`{"properties": {
+				"a": {"type": "string"},
+				"b": {"type": ["number", "null"], "bsonType": "date"}
+			}}`))
+			So(err, ShouldBeNil)
+			So(compiled.Properties["a"].Types, ShouldResemble,
+				[]string{"string"})
+			So(compiled.Properties["b"].Types, ShouldResemble,
+				[]string{"number", "null", "date"})
+			_, err = parseSchema([]byte(`{"type": 1}`))
+			So(err, ShouldNotBeNil)
+		})
+		Convey("unknown types should cause an error naming the field",
+			func() {
+				_, err := parseSchema([]byte(`{"properties": {"a":
+					{"items": {"type": "str"}}}}`))
+				So(err, ShouldNotBeNil)
+				So(err.Error(), ShouldContainSubstring, "'a.items'")
+			})
+		Convey("bad patterns and negative lengths should cause an error",
+			func() {
+				_, err := parseSchema([]byte(`{"pattern": "("}`))
+				So(err, ShouldNotBeNil)
+				_, err = parseSchema([]byte(`{"minItems": -1}`))
+				So(err, ShouldNotBeNil)
+			})
+		Convey("a schema that is not a JSON object should cause an error",
+			func() {
+				_, err := parseSchema([]byte(`["type"]`))
+				So(err, ShouldNotBeNil)
+			})
+		Convey("exclusive bounds may be given as numbers", func() {
+			compiled, err := parseSchema([]byte(`{"minimum": 0,
+				"exclusiveMinimum": 1, "exclusiveMaximum": 10}`))
+			So(err, ShouldBeNil)
+			So(*compiled.Minimum, ShouldEqual, 0)
+			So(*compiled.ExclusiveMinimum, ShouldEqual, 1)
+			So(*compiled.ExclusiveMaximum, ShouldEqual, 10)
+			So(compiled.validateNumber(1, "a"), ShouldNotBeNil)
+			So(compiled.validateNumber(5, "a"), ShouldBeNil)
+		})
+		Convey("exclusive bounds may be given as booleans making minimum "+
+			"and maximum strict, as in draft 4", func() {
+			compiled, err := parseSchema([]byte(`{"minimum": 0,
+				"exclusiveMinimum": true, "maximum": 10,
+				"exclusiveMaximum": false}`))
+			So(err, ShouldBeNil)
+			So(compiled.Minimum, ShouldBeNil)
+			So(*compiled.ExclusiveMinimum, ShouldEqual, 0)
+			So(*compiled.Maximum, ShouldEqual, 10)
+			So(compiled.ExclusiveMaximum, ShouldBeNil)
+			So(compiled.validateNumber(0, "a"), ShouldNotBeNil)
+			So(compiled.validateNumber(10, "a"), ShouldBeNil)
+		})
+		Convey("bad exclusive bounds should cause an error", func() {
+			for _, spec := range []string{
+				`{"exclusiveMinimum": true}`,
+				`{"maximum": 1, "exclusiveMaximum": "1"}`,
+			} {
+				_, err := parseSchema([]byte(spec))
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestValidateSchema(t *testing.T) {
+	Convey("Given a schema, on calling validate", t, func() {
+		compiled, err := parseSchema([]byte(`{
+			"required": ["_id", "name"],
+			"properties": {
+				"_id": {"bsonType": "objectId"},
+				"name": {
+					"type": "object",
+					"required": ["first"],
+					"additionalProperties": false,
+					"properties": {
+						"first": {"type": "string", "minLength": 1},
+						"last": {"type": "string", "maxLength": 5}
+					}
+				},
+				"age": {"type": "integer", "minimum": 0, "maximum": 150},
+				"score": {"type": "number", "exclusiveMaximum": 1},
+				"status": {"enum": ["active", "retired", null]},
+				"zip": {"type": "string", "pattern": "^[0-9]{5}$"},
+				"born": {"bsonType": ["date", "null"]},
+				"views": {"bsonType": "long"},
+				"tags": {
+					"type": "array",
+					"minItems": 1,
+					"maxItems": 3,
+					"items": {"type": "object", "required": ["label"]}
+				}
+			}
+		}`))
+		So(err, ShouldBeNil)
+		valid := func() bson.M {
+			return bson.M{
+				"_id":    bson.NewObjectId(),
+				"name":   bson.M{"first": "Ada", "last": "Byron"},
+				"age":    36.0,
+				"score":  0.5,
+				"status": "retired",
+				"zip":    "10001",
+				"born":   time.Now(),
+				"views":  bson_ext.NumberLongExt(3),
+				"tags": []interface{}{
+					map[string]interface{}{"label": "math"},
+				},
+				"extra": true,
+			}
+		}
+		pathOf := func(err error) string {
+			So(err, ShouldHaveSameTypeAs, &schemaError{})
+			return err.(*schemaError).Path
+		}
+
+		Convey("a matching document should pass", func() {
+			So(compiled.validate(valid()), ShouldBeNil)
+			document := valid()
+			document["born"] = nil
+			document["age"] = int32(7)
+			So(compiled.validate(document), ShouldBeNil)
+		})
+		Convey("a missing required field should fail with its path", func() {
+			document := valid()
+			delete(document["name"].(bson.M), "first")
+			err := compiled.validate(document)
+			So(pathOf(err), ShouldEqual, "name.first")
+			So(err.Error(), ShouldEqual, "field 'name.first' is required")
+		})
+		Convey("values of the wrong type should fail", func() {
+			document := valid()
+			document["_id"] = "53cefc71b14ed89d84856287"
+			err := compiled.validate(document)
+			So(pathOf(err), ShouldEqual, "_id")
+			So(err.Error(), ShouldContainSubstring,
+				"must be of type objectId, not string")
+			document = valid()
+			document["age"] = 36.5
+			So(pathOf(compiled.validate(document)), ShouldEqual, "age")
+		})
+		Convey("values outside of the bounds should fail", func() {
+			document := valid()
+			document["age"] = int64(-1)
+			So(pathOf(compiled.validate(document)), ShouldEqual, "age")
+			document = valid()
+			document["score"] = 1
+			So(pathOf(compiled.validate(document)), ShouldEqual, "score")
+			document = valid()
+			document["name"].(bson.M)["last"] = "Lovelace"
+			So(pathOf(compiled.validate(document)), ShouldEqual, "name.last")
+			document = valid()
+			document["tags"] = []interface{}{}
+			So(pathOf(compiled.validate(document)), ShouldEqual, "tags")
+		})
+		Convey("values outside of the enum should fail", func() {
+			document := valid()
+			document["status"] = "unknown"
+			err := compiled.validate(document)
+			So(pathOf(err), ShouldEqual, "status")
+			So(err.Error(), ShouldContainSubstring,
+				`["active","retired",null]`)
+		})
+		Convey("strings not matching the pattern should fail", func() {
+			document := valid()
+			document["zip"] = "1000"
+			So(pathOf(compiled.validate(document)), ShouldEqual, "zip")
+		})
+		Convey("array elements should fail with their index", func() {
+			document := valid()
+			document["tags"] = []interface{}{
+				bson.M{"label": "math"},
+				bson.M{"name": "poetry"},
+			}
+			So(pathOf(compiled.validate(document)), ShouldEqual,
+				"tags.1.label")
+		})
+		Convey("fields that are not allowed should fail", func() {
+			document := valid()
+			document["name"].(bson.M)["middle"] = "King"
+			So(pathOf(compiled.validate(document)), ShouldEqual,
+				"name.middle")
+		})
+		Convey("a nil schema should match any document", func() {
+			var noSchema *schema
+			So(noSchema.validate(bson.M{"a": 1}), ShouldBeNil)
+		})
+	})
+}
+
+func TestSchemaValuesEqual(t *testing.T) {
+	Convey("Given a document value and a value from a schema", t, func() {
+		Convey("numbers should compare by value", func() {
+			So(schemaValuesEqual(int32(3), 3.0), ShouldBeTrue)
+			So(schemaValuesEqual(int64(3), 3.5), ShouldBeFalse)
+			So(schemaValuesEqual(3, "3"), ShouldBeFalse)
+		})
+		Convey("subdocuments and arrays should compare element-wise", func() {
+			So(schemaValuesEqual(bson.M{"a": []interface{}{1}},
+				map[string]interface{}{"a": []interface{}{1.0}}), ShouldBeTrue)
+			So(schemaValuesEqual(bson.M{"a": 1},
+				map[string]interface{}{"a": 1.0, "b": 2.0}), ShouldBeFalse)
+		})
+		Convey("values of other types should never be equal", func() {
+			So(schemaValuesEqual(time.Time{}, "0001-01-01"), ShouldBeFalse)
+		})
+	})
+}
+
+func TestSchemaImport(t *testing.T) {
+	Convey("Given a mongoimport instance with a schema file", t, func() {
+		file, err := ioutil.TempFile("", "mongoimport_")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		mongoImport := MongoImport{
+			ToolOptions: getBasicToolOptions(),
+			InputOptions: &options.InputOptions{
+				Type:   CSV,
+				File:   "testdata/test.csv",
+				Fields: "a,b,c",
+			},
+			IngestOptions: &options.IngestOptions{
+				DryRun:     true,
+				SchemaFile: file.Name(),
+			},
+		}
+		Convey("documents that do not match should be skipped", func() {
+			_, err = file.WriteString(`{"properties": {"b":
+				{"type": "integer"}}}`)
+			So(err, ShouldBeNil)
+			numImported, err := mongoImport.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numImported, ShouldEqual, 2)
+			So(mongoImport.failed, ShouldEqual, 1)
+		})
+		Convey("with --stopOnError, documents that do not match should "+
+			"stop the import", func() {
+			_, err = file.WriteString(`{"properties": {"b":
+				{"type": "integer"}}}`)
+			So(err, ShouldBeNil)
+			mongoImport.IngestOptions.StopOnError = true
+			_, err := mongoImport.ImportDocuments()
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "field 'b'")
+		})
+		Convey("a bad schema file should cause an error", func() {
+			_, err = file.WriteString(`{"type": "text"}`)
+			So(err, ShouldBeNil)
+			_, err := mongoImport.ImportDocuments()
+			So(err, ShouldNotBeNil)
+		})
+		Reset(func() {
+			file.Close()
+		})
+	})
+}
@@ -0,0 +1,512 @@
+package mongoimport
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/shelman/mongo-tools-proto/common/bson_ext"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"strconv"
+	"strings"
+)
+
+// transform is a sequence of rules read from a transform spec file, applied in
+// order to every imported document before it is written.
+//
+// A transform spec is a JSON array of single-key objects, each naming one of
+// the operations below and giving its arguments. Field names are dotted paths
+// through subdocuments. Operations with several fields apply to them in the
+// order they are written - except that the fields of a rename are all moved at
+// once, so that fields can be swapped - and fields missing from a document are
+// left alone.
+//
+//	[
+//	  {"rename": {"fname": "name.first", "lname": "name.last"}},
+//	  {"drop": ["internal_id"]},
+//	  {"set": {"batch": "2014-07", "name.full": {"$concat": ["$name.first",
+//	    " ", "$name.last"]}}},
+//	  {"cast": {"born": "date(2006-01-02)", "zip": "string"}},
+//	  {"split": {"tags": ";"}},
+//	  {"nest": {"address": ["street", "city", "zip"]}}
+//	]
+//
+// "set" values are extended JSON, whose integers are set as 64-bit integers; a
+// {"$concat": [...]} value joins strings and the values of fields referenced as
+// "$field". "cast" types are those of --columnsHaveTypes.
+type transform []transformRule
+
+// transformRule is a single operation of a transform on a single field
+type transformRule interface {
+	// apply transforms the given document in place
+	apply(document bson.M) error
+}
+
+// transformRuleParsers maps each operation of a transform spec to a function
+// that builds its rules from the operation's arguments
+var transformRuleParsers = map[string]func(json.RawMessage) ([]transformRule,
+	error){
+	"rename": parseRenameRules,
+	"drop":   parseDropRules,
+	"set":    parseSetRules,
+	"cast":   parseCastRules,
+	"split":  parseSplitRules,
+	"nest":   parseNestRules,
+}
+
+// readTransform reads the transform spec in the file at the given path
+func readTransform(path string) (transform, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	spec, err := parseTransform(data)
+	if err != nil {
+		return nil, fmt.Errorf("error reading transform spec '%v': %v", path,
+			err)
+	}
+	return spec, nil
+}
+
+// parseTransform parses the given transform spec
+func parseTransform(data []byte) (transform, error) {
+	var operations []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &operations); err != nil {
+		return nil, err
+	}
+	var rules transform
+	for index, operation := range operations {
+		if len(operation) != 1 {
+			return nil, fmt.Errorf("operation #%v must have exactly one key",
+				index+1)
+		}
+		for name, args := range operation {
+			parseRules, ok := transformRuleParsers[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown operation '%v'", name)
+			}
+			operationRules, err := parseRules(args)
+			if err != nil {
+				return nil, fmt.Errorf("bad arguments to '%v': %v", name, err)
+			}
+			rules = append(rules, operationRules...)
+		}
+	}
+	return rules, nil
+}
+
+// apply applies every rule of the transform to the given document in turn
+func (rules transform) apply(document bson.M) error {
+	for _, rule := range rules {
+		if err := rule.apply(document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseFieldArgs decodes the arguments of an operation that maps field names
+// to values into the given map, returning the field names in the order they
+// are written
+func parseFieldArgs(args json.RawMessage, values interface{}) ([]string,
+	error) {
+	decoder := json.NewDecoder(bytes.NewReader(args))
+	token, err := decoder.Token()
+	if err != nil {
+		return nil, err
+	}
+	if token != json.Delim('{') {
+		return nil, fmt.Errorf("arguments must be an object of field names")
+	}
+	var names []string
+	seen := map[string]bool{}
+	for decoder.More() {
+		if token, err = decoder.Token(); err != nil {
+			return nil, err
+		}
+		name, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("arguments must be an object of field names")
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("field '%v' is given more than once", name)
+		}
+		seen[name] = true
+		names = append(names, name)
+		// the values are decoded all at once below
+		var value json.RawMessage
+		if err = decoder.Decode(&value); err != nil {
+			return nil, err
+		}
+	}
+	if err = json.Unmarshal(args, values); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// renameRule moves the values of fields to other fields, all at once: each
+// value is moved to the field of the same index in to
+type renameRule struct {
+	fields []string
+	to     []string
+}
+
+func parseRenameRules(args json.RawMessage) ([]transformRule, error) {
+	var targets map[string]string
+	fields, err := parseFieldArgs(args, &targets)
+	if err != nil {
+		return nil, err
+	}
+	rule := renameRule{fields: fields}
+	renamed := map[string]string{}
+	for _, field := range fields {
+		to := targets[field]
+		if other, ok := renamed[to]; ok {
+			return nil, fmt.Errorf("'%v' and '%v' can not both be renamed "+
+				"to '%v'", other, field, to)
+		}
+		renamed[to] = field
+		rule.to = append(rule.to, to)
+	}
+	return []transformRule{rule}, nil
+}
+
+func (rule renameRule) apply(document bson.M) error {
+	// remove all of the fields before setting any, so that renaming a field
+	// to another that is renamed in turn never overwrites it
+	values := make([]interface{}, len(rule.fields))
+	found := make([]bool, len(rule.fields))
+	for index, field := range rule.fields {
+		values[index], found[index] = removeField(document, field)
+	}
+	for index, to := range rule.to {
+		if found[index] {
+			setNestedValue(document, to, values[index])
+		}
+	}
+	return nil
+}
+
+// dropRule removes a field
+type dropRule struct {
+	field string
+}
+
+func parseDropRules(args json.RawMessage) ([]transformRule, error) {
+	var fields []string
+	if err := json.Unmarshal(args, &fields); err != nil {
+		return nil, err
+	}
+	rules := make([]transformRule, 0, len(fields))
+	for _, field := range fields {
+		rules = append(rules, dropRule{field})
+	}
+	return rules, nil
+}
+
+func (rule dropRule) apply(document bson.M) error {
+	removeField(document, rule.field)
+	return nil
+}
+
+// setRule sets a field to a constant value or, if concat is set, to the
+// concatenation of its parts
+type setRule struct {
+	field string
+	value interface{}
+	// concat holds strings and - prefixed with '$' - references to fields
+	concat []string
+}
+
+func parseSetRules(args json.RawMessage) ([]transformRule, error) {
+	rawValues := map[string]json.RawMessage{}
+	fields, err := parseFieldArgs(args, &rawValues)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]transformRule, 0, len(fields))
+	for _, field := range fields {
+		rule := setRule{field: field}
+		// numbers are decoded as they are written, to tell integers apart
+		var setValue interface{}
+		decoder := json.NewDecoder(bytes.NewReader(rawValues[field]))
+		decoder.UseNumber()
+		if err = decoder.Decode(&setValue); err != nil {
+			return nil, err
+		}
+		setValue = convertNumbers(setValue)
+		if concat, ok := setValue.(map[string]interface{}); ok &&
+			len(concat) == 1 && concat["$concat"] != nil {
+			parts, ok := concat["$concat"].([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("$concat for '%v' must be an array",
+					field)
+			}
+			for _, part := range parts {
+				partString, ok := part.(string)
+				if !ok {
+					return nil, fmt.Errorf("$concat for '%v' must only hold "+
+						"strings", field)
+				}
+				rule.concat = append(rule.concat, partString)
+			}
+		} else {
+			// convert extended JSON the way JSON input is
+			value := bson.M{"value": setValue}
+			if err = bson_ext.ConvertSubdocsFromJSON(value); err != nil {
+				return nil, fmt.Errorf("bad value for '%v': %v", field, err)
+			}
+			rule.value = value["value"]
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (rule setRule) apply(document bson.M) error {
+	if rule.concat == nil {
+		// each document gets its own copy of the constant, as later rules
+		// may change it
+		setNestedValue(document, rule.field, copyValue(rule.value))
+		return nil
+	}
+	concatenated := bytes.Buffer{}
+	for _, part := range rule.concat {
+		if strings.HasPrefix(part, "$") {
+			if value, ok := getField(document, part[1:]); ok && value != nil {
+				concatenated.WriteString(formatValue(value))
+			}
+			continue
+		}
+		concatenated.WriteString(part)
+	}
+	setNestedValue(document, rule.field, concatenated.String())
+	return nil
+}
+
+// castRule converts the value of a field to another type
+type castRule struct {
+	field  string
+	parser FieldParser
+}
+
+func parseCastRules(args json.RawMessage) ([]transformRule, error) {
+	var types map[string]string
+	fields, err := parseFieldArgs(args, &types)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]transformRule, 0, len(fields))
+	for _, field := range fields {
+		// the types are those of typed fields, i.e. "field.type()", though
+		// types without an argument may leave out the parentheses
+		typeSpec := types[field]
+		if !strings.Contains(typeSpec, "(") {
+			typeSpec += "()"
+		}
+		_, parser, err := ParseTypedField(field + "." + typeSpec)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, castRule{field, parser})
+	}
+	return rules, nil
+}
+
+// apply parses the field's value - or, for values that are not strings, their
+// text - as a value of the rule's type. Blank and null values are left as
+// they are.
+func (rule castRule) apply(document bson.M) error {
+	parent, key := fieldParent(document, rule.field)
+	value, ok := parent[key]
+	if !ok || value == nil || value == "" {
+		return nil
+	}
+	token, ok := value.(string)
+	if !ok {
+		token = formatValue(value)
+	}
+	parsed, err := rule.parser.Parse(token)
+	if err != nil {
+		return fmt.Errorf("field '%v': %v", rule.field, err)
+	}
+	parent[key] = parsed
+	return nil
+}
+
+// convertNumbers converts the numbers, decoded as json.Number, within the given
+// value to int64 if they are integers, or else to float64
+func convertNumbers(value interface{}) interface{} {
+	switch typedValue := value.(type) {
+	case json.Number:
+		if integer, err := typedValue.Int64(); err == nil {
+			return integer
+		}
+		// json.Number always holds a valid number
+		float, _ := typedValue.Float64()
+		return float
+	case map[string]interface{}:
+		for key, subValue := range typedValue {
+			typedValue[key] = convertNumbers(subValue)
+		}
+	case []interface{}:
+		for index, element := range typedValue {
+			typedValue[index] = convertNumbers(element)
+		}
+	}
+	return value
+}
+
+// formatValue returns the text of the given value, with floating point numbers
+// written out in full rather than in exponent notation
+func formatValue(value interface{}) string {
+	switch typedValue := value.(type) {
+	case float64:
+		return strconv.FormatFloat(typedValue, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(typedValue), 'f', -1, 32)
+	}
+	return fmt.Sprint(value)
+}
+
+// splitRule splits a string field into an array of strings
+type splitRule struct {
+	field     string
+	separator string
+}
+
+func parseSplitRules(args json.RawMessage) ([]transformRule, error) {
+	var separators map[string]string
+	fields, err := parseFieldArgs(args, &separators)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]transformRule, 0, len(fields))
+	for _, field := range fields {
+		if separators[field] == "" {
+			return nil, fmt.Errorf("separator for '%v' can not be empty",
+				field)
+		}
+		rules = append(rules, splitRule{field, separators[field]})
+	}
+	return rules, nil
+}
+
+// apply splits the field if it holds a string; a blank string becomes an
+// empty array
+func (rule splitRule) apply(document bson.M) error {
+	parent, key := fieldParent(document, rule.field)
+	value, ok := parent[key].(string)
+	if !ok {
+		return nil
+	}
+	array := []interface{}{}
+	if value != "" {
+		for _, element := range strings.Split(value, rule.separator) {
+			array = append(array, element)
+		}
+	}
+	parent[key] = array
+	return nil
+}
+
+// nestRule moves fields into a subdocument, keeping the last segment of
+// their names
+type nestRule struct {
+	field   string
+	sources []string
+}
+
+func parseNestRules(args json.RawMessage) ([]transformRule, error) {
+	var sources map[string][]string
+	fields, err := parseFieldArgs(args, &sources)
+	if err != nil {
+		return nil, err
+	}
+	rules := make([]transformRule, 0, len(fields))
+	for _, field := range fields {
+		rules = append(rules, nestRule{field, sources[field]})
+	}
+	return rules, nil
+}
+
+// apply adds the source fields to the subdocument, creating it if any of the
+// source fields is present
+func (rule nestRule) apply(document bson.M) error {
+	for _, source := range rule.sources {
+		value, ok := removeField(document, source)
+		if !ok {
+			continue
+		}
+		name := source[strings.LastIndex(source, ".")+1:]
+		setNestedValue(document, rule.field+"."+name, value)
+	}
+	return nil
+}
+
+// asDocument returns the given value as a bson.M if it is a subdocument.
+// Subdocuments of JSON input are plain maps.
+func asDocument(value interface{}) (bson.M, bool) {
+	switch document := value.(type) {
+	case bson.M:
+		return document, true
+	case map[string]interface{}:
+		return bson.M(document), true
+	}
+	return nil, false
+}
+
+// fieldParent returns the subdocument holding the given dotted field along
+// with the field's key in that subdocument. The subdocument is nil if it does
+// not exist.
+func fieldParent(document bson.M, field string) (bson.M, string) {
+	parts := strings.Split(field, ".")
+	parent := document
+	for _, part := range parts[:len(parts)-1] {
+		subdocument, ok := asDocument(parent[part])
+		if !ok {
+			return nil, ""
+		}
+		parent = subdocument
+	}
+	return parent, parts[len(parts)-1]
+}
+
+// getField returns the value of the given dotted field, if present
+func getField(document bson.M, field string) (interface{}, bool) {
+	parent, key := fieldParent(document, field)
+	value, ok := parent[key]
+	return value, ok
+}
+
+// removeField removes the given dotted field from the document, returning
+// its value if it was present
+func removeField(document bson.M, field string) (interface{}, bool) {
+	parent, key := fieldParent(document, field)
+	value, ok := parent[key]
+	if ok {
+		delete(parent, key)
+	}
+	return value, ok
+}
+
+// copyValue returns a deep copy of the given subdocument or array, or the
+// value itself otherwise
+func copyValue(value interface{}) interface{} {
+	if document, ok := asDocument(value); ok {
+		copied := bson.M{}
+		for key, subValue := range document {
+			copied[key] = copyValue(subValue)
+		}
+		return copied
+	}
+	if array, ok := value.([]interface{}); ok {
+		copied := make([]interface{}, len(array))
+		for index, element := range array {
+			copied[index] = copyValue(element)
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,208 @@
+package mongoimport
+
+import (
+	"github.com/shelman/mongo-tools-proto/mongoimport/options"
+	. "github.com/smartystreets/goconvey/convey"
+	"io/ioutil"
+	"labix.org/v2/mgo/bson"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestParseTransform(t *testing.T) {
+	Convey("Given a transform spec, on calling parseTransform", t, func() {
+		Convey("operations should expand to rules for their fields, in the "+
+			"order they are written", func() {
+			rules, err := parseTransform([]byte(`[
+				{"rename": {"b": "c", "a": "d"}},
+				{"drop": ["e"]},
+				{"split": {"y": ";", "x": ","}}
+			]`))
+			So(err, ShouldBeNil)
+			So(rules, ShouldResemble, transform{
+				renameRule{[]string{"b", "a"}, []string{"c", "d"}},
+				dropRule{"e"},
+				splitRule{"y", ";"},
+				splitRule{"x", ","},
+			})
+		})
+		Convey("an error should be returned for bad specs", func() {
+			for _, spec := range []string{
+				`{"drop": ["a"]}`,
+				`[{"drop": ["a"], "set": {"b": 1}}]`,
+				`[{"uppercase": ["a"]}]`,
+				`[{"drop": "a"}]`,
+				`[{"cast": {"a": "decimal"}}]`,
+				`[{"split": {"a": ""}}]`,
+				`[{"set": {"a": {"$concat": ["x", 1]}}}]`,
+				`[{"set": {"a": {"$date": 1}}}]`,
+				`[{"rename": {"a": "c", "b": "c"}}]`,
+				`[{"split": {"a": ";", "a": ","}}]`,
+			} {
+				_, err := parseTransform([]byte(spec))
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestApplyTransform(t *testing.T) {
+	Convey("Given a document, on applying a transform", t, func() {
+		Convey("renamed fields should be moved all at once, whatever their "+
+			"names", func() {
+			rules, err := parseTransform([]byte(`[
+				{"rename": {"a": "b", "b": "a"}},
+				{"rename": {"y": "z", "x": "y"}}
+			]`))
+			So(err, ShouldBeNil)
+			document := bson.M{"a": 1, "b": 2, "x": 3, "y": 4}
+			So(rules.apply(document), ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"a": 2, "b": 1, "y": 3,
+				"z": 4})
+		})
+		Convey("fields should be renamed, dropped and nested", func() {
+			rules, err := parseTransform([]byte(`[
+				{"rename": {"fname": "name.first", "missing": "x"}},
+				{"drop": ["tmp", "a.b"]},
+				{"nest": {"address": ["street", "loc.city"]}}
+			]`))
+			So(err, ShouldBeNil)
+			document := bson.M{"fname": "Ada", "tmp": 1,
+				"a": bson.M{"b": 2, "c": 3}, "street": "Main",
+				"loc": bson.M{"city": "York"}}
+			So(rules.apply(document), ShouldBeNil)
+			So(document, ShouldResemble, bson.M{
+				"name":    bson.M{"first": "Ada"},
+				"a":       bson.M{"c": 3},
+				"address": bson.M{"street": "Main", "city": "York"},
+				"loc":     bson.M{},
+			})
+		})
+		Convey("constant and concatenated fields should be set", func() {
+			rules, err := parseTransform([]byte(`[
+				{"set": {
+					"batch": {"sub": "x"},
+					"born": {"$date": "2014-07-01T00:00:00.000+0000"},
+					"name.full": {"$concat": ["$first", " ", "$last"]}
+				}}
+			]`))
+			So(err, ShouldBeNil)
+			first := bson.M{"first": "Ada", "last": "Lovelace"}
+			So(rules.apply(first), ShouldBeNil)
+			So(first["name"], ShouldResemble, bson.M{"full": "Ada Lovelace"})
+			So(first["born"].(time.Time).Equal(time.Date(2014, 7, 1, 0, 0,
+				0, 0, time.UTC)), ShouldBeTrue)
+			// each document should get its own copy of a constant
+			first["batch"].(bson.M)["sub"] = "changed"
+			second := bson.M{}
+			So(rules.apply(second), ShouldBeNil)
+			So(second["batch"], ShouldResemble, bson.M{"sub": "x"})
+		})
+		Convey("integers should be set as integers, and other numbers as "+
+			"doubles", func() {
+			rules, err := parseTransform([]byte(`[
+				{"set": {"i": 3, "f": 2.5, "e": 1e3, "big": 9007199254740993,
+					"sub": {"n": -4, "list": [1, 0.5]}}}
+			]`))
+			So(err, ShouldBeNil)
+			document := bson.M{}
+			So(rules.apply(document), ShouldBeNil)
+			So(document, ShouldResemble, bson.M{
+				"i":   int64(3),
+				"f":   2.5,
+				"e":   1000.0,
+				"big": int64(9007199254740993),
+				"sub": bson.M{"n": int64(-4),
+					"list": []interface{}{int64(1), 0.5}},
+			})
+		})
+		Convey("numbers should be cast and concatenated as they read",
+			func() {
+				rules, err := parseTransform([]byte(`[
+					{"set": {"label": {"$concat": ["$f", "/", "$i"]}}},
+					{"cast": {"f": "string", "i": "int32"}}
+				]`))
+				So(err, ShouldBeNil)
+				document := bson.M{"f": 1e6, "i": 2e6}
+				So(rules.apply(document), ShouldBeNil)
+				So(document, ShouldResemble, bson.M{
+					"f":     "1000000",
+					"i":     int32(2000000),
+					"label": "1000000/2000000",
+				})
+			})
+		Convey("fields should be cast and split, leaving blanks alone",
+			func() {
+				rules, err := parseTransform([]byte(`[
+					{"cast": {"zip": "string", "n": "int32",
+						"day": "date(2006-01-02)", "blank": "int32"}},
+					{"split": {"tags": ";", "none": ";"}}
+				]`))
+				So(err, ShouldBeNil)
+				document := bson.M{"zip": 2138, "n": "7", "day": "2014-07-01",
+					"blank": "", "tags": "a;b", "none": ""}
+				So(rules.apply(document), ShouldBeNil)
+				So(document, ShouldResemble, bson.M{
+					"zip":   "2138",
+					"n":     int32(7),
+					"day":   time.Date(2014, 7, 1, 0, 0, 0, 0, time.UTC),
+					"blank": "",
+					"tags":  []interface{}{"a", "b"},
+					"none":  []interface{}{},
+				})
+			})
+		Convey("values that can not be cast should cause an error", func() {
+			rules, err := parseTransform([]byte(`[{"cast": {"n": "int32"}}]`))
+			So(err, ShouldBeNil)
+			So(rules.apply(bson.M{"n": "seven"}), ShouldNotBeNil)
+		})
+		Convey("subdocuments of JSON input should be transformed in place",
+			func() {
+				rules, err := parseTransform([]byte(`[
+					{"rename": {"a.b": "a.c"}}
+				]`))
+				So(err, ShouldBeNil)
+				document := bson.M{"a": map[string]interface{}{"b": 1, "d": 2}}
+				So(rules.apply(document), ShouldBeNil)
+				So(document["a"], ShouldResemble, bson.M{"c": 1, "d": 2})
+			})
+	})
+}
+
+func TestTransformImport(t *testing.T) {
+	Convey("Given a mongoimport instance with a transform file", t, func() {
+		file, err := ioutil.TempFile("", "mongoimport_")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		mongoImport := MongoImport{
+			ToolOptions: getBasicToolOptions(),
+			InputOptions: &options.InputOptions{
+				Type:   CSV,
+				File:   "testdata/test.csv",
+				Fields: "a,b,c",
+			},
+			IngestOptions: &options.IngestOptions{
+				DryRun:        true,
+				TransformFile: file.Name(),
+			},
+		}
+		Convey("documents that fail to transform should be skipped", func() {
+			_, err = file.WriteString(`[{"cast": {"b": "int32"}}]`)
+			So(err, ShouldBeNil)
+			numImported, err := mongoImport.ImportDocuments()
+			So(err, ShouldBeNil)
+			So(numImported, ShouldEqual, 2)
+			So(mongoImport.failed, ShouldEqual, 1)
+		})
+		Convey("a bad transform file should cause an error", func() {
+			_, err = file.WriteString(`[{"cast": {"b": "number"}}]`)
+			So(err, ShouldBeNil)
+			_, err := mongoImport.ImportDocuments()
+			So(err, ShouldNotBeNil)
+		})
+		Reset(func() {
+			file.Close()
+		})
+	})
+}
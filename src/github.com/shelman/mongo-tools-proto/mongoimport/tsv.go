@@ -2,9 +2,9 @@ package mongoimport
 
 import (
 	"bufio"
+	"github.com/shelman/mongo-tools-proto/common/util"
 	"io"
 	"labix.org/v2/mgo/bson"
-	"strconv"
 	"strings"
 )
 
@@ -18,9 +18,17 @@ const (
 type TSVImportInput struct {
 	// Fields is a list of field names in the BSON documents to be imported
 	Fields []string
+	// ColumnsHaveTypes indicates that header line entries declare the type of
+	// their column, e.g. "name.string()"
+	ColumnsHaveTypes bool
+	// parsers holds the parser for each of the Fields when the columns have
+	// declared types
+	parsers []FieldParser
 	// tsvReader is the underlying reader used to read data in from the TSV
 	// or TSV file
 	tsvReader *bufio.Reader
+	// lastRecord describes the record last read from the input source
+	lastRecord InputRecord
 }
 
 // NewTSVImportInput returns a TSVImportInput configured to read input from the
@@ -32,42 +40,92 @@ func NewTSVImportInput(fields []string, in io.Reader) *TSVImportInput {
 	}
 }
 
+// readRecord reads the next line from the TSV, keeping track of its raw text.
+// A final line without a line break is a record like any other.
+func (tsvImporter *TSVImportInput) readRecord() (string, error) {
+	tsvRecord, err := tsvImporter.tsvReader.ReadString(entryDelimiter)
+	if err == io.EOF && len(tsvRecord) != 0 {
+		err = nil
+	}
+	tsvImporter.lastRecord.Line = tsvImporter.lastRecord.LinesRead + 1
+	tsvImporter.lastRecord.Raw = []byte(tsvRecord)
+	tsvImporter.lastRecord.Offset += int64(len(tsvRecord))
+	if strings.HasSuffix(tsvRecord, string(entryDelimiter)) {
+		tsvImporter.lastRecord.LinesRead++
+	}
+	return tsvRecord, err
+}
+
+// SkipLines skips the given number of lines at the current position of the
+// input source
+func (tsvImporter *TSVImportInput) SkipLines(count int) error {
+	for i := 0; i < count; i++ {
+		if _, err := tsvImporter.readRecord(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ResumeFrom continues reading the TSV from the given io.Reader, which must
+// be positioned just after the given record
+func (tsvImporter *TSVImportInput) ResumeFrom(in io.Reader, record InputRecord) {
+	tsvImporter.tsvReader = bufio.NewReader(in)
+	tsvImporter.lastRecord = record
+}
+
+// LastRecord returns the record last read from the TSV
+func (tsvImporter *TSVImportInput) LastRecord() InputRecord {
+	return tsvImporter.lastRecord
+}
+
 // SetHeader sets the header field for a TSV
 func (tsvImporter *TSVImportInput) SetHeader() error {
-	headers, err := tsvImporter.tsvReader.ReadString(entryDelimiter)
+	headers, err := tsvImporter.readRecord()
 	if err != nil {
 		return err
 	}
-	tokenizedHeaders := strings.Split(headers, tokenSeparator)
+	tokenizedHeaders := tokenizeTSVRecord(headers)
 
-	for _, header := range tokenizedHeaders {
-		tsvImporter.Fields = append(tsvImporter.Fields,
-			strings.TrimSpace(header))
+	if tsvImporter.ColumnsHaveTypes {
+		fields, parsers, err := ParseTypedFields(tokenizedHeaders)
+		if err != nil {
+			return err
+		}
+		tsvImporter.Fields = append(tsvImporter.Fields, fields...)
+		tsvImporter.parsers = append(tsvImporter.parsers, parsers...)
+	} else {
+		for _, header := range tokenizedHeaders {
+			tsvImporter.Fields = append(tsvImporter.Fields,
+				strings.TrimSpace(header))
+		}
 	}
-	return nil
+	return validateFields(tsvImporter.Fields)
 }
 
 // ImportDocument reads a line of input with the TSV representation of a doc and
 // returns the BSON equivalent.
 func (tsvImporter *TSVImportInput) ImportDocument() (bson.M, error) {
-	tsvRecord, err := tsvImporter.tsvReader.ReadString(entryDelimiter)
+	tsvRecord, err := tsvImporter.readRecord()
 	if err != nil {
 		return nil, err
 	}
-	document := bson.M{}
+	tokens := tokenizeTSVRecord(tsvRecord)
+	return tokensToBSON(tsvImporter.Fields, tsvImporter.parsers, tokens)
+}
 
-	// strip the trailing '\n' from ReadString
-	if len(tsvRecord) != 0 {
-		tsvRecord = tsvRecord[:len(tsvRecord)-1]
-	}
+// tokenizeTSVRecord strips the line break - '\n' or "\r\n" - from the given
+// TSV record and splits it into its fields, undoing the backslash escapes of
+// tabs, line breaks and backslashes within each
+func tokenizeTSVRecord(tsvRecord string) []string {
+	tsvRecord = strings.TrimSuffix(tsvRecord, string(entryDelimiter))
+	tsvRecord = strings.TrimSuffix(tsvRecord, "\r")
 	tokens := strings.Split(tsvRecord, tokenSeparator)
 	for index, token := range tokens {
-		parsedValue := getParsedValue(token)
-		if index < len(tsvImporter.Fields) {
-			document[tsvImporter.Fields[index]] = parsedValue
-		} else {
-			document["field"+strconv.Itoa(index)] = parsedValue
-		}
+		tokens[index] = util.UnescapeTSV(token)
 	}
-	return document, nil
+	return tokens
 }
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"labix.org/v2/mgo/bson"
 	"os"
+	"strings"
 	"testing"
 )
 
@@ -161,6 +162,39 @@ func TestTSVImportDocument(t *testing.T) {
 	})
 }
 
+func TestTSVLineEndingsAndEscapes(t *testing.T) {
+	Convey("With a TSV import input", t, func() {
+		Convey("CRLF line breaks should be stripped and a final line "+
+			"without a line break should be imported", func() {
+			contents := "1\tx\r\n2\ty"
+			tsvImporter := NewTSVImportInput([]string{"a", "b"},
+				strings.NewReader(contents))
+			bsonDoc, err := tsvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(bsonDoc, ShouldResemble, bson.M{"a": 1, "b": "x"})
+			bsonDoc, err = tsvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(bsonDoc, ShouldResemble, bson.M{"a": 2, "b": "y"})
+			So(tsvImporter.LastRecord().Offset, ShouldEqual, len(contents))
+			_, err = tsvImporter.ImportDocument()
+			So(err, ShouldEqual, io.EOF)
+		})
+		Convey("escaped tabs, line breaks and backslashes should be "+
+			"unescaped", func() {
+			contents := "a\\tb\tc\\nd\\\\e\tC:\\data\n"
+			tsvImporter := NewTSVImportInput([]string{"a", "b"},
+				strings.NewReader(contents))
+			bsonDoc, err := tsvImporter.ImportDocument()
+			So(err, ShouldBeNil)
+			So(bsonDoc, ShouldResemble, bson.M{
+				"a":      "a\tb",
+				"b":      "c\nd\\e",
+				"field2": "C:\\data",
+			})
+		})
+	})
+}
+
 func TestTSVSetHeader(t *testing.T) {
 	var err error
 	var tsvFile, fileHandle *os.File
@@ -0,0 +1,230 @@
+package mongoimport
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"labix.org/v2/mgo/bson"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FieldParser converts a single CSV or TSV token to a BSON value of the type
+// declared for its column
+type FieldParser interface {
+	// Parse returns the value represented by the given token, or an error if
+	// the token does not hold a value of the parser's type
+	Parse(token string) (interface{}, error)
+}
+
+// fieldParserConstructors maps each type name allowed in a typed field
+// specification to a function that builds its parser from the argument
+// enclosed in the specification's parentheses
+var fieldParserConstructors = map[string]func(arg string) (FieldParser, error){
+	"auto":     noArgParser(autoParser{}),
+	"string":   noArgParser(stringParser{}),
+	"int32":    noArgParser(int32Parser{}),
+	"int64":    noArgParser(int64Parser{}),
+	"double":   noArgParser(doubleParser{}),
+	"boolean":  noArgParser(booleanParser{}),
+	"objectid": noArgParser(objectIdParser{}),
+	"date":     newDateParser,
+	"binary":   newBinaryParser,
+}
+
+// noArgParser returns a parser constructor for types that take no argument
+func noArgParser(parser FieldParser) func(string) (FieldParser, error) {
+	return func(arg string) (FieldParser, error) {
+		if arg != "" {
+			return nil, fmt.Errorf("type takes no argument")
+		}
+		return parser, nil
+	}
+}
+
+// ParseTypedField splits a field specification such as "created.date(2006-01-02)"
+// into the field name ("created") and a FieldParser for the declared type. The
+// field name itself may contain dots.
+func ParseTypedField(spec string) (string, FieldParser, error) {
+	openParen := strings.Index(spec, "(")
+	if openParen == -1 || !strings.HasSuffix(spec, ")") {
+		return "", nil, fmt.Errorf("field '%v' has no type - typed fields "+
+			"must be of the form 'name.type()'", spec)
+	}
+	typeStart := strings.LastIndex(spec[:openParen], ".")
+	if typeStart < 1 {
+		return "", nil, fmt.Errorf("field '%v' has no name - typed fields "+
+			"must be of the form 'name.type()'", spec)
+	}
+	name := spec[:typeStart]
+	typeName := spec[typeStart+1 : openParen]
+	arg := spec[openParen+1 : len(spec)-1]
+	newParser, ok := fieldParserConstructors[typeName]
+	if !ok {
+		return "", nil, fmt.Errorf("field '%v' has unknown type '%v'", name,
+			typeName)
+	}
+	parser, err := newParser(arg)
+	if err != nil {
+		return "", nil, fmt.Errorf("field '%v' has invalid type '%v(%v)': %v",
+			name, typeName, arg, err)
+	}
+	return name, parser, nil
+}
+
+// ParseTypedFields calls ParseTypedField on each of the given specifications,
+// returning the field names and their parsers
+func ParseTypedFields(specs []string) ([]string, []FieldParser, error) {
+	fields := make([]string, 0, len(specs))
+	parsers := make([]FieldParser, 0, len(specs))
+	for _, spec := range specs {
+		name, parser, err := ParseTypedField(strings.TrimSpace(spec))
+		if err != nil {
+			return nil, nil, err
+		}
+		fields = append(fields, name)
+		parsers = append(parsers, parser)
+	}
+	return fields, parsers, nil
+}
+
+// tokensToBSON builds a BSON document from the tokens of a single CSV or TSV
+// record. Each value is named after the field in the same position, and is
+// converted by that field's parser - or by getParsedValue if the field has no
+// parser. Dotted field names build nested subdocuments and arrays. Tokens
+// beyond the known fields are named "field<index>". Blank tokens are kept as
+// empty strings so that --ignoreBlanks can remove them.
+func tokensToBSON(fields []string, parsers []FieldParser, tokens []string) (
+	bson.M, error) {
+	document := bson.M{}
+	for index, token := range tokens {
+		var parser FieldParser = autoParser{}
+		if index < len(parsers) && parsers[index] != nil && token != "" {
+			parser = parsers[index]
+		}
+		key := "field" + strconv.Itoa(index)
+		if index < len(fields) {
+			key = fields[index]
+		}
+		parsedValue, err := parser.Parse(token)
+		if err != nil {
+			return document, fmt.Errorf("field '%v': %v", key, err)
+		}
+		setNestedValue(document, key, parsedValue)
+	}
+	return document, nil
+}
+
+// autoParser guesses the type of a token; see getParsedValue
+type autoParser struct{}
+
+func (autoParser) Parse(token string) (interface{}, error) {
+	return getParsedValue(token), nil
+}
+
+type stringParser struct{}
+
+func (stringParser) Parse(token string) (interface{}, error) {
+	return token, nil
+}
+
+type int32Parser struct{}
+
+func (int32Parser) Parse(token string) (interface{}, error) {
+	value, err := strconv.ParseInt(strings.TrimSpace(token), 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse '%v' as int32", token)
+	}
+	return int32(value), nil
+}
+
+type int64Parser struct{}
+
+func (int64Parser) Parse(token string) (interface{}, error) {
+	value, err := strconv.ParseInt(strings.TrimSpace(token), 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse '%v' as int64", token)
+	}
+	return value, nil
+}
+
+type doubleParser struct{}
+
+func (doubleParser) Parse(token string) (interface{}, error) {
+	value, err := strconv.ParseFloat(strings.TrimSpace(token), 64)
+	if err != nil {
+		return nil, fmt.Errorf("can not parse '%v' as double", token)
+	}
+	return value, nil
+}
+
+type booleanParser struct{}
+
+func (booleanParser) Parse(token string) (interface{}, error) {
+	value, err := strconv.ParseBool(strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("can not parse '%v' as boolean", token)
+	}
+	return value, nil
+}
+
+type objectIdParser struct{}
+
+func (objectIdParser) Parse(token string) (interface{}, error) {
+	token = strings.TrimSpace(token)
+	if !bson.IsObjectIdHex(token) {
+		return nil, fmt.Errorf("can not parse '%v' as objectid", token)
+	}
+	return bson.ObjectIdHex(token), nil
+}
+
+// dateParser parses dates using a Go time layout, e.g. date(2006-01-02)
+type dateParser struct {
+	layout string
+}
+
+func newDateParser(layout string) (FieldParser, error) {
+	if layout == "" {
+		return nil, fmt.Errorf("date type requires a layout, " +
+			"e.g. date(2006-01-02)")
+	}
+	return dateParser{layout}, nil
+}
+
+func (parser dateParser) Parse(token string) (interface{}, error) {
+	value, err := time.Parse(parser.layout, strings.TrimSpace(token))
+	if err != nil {
+		return nil, fmt.Errorf("can not parse '%v' as date(%v)", token,
+			parser.layout)
+	}
+	return value, nil
+}
+
+// binaryParser decodes binary data in either base64 or hex encoding
+type binaryParser struct {
+	encoding string
+}
+
+func newBinaryParser(encoding string) (FieldParser, error) {
+	if encoding != "base64" && encoding != "hex" {
+		return nil, fmt.Errorf("binary type requires an encoding of " +
+			"either 'base64' or 'hex'")
+	}
+	return binaryParser{encoding}, nil
+}
+
+func (parser binaryParser) Parse(token string) (interface{}, error) {
+	var value []byte
+	var err error
+	if parser.encoding == "hex" {
+		value, err = hex.DecodeString(strings.TrimSpace(token))
+	} else {
+		value, err = base64.StdEncoding.DecodeString(strings.TrimSpace(token))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not parse '%v' as binary(%v)", token,
+			parser.encoding)
+	}
+	return value, nil
+}
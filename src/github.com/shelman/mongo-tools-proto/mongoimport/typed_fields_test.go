@@ -0,0 +1,100 @@
+package mongoimport
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"labix.org/v2/mgo/bson"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseTypedField(t *testing.T) {
+	Convey("Given a typed field specification, on calling ParseTypedField",
+		t, func() {
+			Convey("the name and type should be split correctly", func() {
+				name, parser, err := ParseTypedField("zip.string()")
+				So(err, ShouldBeNil)
+				So(name, ShouldEqual, "zip")
+				So(parser, ShouldResemble, stringParser{})
+			})
+			Convey("dots in the field name should be kept", func() {
+				name, parser, err := ParseTypedField("a.b.int64()")
+				So(err, ShouldBeNil)
+				So(name, ShouldEqual, "a.b")
+				So(parser, ShouldResemble, int64Parser{})
+			})
+			Convey("the type argument should be passed to the parser", func() {
+				name, parser, err := ParseTypedField("created.date(2006.01.02)")
+				So(err, ShouldBeNil)
+				So(name, ShouldEqual, "created")
+				So(parser, ShouldResemble, dateParser{"2006.01.02"})
+			})
+			Convey("an error should be returned if no type is given", func() {
+				_, _, err := ParseTypedField("zip")
+				So(err, ShouldNotBeNil)
+			})
+			Convey("an error should be returned if no name is given", func() {
+				_, _, err := ParseTypedField("string()")
+				So(err, ShouldNotBeNil)
+			})
+			Convey("an error should be returned for unknown types", func() {
+				_, _, err := ParseTypedField("zip.zipcode()")
+				So(err, ShouldNotBeNil)
+			})
+			Convey("an error should be returned for invalid type arguments",
+				func() {
+					_, _, err := ParseTypedField("a.int32(10)")
+					So(err, ShouldNotBeNil)
+					_, _, err = ParseTypedField("a.date()")
+					So(err, ShouldNotBeNil)
+					_, _, err = ParseTypedField("a.binary(base32)")
+					So(err, ShouldNotBeNil)
+				})
+		})
+}
+
+func TestTokensToBSON(t *testing.T) {
+	Convey("Given typed fields, on calling tokensToBSON", t, func() {
+		specs := []string{"zip.string()", "created.date(2006-01-02)",
+			"active.boolean()", "id.int64()", "blob.binary(base64)",
+			"_id.objectid()", "count.int32()", "ratio.double()"}
+		fields, parsers, err := ParseTypedFields(specs)
+		So(err, ShouldBeNil)
+
+		Convey("each value should be parsed as its declared type", func() {
+			tokens := strings.Split("02134,2014-07-25,true,7,aGVsbG8=,"+
+				"53cefc71b14ed89d84856287,12,0.5", ",")
+			document, err := tokensToBSON(fields, parsers, tokens)
+			So(err, ShouldBeNil)
+			So(document, ShouldResemble, bson.M{
+				"zip":     "02134",
+				"created": time.Date(2014, 7, 25, 0, 0, 0, 0, time.UTC),
+				"active":  true,
+				"id":      int64(7),
+				"blob":    []byte("hello"),
+				"_id":     bson.ObjectIdHex("53cefc71b14ed89d84856287"),
+				"count":   int32(12),
+				"ratio":   0.5,
+			})
+		})
+		Convey("blank values should be kept as empty strings", func() {
+			document, err := tokensToBSON(fields, parsers, []string{"", ""})
+			So(err, ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"zip": "", "created": ""})
+		})
+		Convey("an error naming the field should be returned for values that "+
+			"do not parse as their declared type", func() {
+			document, err := tokensToBSON(fields, parsers,
+				[]string{"02134", "yesterday"})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "created")
+			So(document, ShouldNotBeNil)
+		})
+		Convey("values without a declared type should be guessed", func() {
+			document, err := tokensToBSON([]string{"a"}, nil,
+				[]string{"1", "x"})
+			So(err, ShouldBeNil)
+			So(document, ShouldResemble, bson.M{"a": 1, "field1": "x"})
+		})
+	})
+}
@@ -0,0 +1,98 @@
+package mongoimport
+
+import (
+	"encoding/json"
+	"fmt"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// writeConcernSpec is the JSON form of a write concern given to --writeConcern
+type writeConcernSpec struct {
+	W        interface{} `json:"w"`
+	J        bool        `json:"j"`
+	FSync    bool        `json:"fsync"`
+	WTimeout int         `json:"wtimeout"`
+}
+
+// parseWriteConcern parses the value of --writeConcern: the number of servers
+// that must acknowledge each write, a write mode such as "majority" or a JSON
+// document such as {"w": "majority", "j": true, "wtimeout": 5000}. Following
+// mgo's convention, a nil result means writes are not acknowledged at all.
+func parseWriteConcern(value string) (*mgo.Safe, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, fmt.Errorf("write concern can not be empty")
+	}
+	spec := writeConcernSpec{W: value}
+	if strings.HasPrefix(value, "{") {
+		spec = writeConcernSpec{}
+		if err := json.Unmarshal([]byte(value), &spec); err != nil {
+			return nil, fmt.Errorf("bad write concern document: %v", err)
+		}
+	}
+	if spec.WTimeout < 0 {
+		return nil, fmt.Errorf("write concern timeout can not be negative")
+	}
+
+	safe := &mgo.Safe{J: spec.J, FSync: spec.FSync, WTimeout: spec.WTimeout}
+	switch w := spec.W.(type) {
+	case nil:
+	case float64:
+		if w < 0 || w != math.Trunc(w) {
+			return nil, fmt.Errorf("bad write concern 'w' value: %v", w)
+		}
+		safe.W = int(w)
+	case string:
+		if n, err := strconv.Atoi(w); err == nil {
+			if n < 0 {
+				return nil, fmt.Errorf("bad write concern 'w' value: %v", w)
+			}
+			safe.W = n
+		} else if w != "" {
+			safe.WMode = w
+		}
+	default:
+		return nil, fmt.Errorf("write concern 'w' must be a number or a string")
+	}
+	if spec.W != nil && safe.W == 0 && safe.WMode == "" {
+		if safe.J || safe.FSync {
+			return nil, fmt.Errorf("unacknowledged writes can not be journaled " +
+				"or synced")
+		}
+		return nil, nil
+	}
+	return safe, nil
+}
+
+// writeConcernDocument returns the writeConcern field of a write command for
+// the given session safety mode, or nil if the server's default applies
+func writeConcernDocument(safe *mgo.Safe) bson.D {
+	if safe == nil {
+		return bson.D{{Name: "w", Value: 0}}
+	}
+	var writeConcern bson.D
+	if safe.WMode != "" {
+		writeConcern = append(writeConcern, bson.DocElem{Name: "w",
+			Value: safe.WMode})
+	} else if safe.W > 0 {
+		writeConcern = append(writeConcern, bson.DocElem{Name: "w",
+			Value: safe.W})
+	}
+	if safe.J {
+		writeConcern = append(writeConcern, bson.DocElem{Name: "j",
+			Value: true})
+	}
+	if safe.FSync {
+		writeConcern = append(writeConcern, bson.DocElem{Name: "fsync",
+			Value: true})
+	}
+	if safe.WTimeout > 0 {
+		writeConcern = append(writeConcern, bson.DocElem{Name: "wtimeout",
+			Value: safe.WTimeout})
+	}
+	return writeConcern
+}
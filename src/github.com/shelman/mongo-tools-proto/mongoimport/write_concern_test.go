@@ -0,0 +1,77 @@
+package mongoimport
+
+import (
+	. "github.com/smartystreets/goconvey/convey"
+	"labix.org/v2/mgo"
+	"labix.org/v2/mgo/bson"
+	"testing"
+)
+
+func TestParseWriteConcern(t *testing.T) {
+	Convey("Given a write concern, on calling parseWriteConcern", t, func() {
+		Convey("a number should set the number of acknowledging servers",
+			func() {
+				safe, err := parseWriteConcern("2")
+				So(err, ShouldBeNil)
+				So(safe, ShouldResemble, &mgo.Safe{W: 2})
+			})
+		Convey("any other string should set the write mode", func() {
+			safe, err := parseWriteConcern("majority")
+			So(err, ShouldBeNil)
+			So(safe, ShouldResemble, &mgo.Safe{WMode: "majority"})
+		})
+		Convey("a document should set all of its fields", func() {
+			safe, err := parseWriteConcern(`{"w": "majority", "j": true, ` +
+				`"wtimeout": 5000}`)
+			So(err, ShouldBeNil)
+			So(safe, ShouldResemble, &mgo.Safe{WMode: "majority", J: true,
+				WTimeout: 5000})
+			safe, err = parseWriteConcern(`{"w": 3}`)
+			So(err, ShouldBeNil)
+			So(safe, ShouldResemble, &mgo.Safe{W: 3})
+			safe, err = parseWriteConcern(`{"j": true}`)
+			So(err, ShouldBeNil)
+			So(safe, ShouldResemble, &mgo.Safe{J: true})
+		})
+		Convey("a 'w' of 0 should make writes unacknowledged", func() {
+			safe, err := parseWriteConcern("0")
+			So(err, ShouldBeNil)
+			So(safe, ShouldBeNil)
+			safe, err = parseWriteConcern(`{"w": 0}`)
+			So(err, ShouldBeNil)
+			So(safe, ShouldBeNil)
+			_, err = parseWriteConcern(`{"w": 0, "j": true}`)
+			So(err, ShouldNotBeNil)
+		})
+		Convey("bad values should cause an error", func() {
+			for _, value := range []string{"", "-1", `{"w": 1.5}`,
+				`{"w": true}`, `{"wtimeout": -1}`, `{w: 1}`} {
+				_, err := parseWriteConcern(value)
+				So(err, ShouldNotBeNil)
+			}
+		})
+	})
+}
+
+func TestWriteConcernDocument(t *testing.T) {
+	Convey("Given a session safety mode, on calling writeConcernDocument", t,
+		func() {
+			Convey("unacknowledged writes should have a 'w' of 0", func() {
+				So(writeConcernDocument(nil), ShouldResemble,
+					bson.D{{Name: "w", Value: 0}})
+			})
+			Convey("the server's default should be left alone", func() {
+				So(writeConcernDocument(&mgo.Safe{}), ShouldBeNil)
+			})
+			Convey("all set fields should be included", func() {
+				So(writeConcernDocument(&mgo.Safe{WMode: "majority", W: 2,
+					J: true, WTimeout: 100}), ShouldResemble, bson.D{
+					{Name: "w", Value: "majority"},
+					{Name: "j", Value: true},
+					{Name: "wtimeout", Value: 100},
+				})
+				So(writeConcernDocument(&mgo.Safe{W: 2}), ShouldResemble,
+					bson.D{{Name: "w", Value: 2}})
+			})
+		})
+}